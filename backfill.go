@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/backfill"
+)
+
+type backfillSummary struct {
+	commandSummary
+	backfill.Result
+}
+
+// runBackfill loads historical measurements of a date range into the history collection.
+func runBackfill(args []string) int {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	s, err := registerSettings(fs, args)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	var (
+		from      = fs.String("from", "", "Start of the range as date (2006-01-02) or RFC3339 timestamp")
+		to        = fs.String("to", "", "End of the range as date (2006-01-02) or RFC3339 timestamp, defaults to now")
+		country   = fs.String("country", "", "Country code to backfill (default all)")
+		parameter = fs.String("parameter", "", "Parameter to backfill (default all)")
+		window    = fs.Duration("window", 24*time.Hour, "Length of the date windows the range is split into")
+		rateLimit = fs.Float64("rate-limit", 1, "Maximum number of api requests per second")
+		restart   = fs.Bool("restart", false, "Ignore the checkpoint of a previous backfill of the same range")
+	)
+	if err := s.parse(fs, args); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	opts := backfill.Options{
+		APIEndpoint: s.API.Endpoint,
+		Window:      *window,
+		Country:     *country,
+		Parameter:   *parameter,
+		BatchSize:   s.API.BatchSize,
+		To:          time.Now().UTC(),
+	}
+	if opts.From, err = parseTime(*from); err != nil {
+		logger.Log("err", fmt.Errorf("invalid from: %w", err))
+		return exitInitError
+	}
+	if *to != "" {
+		if opts.To, err = parseTime(*to); err != nil {
+			logger.Log("err", fmt.Errorf("invalid to: %w", err))
+			return exitInitError
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	st, err := openStore(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer st.disconnect(s.Mongo.ConnectTimeout)
+	if st.history == nil {
+		logger.Log("err", fmt.Errorf("backfill is not supported by the %s storage backend", s.Storage.Backend))
+		return exitInitError
+	}
+	backfiller := backfill.NewBackfiller(newAPIClient(s, newArchiver(s)), *rateLimit, st.history, st.backfillCheckpoints)
+
+	logger.Log("info", fmt.Sprintf("Backfilling %s to %s", opts.From.Format(time.RFC3339), opts.To.Format(time.RFC3339)))
+	result, err := backfiller.Run(ctx, opts, *restart)
+	summary := backfillSummary{Result: result}
+	code := summary.finish(ctx, err)
+	return printSummary(summary, code)
+}
+
+// parseTime parses a date or an RFC3339 timestamp.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
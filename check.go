@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/source/sensorcommunity"
+)
+
+type checkResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// runCheck checks that mongo, the AQ api and the enabled providers are reachable and prints the
+// result as JSON.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	s, err := registerSettings(fs, args)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	if err := s.parse(fs, args); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Mongo.ConnectTimeout)
+	defer cancel()
+
+	results := append(checkStorage(ctx, s),
+		newCheckResult("api", checkURL(ctx, http.MethodGet, fmt.Sprintf("%s/v1/countries?limit=1", s.API.Endpoint))))
+	if s.SensorCommunity.Enabled {
+		// HEAD as the feed holds the readings of all sensors.
+		feedURL := sensorcommunity.NewSource(sensorcommunity.Options{Endpoint: s.SensorCommunity.Endpoint}).URL(sensorcommunity.Dataset, time.Time{})
+		results = append(results, newCheckResult(sensorcommunity.ID, checkURL(ctx, http.MethodHead, feedURL+"1")))
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(results)
+	for _, r := range results {
+		if r.Status != statusOK {
+			return exitCheckFailed
+		}
+	}
+	return exitOK
+}
+
+func newCheckResult(name string, err error) checkResult {
+	if err != nil {
+		return checkResult{Name: name, Status: statusFailed, Error: err.Error()}
+	}
+	return checkResult{Name: name, Status: statusOK}
+}
+
+// checkStorage checks the connection to the storage backend without changing its schema and
+// reports the indexes and tables that the first sync still has to create.
+func checkStorage(ctx context.Context, s *settings) []checkResult {
+	st, err := openExistingStore(ctx, s)
+	if err != nil {
+		return []checkResult{newCheckResult(s.Storage.Backend, err)}
+	}
+	defer st.disconnect(s.Mongo.ConnectTimeout)
+	missing, err := st.missingSchema(ctx)
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return []checkResult{newCheckResult(s.Storage.Backend, nil), newCheckResult("schema", err)}
+}
+
+// checkURL checks that a request of url succeeds.
+func checkURL(ctx context.Context, method string, url string) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runConfig prints the effective configuration after applying the config file, the environment
+// and the flags. Secrets are redacted.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintf(os.Stderr, "usage: aq-dbsync config print [flags]\n")
+		return exitInitError
+	}
+	args = args[1:]
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	s, err := registerSettings(fs, args)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	if err := s.parse(fs, args); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	out, err := s.Redacted().YAML()
+	if err != nil {
+		logger.Log("err", fmt.Errorf("error encoding config: %w", err))
+		return exitInitError
+	}
+	os.Stdout.Write(out)
+	return exitOK
+}
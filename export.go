@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/nhe23/aq-dbsync/pkg/export"
+)
+
+// runExport writes a dataset as CSV, NDJSON or Parquet to a file or stdout.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	s, err := registerSettings(fs, args)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	var (
+		dataset   = fs.String("dataset", export.Measurements, fmt.Sprintf("Dataset to export, one of %s", strings.Join(export.Datasets, ",")))
+		format    = fs.String("format", string(export.CSV), "Output format, csv, ndjson or parquet")
+		output    = fs.String("output", "-", "Output file, - writes to stdout")
+		country   = fs.String("country", "", "Country code to export (default all)")
+		city      = fs.String("city", "", "City to export (default all)")
+		parameter = fs.String("parameter", "", "Parameter of measurements and history to export (default all)")
+		from      = fs.String("from", "", "Start of the time range of measurements and history as date (2006-01-02) or RFC3339 timestamp")
+		to        = fs.String("to", "", "Exclusive end of the time range of measurements and history")
+		pageSize  = fs.Int("page-size", 1000, "Number of documents read at once")
+	)
+	if err := s.parse(fs, args); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	f, err := export.ParseFormat(*format)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	q := export.Query{Country: *country, City: *city, Parameter: *parameter}
+	if *from != "" {
+		if q.From, err = parseTime(*from); err != nil {
+			logger.Log("err", fmt.Errorf("invalid from: %w", err))
+			return exitInitError
+		}
+	}
+	if *to != "" {
+		if q.To, err = parseTime(*to); err != nil {
+			logger.Log("err", fmt.Errorf("invalid to: %w", err))
+			return exitInitError
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	st, err := openStore(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer st.disconnect(s.Mongo.ConnectTimeout)
+
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if *output != "-" {
+		file, err = os.Create(*output)
+		if err != nil {
+			logger.Log("err", fmt.Errorf("error creating output file: %w", err))
+			return exitInitError
+		}
+		w = file
+	}
+	src := export.Sources{Repos: st.repos, History: st.historyReader, PageSize: *pageSize}
+	rows, err := export.Export(ctx, src, *dataset, q, f, w)
+	// A failed close can leave a truncated file, so it fails the export.
+	if file != nil {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("error closing output file: %w", closeErr)
+		}
+	}
+	if err != nil {
+		logger.Log("err", err)
+		if ctx.Err() != nil {
+			return exitInterrupted
+		}
+		return exitSyncFailed
+	}
+	logger.Log("info", fmt.Sprintf("Exported %d rows of %s", rows, *dataset))
+	return exitOK
+}
@@ -4,37 +4,77 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/nhe23/aq-dbsync/pkg/airquality"
+	"github.com/nhe23/aq-dbsync/pkg/archive"
+	"github.com/nhe23/aq-dbsync/pkg/config"
 	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
-
-	"github.com/jasonlvhit/gocron"
+	"github.com/nhe23/aq-dbsync/pkg/lease"
+	"github.com/nhe23/aq-dbsync/pkg/rollup"
+	"github.com/nhe23/aq-dbsync/pkg/runs"
+	"github.com/nhe23/aq-dbsync/pkg/source"
+	"github.com/nhe23/aq-dbsync/pkg/source/openaq"
+	"github.com/nhe23/aq-dbsync/pkg/source/sensorcommunity"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"github.com/nhe23/aq-dbsync/pkg/storage/mongostore"
+	"github.com/nhe23/aq-dbsync/pkg/tracing"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var ctx = context.Background()
-
 const measurementsColName = "measurements"
 const citiesColName = "cities"
 const countriesColName = "countries"
+const historyColName = "measurements_history"
+const backfillCheckpointsColName = "backfill_checkpoints"
+const syncStateColName = "sync_state"
+const syncCheckpointsColName = "sync_checkpoints"
+const syncLeasesColName = "sync_leases"
+const rollupsHourlyColName = "rollups_hourly"
+const rollupsDailyColName = "rollups_daily"
+const migrationsColName = "schema_migrations"
+const syncRunsColName = "sync_runs"
+
+// kindCollections are the collections of the documents of each kind.
+var kindCollections = map[string]string{
+	source.Cities:    citiesColName,
+	source.Countries: countriesColName,
+	source.Locations: measurementsColName,
+}
 
 type dataProcessParams struct {
-	url          string
-	col          collection
-	callBackFunc func(url string, collection dataprocessor.DataAccessInterface) (int, error)
+	name string
+	// kind is the kind of the documents of the dataset.
+	kind        string
+	source      source.Source
+	repo        storage.Repository
+	processor   dataprocessor.DataProcessor
+	incremental bool
+	// staleAfter deletes the documents of the source not synced for this long after every sync if set.
+	staleAfter time.Duration
 }
 
 type collections struct {
 	measurementCol collection
 	citiesCol      collection
 	countriesCol   collection
+	historyCol     collection
+	syncStateCol   collection
+	checkpointsCol collection
+	leasesCol      collection
+	hourlyCol      collection
+	dailyCol       collection
+	runsCol        collection
 }
 
 type collection struct {
@@ -42,96 +82,432 @@ type collection struct {
 	col  *mongo.Collection
 }
 
+// settings holds the configuration shared by all commands.
+type settings struct {
+	*config.Config
+	configFile string
+	only       string
+}
+
 var logger log.Logger
 
-const defaultDb = "mongodb://localhost:27018"
+// configEnv names the environment variable with the path of the config file.
+const configEnv = config.EnvPrefix + "CONFIG"
+
+// datasetNames lists all datasets in the order they are synced.
+var datasetNames = append(source.Names(openaq.Datasets), source.Names(sensorcommunity.Datasets)...)
+
+// Exit codes of the service.
+const (
+	exitOK          = 0
+	exitInitError   = 1
+	exitInterrupted = 2
+	exitSyncFailed  = 3
+	exitCheckFailed = 4
+)
+
+const usage = `Usage: aq-dbsync <command> [flags]
+
+Commands:
+  serve     Sync all datasets on their schedules until stopped (default)
+  serve-api Serve the current air quality from the database as HTTP/JSON read api
+  sync      Sync datasets once and print a JSON summary
+  backfill  Load historical measurements of a date range
+  replay    Ingest recorded api pages from JSON files or the archive without network access
+  migrate   Apply pending migrations of the stored documents
+  rollup    Recompute the hourly and daily rollups of a date range from the history
+  export    Write a dataset as CSV, NDJSON or Parquet
+  check     Check the connection to mongo and the AQ api
+  config    Print the effective configuration with secrets redacted (config print)
+  help      Show this help
+
+Run "aq-dbsync <command> -h" for the flags of a command.
+
+Settings are read from the YAML file given by -config or $AQ_CONFIG, then overridden by
+AQ_ environment variables (e.g. AQ_MONGO_URI, AQ_DATASETS_CITIES_SCHEDULE) and flags.
+`
+
+// collectionIndex is an index of a collection.
+type collectionIndex struct {
+	col   collection
+	model mongo.IndexModel
+}
+
+// indexes returns the indexes initCollections creates, without the retention of the runs.
+func (cols collections) indexes() []collectionIndex {
+	indexes := []collectionIndex{
+		{cols.measurementCol, mongo.IndexModel{Keys: bson.D{{Key: "location", Value: 1}}, Options: options.Index().SetUnique(false)}},
+		// Serves the queries of the read api for stations within bounds.
+		{cols.measurementCol, mongo.IndexModel{Keys: bson.D{{Key: "coordinates.latitude", Value: 1}, {Key: "coordinates.longitude", Value: 1}}}},
+		{cols.historyCol, mongo.IndexModel{
+			Keys:    bson.D{{Key: "location", Value: 1}, {Key: "parameter", Value: 1}, {Key: "date", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+	}
+	rollupKeys := bson.D{}
+	for _, key := range rollup.Keys {
+		rollupKeys = append(rollupKeys, bson.E{Key: key, Value: 1})
+	}
+	for _, col := range []collection{cols.hourlyCol, cols.dailyCol} {
+		indexes = append(indexes, collectionIndex{col, mongo.IndexModel{Keys: rollupKeys, Options: options.Index().SetUnique(true)}})
+	}
+	return indexes
+}
 
-func initCollections(mongoURI string, dbName string) (collections, error) {
+// initCollections connects to mongo and, if createSchema is set, applies the validators and
+// creates the indexes of the collections.
+func initCollections(ctx context.Context, clientOpts *options.ClientOptions, s *settings, createSchema bool) (*mongo.Client, collections, error) {
 	var cols collections
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
-		return cols, err
+		return nil, cols, err
 	}
 
 	err = client.Ping(ctx, nil)
 	if err != nil {
-		return cols, err
+		return client, cols, err
 	}
 
-	db := client.Database(dbName)
+	db := client.Database(s.Mongo.Database)
 	cols.countriesCol.name = countriesColName
 	cols.measurementCol.name = measurementsColName
 	cols.citiesCol.name = citiesColName
+	cols.historyCol.name = historyColName
+	cols.syncStateCol.name = syncStateColName
+	cols.checkpointsCol.name = syncCheckpointsColName
+	cols.leasesCol.name = syncLeasesColName
+	cols.hourlyCol.name = rollupsHourlyColName
+	cols.dailyCol.name = rollupsDailyColName
+	cols.runsCol.name = syncRunsColName
 
 	cols.countriesCol.col = db.Collection(cols.countriesCol.name)
 	cols.citiesCol.col = db.Collection(cols.citiesCol.name)
 	cols.measurementCol.col = db.Collection(cols.measurementCol.name)
-	_, err = cols.measurementCol.col.Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{
-			Keys: bson.M{
-				"location": 1,
-			},
-			Options: options.Index().SetUnique(false),
-		},
-	)
-	if err != nil {
-		return cols, err
+	cols.historyCol.col = db.Collection(cols.historyCol.name)
+	cols.syncStateCol.col = db.Collection(cols.syncStateCol.name)
+	cols.checkpointsCol.col = db.Collection(cols.checkpointsCol.name)
+	cols.leasesCol.col = db.Collection(cols.leasesCol.name)
+	cols.hourlyCol.col = db.Collection(cols.hourlyCol.name)
+	cols.dailyCol.col = db.Collection(cols.dailyCol.name)
+	cols.runsCol.col = db.Collection(cols.runsCol.name)
+	if !createSchema {
+		return client, cols, nil
+	}
+	if validation := s.Mongo.Validation; validation.Enabled {
+		for name, doc := range map[string]interface{}{
+			citiesColName:       storage.City{},
+			countriesColName:    storage.Country{},
+			measurementsColName: storage.Location{},
+		} {
+			if err := mongostore.ApplyValidator(ctx, db, name, doc, validation.Level, validation.Action); err != nil {
+				return client, cols, fmt.Errorf("error applying the validator of %s: %w", name, err)
+			}
+		}
 	}
-	return cols, nil
+	for _, index := range cols.indexes() {
+		if _, err := index.col.col.Indexes().CreateOne(ctx, index.model); err != nil {
+			return client, cols, err
+		}
+	}
+	if s.Runs.Enabled {
+		if err := runs.EnsureRetention(ctx, db, cols.runsCol.name, s.Runs.Retention); err != nil {
+			return client, cols, err
+		}
+	}
+	return client, cols, nil
 }
 
 func main() {
-	var (
-		fs             = flag.NewFlagSet("aqDataCrawler", flag.ExitOnError)
-		aqAPI          = fs.String("aq-apiendpoint", "https://api.openaq.org", "The latest URL of the AQ api.")
-		batchSize      = fs.Int("batch-size", 1000, "Number of results per request")
-		httpRetryCount = fs.Int("http-retry-count", 3, "Number maximum retries of http requests")
-		dbName         = fs.String("db-name", "AQ_DB", "Name of used mongo db")
-		schedDuration  = fs.Uint64("scheduler-seconds", 3600, "Scheduler interval in seoconds")
-	)
-	mongoURI := os.Getenv("mongodb")
-	if mongoURI == "" {
-		mongoURI = defaultDb
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
+
 	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	logger = level.NewFilter(logger, level.AllowInfo())
 	logger = log.With(logger, "TS:", log.DefaultTimestamp, "caller", log.DefaultCaller)
 
-	logger.Log("info", "Starting service")
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = *httpRetryCount
-	retryClient.RetryWaitMin = 5 * time.Second
-	retryClient.Logger = logger.Log()
-	httpClient := retryClient.StandardClient()
-	cols, err := initCollections(mongoURI, *dbName)
+	switch cmd {
+	case "serve":
+		return runServe(args)
+	case "serve-api":
+		return runServeAPI(args)
+	case "sync":
+		return runSync(args)
+	case "backfill":
+		return runBackfill(args)
+	case "replay":
+		return runReplay(args)
+	case "migrate":
+		return runMigrate(args)
+	case "rollup":
+		return runRollup(args)
+	case "export":
+		return runExport(args)
+	case "check":
+		return runCheck(args)
+	case "config":
+		return runConfig(args)
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		return exitInitError
+	}
+}
+
+// registerSettings loads the config file and the environment and registers the flags shared by
+// all commands, which override them.
+func registerSettings(fs *flag.FlagSet, args []string) (*settings, error) {
+	s := &settings{configFile: configFileArg(args)}
+	cfg, err := config.Load(s.configFile, datasetNames, os.LookupEnv)
 	if err != nil {
-		logger.Log("err", "Error initializing mongo collections")
-		logger.Log("err", err)
-		os.Exit(1)
+		return nil, err
+	}
+	s.Config = cfg
+	fs.StringVar(&s.configFile, "config", s.configFile, fmt.Sprintf("Path of the YAML config file, defaults to $%s", configEnv))
+	fs.StringVar(&s.only, "only", "", "Comma separated list of datasets to sync (default all enabled)")
+	fs.StringVar(&cfg.API.Endpoint, "aq-apiendpoint", cfg.API.Endpoint, "The latest URL of the AQ api.")
+	fs.IntVar(&cfg.API.BatchSize, "batch-size", cfg.API.BatchSize, "Number of results per request")
+	fs.IntVar(&cfg.API.RetryCount, "http-retry-count", cfg.API.RetryCount, "Number maximum retries of http requests")
+	fs.DurationVar(&cfg.API.RetryWaitMin, "http-retry-wait-min", cfg.API.RetryWaitMin, "Minimum wait time between retries of http requests")
+	fs.DurationVar(&cfg.API.RetryWaitMax, "http-retry-wait-max", cfg.API.RetryWaitMax, "Maximum wait time between retries of http requests")
+	fs.BoolVar(&cfg.SensorCommunity.Enabled, "sensor-community", cfg.SensorCommunity.Enabled, "Sync the low-cost sensors of Sensor.Community")
+	fs.StringVar(&cfg.SensorCommunity.Endpoint, "sensor-community-endpoint", cfg.SensorCommunity.Endpoint, "Base URL of the Sensor.Community data feed")
+	fs.DurationVar(&cfg.SensorCommunity.StaleAfter, "sensor-community-stale-after", cfg.SensorCommunity.StaleAfter, "Delete Sensor.Community sensors that were not in the feed for this long")
+	fs.StringVar(&cfg.Storage.Backend, "storage", cfg.Storage.Backend, "Storage backend, mongo or sqlite")
+	fs.StringVar(&cfg.Storage.SQLitePath, "sqlite-path", cfg.Storage.SQLitePath, "Path of the sqlite database file")
+	fs.StringVar(&cfg.Mongo.URI, "mongo-uri", cfg.Mongo.URI, "URI of the mongo db, prefer the config file or $AQ_MONGO_URI for credentials")
+	fs.StringVar(&cfg.Mongo.URIFile, "mongo-uri-file", cfg.Mongo.URIFile, "Path of a file containing the mongo uri, overrides mongo-uri")
+	fs.StringVar(&cfg.Mongo.Database, "db-name", cfg.Mongo.Database, "Name of used mongo db")
+	fs.BoolVar(&cfg.Mongo.Validation.Enabled, "mongo-validation", cfg.Mongo.Validation.Enabled, "Validate the documents of the datasets with $jsonSchema validators")
+	fs.StringVar(&cfg.Mongo.Validation.Level, "mongo-validation-level", cfg.Mongo.Validation.Level, "Validation level, off, strict or moderate")
+	fs.StringVar(&cfg.Mongo.Validation.Action, "mongo-validation-action", cfg.Mongo.Validation.Action, "Validation action, error to reject invalid documents or warn")
+	fs.DurationVar(&cfg.Mongo.ConnectTimeout, "connect-timeout", cfg.Mongo.ConnectTimeout, "Timeout for connecting to and disconnecting from the storage")
+	fs.StringVar(&cfg.Sync.DefaultSchedule, "default-schedule", cfg.Sync.DefaultSchedule, "Cron expression or interval for datasets without a schedule")
+	fs.Func("scheduler-seconds", "Default scheduler interval in seconds, deprecated in favour of default-schedule", func(value string) error {
+		seconds, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		cfg.Sync.DefaultSchedule = fmt.Sprintf("@every %ds", seconds)
+		return nil
+	})
+	fs.DurationVar(&cfg.Sync.RunTimeout, "run-timeout", cfg.Sync.RunTimeout, "Default maximum duration of a single dataset sync")
+	fs.BoolVar(&cfg.Sync.Incremental, "incremental", cfg.Sync.Incremental, "Only request measurements newer than the last synced ones")
+	fs.StringVar(&cfg.Sync.IncrementalParam, "incremental-param", cfg.Sync.IncrementalParam, "Query parameter used to request measurements newer than the watermark")
+	fs.DurationVar(&cfg.Sync.WatermarkOverlap, "watermark-overlap", cfg.Sync.WatermarkOverlap, "Overlap of incremental syncs with the previous sync")
+	fs.DurationVar(&cfg.Sync.FullSyncInterval, "full-sync-interval", cfg.Sync.FullSyncInterval, "Interval of full syncs that also delete stale measurements")
+	fs.DurationVar(&cfg.Sync.CheckpointMaxAge, "checkpoint-max-age", cfg.Sync.CheckpointMaxAge, "Maximum age of an unfinished sync that is resumed, 0 disables resuming")
+	fs.BoolVar(&cfg.Lease.Enabled, "leader-election", cfg.Lease.Enabled, "Hold a lease in mongo while syncing a dataset so replicas do not sync concurrently")
+	fs.DurationVar(&cfg.Lease.TTL, "lease-ttl", cfg.Lease.TTL, "Time after which the lease of a dead replica expires")
+	fs.StringVar(&cfg.Lease.Owner, "lease-owner", cfg.Lease.Owner, "ID of this replica in the leases")
+	fs.BoolVar(&cfg.Archive.Enabled, "archive", cfg.Archive.Enabled, "Archive the raw api responses")
+	fs.StringVar(&cfg.Archive.Dir, "archive-dir", cfg.Archive.Dir, "Directory of the archive of api responses")
+	fs.BoolVar(&cfg.Rollups.Enabled, "rollups", cfg.Rollups.Enabled, "Aggregate the synced measurements per hour and day")
+	fs.BoolVar(&cfg.Migrations.OnStartup, "migrate-on-startup", cfg.Migrations.OnStartup, "Apply pending migrations of the stored documents before syncing")
+	fs.BoolVar(&cfg.AirQuality.Enabled, "air-quality", cfg.AirQuality.Enabled, "Store the current air quality on cities and countries after syncing measurements")
+	fs.BoolVar(&cfg.Runs.Enabled, "runs", cfg.Runs.Enabled, "Keep the history of sync runs in the database")
+	fs.DurationVar(&cfg.Runs.Retention, "runs-retention", cfg.Runs.Retention, "Time after which a run is removed from the history")
+	fs.BoolVar(&cfg.Admin.Enabled, "admin", cfg.Admin.Enabled, "Serve the admin api to trigger, pause and inspect syncs")
+	fs.StringVar(&cfg.Admin.Addr, "admin-addr", cfg.Admin.Addr, "Address of the admin api")
+	fs.StringVar(&cfg.Admin.TokenFile, "admin-token-file", cfg.Admin.TokenFile, "File containing the bearer token of the admin api, or set $AQ_ADMIN_TOKEN")
+	fs.BoolVar(&cfg.Stream.Enabled, "stream", cfg.Stream.Enabled, "Serve a Server-Sent Events stream of the locations changed by each page")
+	fs.StringVar(&cfg.Stream.Addr, "stream-addr", cfg.Stream.Addr, "Address of the stream of changed locations")
+	fs.BoolVar(&cfg.Tracing.Enabled, "tracing", cfg.Tracing.Enabled, "Export OpenTelemetry spans of the syncs")
+	fs.StringVar(&cfg.Tracing.Exporter, "tracing-exporter", cfg.Tracing.Exporter, "Exporter of spans, otlp, stdout or file")
+	fs.StringVar(&cfg.Tracing.Endpoint, "tracing-endpoint", cfg.Tracing.Endpoint, "host:port of the OTLP/HTTP collector, defaults to $OTEL_EXPORTER_OTLP_ENDPOINT")
+	fs.StringVar(&cfg.Tracing.File, "tracing-file", cfg.Tracing.File, "Path of the file the file exporter appends the spans to")
+	fs.DurationVar(&cfg.AirQuality.MaxAge, "air-quality-max-age", cfg.AirQuality.MaxAge, "Age after which a measurement no longer counts for the current air quality")
+	for _, name := range datasetNames {
+		datasetFlags(fs, name, cfg.Datasets[name])
 	}
+	return s, nil
+}
 
-	measurementsURL := fmt.Sprintf("%s/v1/latest?limit=%d&page=", *aqAPI, *batchSize)
-	citiesURL := fmt.Sprintf("%s/v1/cities?limit=%d&page=", *aqAPI, *batchSize)
-	countriesURL := fmt.Sprintf("%s/v1/countries?limit=%d&page=", *aqAPI, *batchSize)
+// datasetFlags registers the flags of a dataset.
+func datasetFlags(fs *flag.FlagSet, name string, d *config.Dataset) {
+	fs.StringVar(&d.Schedule, name+"-schedule", d.Schedule, fmt.Sprintf("Cron expression or interval for syncing %s, defaults to default-schedule", name))
+	fs.DurationVar(&d.Jitter, name+"-jitter", d.Jitter, fmt.Sprintf("Maximum random delay before syncing %s", name))
+	fs.DurationVar(&d.Timeout, name+"-timeout", d.Timeout, fmt.Sprintf("Maximum duration of a %s sync, defaults to run-timeout", name))
+}
 
-	dataProcessor := dataprocessor.NewDataProcessor(httpClient, *batchSize)
-	dataParams := make([]dataProcessParams, 0)
-	dataParams = append(dataParams, dataProcessParams{citiesURL, cols.citiesCol, dataProcessor.ProcessCities})
-	dataParams = append(dataParams, dataProcessParams{countriesURL, cols.countriesCol, dataProcessor.ProcessCountries})
-	dataParams = append(dataParams, dataProcessParams{measurementsURL, cols.measurementCol, dataProcessor.ProcessMeasurements})
-	for true {
-		gocron.Every(*schedDuration).Seconds().From(gocron.NextTick()).Do(processAllData, dataProcessor, dataParams)
-		<-gocron.Start()
+// configFileArg returns the config file given on the command line, which has to be known before
+// the flags are registered, or in the environment.
+func configFileArg(args []string) string {
+	for i, arg := range args {
+		switch {
+		case arg == "-config" || arg == "--config":
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+		case strings.HasPrefix(arg, "-config="):
+			return strings.TrimPrefix(arg, "-config=")
+		case strings.HasPrefix(arg, "--config="):
+			return strings.TrimPrefix(arg, "--config=")
+		}
 	}
+	return os.Getenv(configEnv)
 }
 
-func processAllData(d dataprocessor.DataProcessor, dataParams []dataProcessParams) {
-	for _, data := range dataParams {
-		logger.Log("info", fmt.Sprintf("Processing data for %s", data.url))
-		err := d.ProcessData(data.url, data.col.col, data.callBackFunc)
-		if err != nil {
-			logger.Log("error", fmt.Errorf("error processing data for url %s: %w", data.url, err))
+// parse parses the command line and validates the settings.
+func (s *settings) parse(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	if err := s.Validate(datasetNames); err != nil {
+		return err
+	}
+	_, err := s.selectedDatasets()
+	return err
+}
+
+// selectedDatasets returns the dataset names selected by the only flag or all enabled datasets
+// of enabled providers.
+func (s *settings) selectedDatasets() (map[string]bool, error) {
+	selected := make(map[string]bool)
+	if s.only == "" {
+		for _, name := range datasetNames {
+			selected[name] = s.Datasets[name].Enabled && s.providerEnabled(name)
+		}
+		return selected, nil
+	}
+	for _, name := range strings.Split(s.only, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := s.Datasets[name]; !ok {
+			return nil, fmt.Errorf("unknown dataset %q, valid datasets are %s", name, strings.Join(datasetNames, ","))
+		}
+		if !s.providerEnabled(name) {
+			return nil, fmt.Errorf("dataset %s requires sensorCommunity.enabled", name)
+		}
+		selected[name] = true
+	}
+	return selected, nil
+}
+
+// providerEnabled reports whether the provider of a dataset is enabled. OpenAQ is always enabled.
+func (s *settings) providerEnabled(name string) bool {
+	return name != sensorcommunity.Dataset || s.SensorCommunity.Enabled
+}
+
+// newArchiver returns the archiver of api responses or nil if archiving is disabled.
+func newArchiver(s *settings) *archive.Archiver {
+	if !s.Archive.Enabled {
+		return nil
+	}
+	retention := archive.Retention{MaxAge: s.Archive.MaxAge, MaxBytes: int64(s.Archive.MaxSizeMb) << 20}
+	return archive.NewArchiver(archive.NewDirStore(s.Archive.Dir), retention, logger)
+}
+
+// newAPIClient returns the http client for the AQ api which archives responses if archiver is not nil.
+func newAPIClient(s *settings, archiver *archive.Archiver) *http.Client {
+	client := newHTTPClient(s).StandardClient()
+	if archiver != nil {
+		client.Transport = archiver.Transport(client.Transport)
+	}
+	return client
+}
+
+func newHTTPClient(s *settings) *retryablehttp.Client {
+	retryClient := retryablehttp.NewClient()
+	retryClient.RetryMax = s.API.RetryCount
+	retryClient.RetryWaitMin = s.API.RetryWaitMin
+	retryClient.RetryWaitMax = s.API.RetryWaitMax
+	retryClient.Logger = logger.Log()
+	tracing.RecordRetries(retryClient)
+	return retryClient
+}
+
+// tracingShutdownTimeout limits the time spent exporting the pending spans on exit.
+const tracingShutdownTimeout = 10 * time.Second
+
+// startTracing installs the exporter of spans if tracing is enabled and returns a function that
+// flushes the pending spans.
+func startTracing(ctx context.Context, s *settings) (func(), error) {
+	if !s.Tracing.Enabled {
+		return func() {}, nil
+	}
+	shutdown, err := tracing.Setup(ctx, tracing.Options{
+		ServiceName: "aq-dbsync",
+		Exporter:    s.Tracing.Exporter,
+		Endpoint:    s.Tracing.Endpoint,
+		Insecure:    s.Tracing.Insecure,
+		File:        s.Tracing.File,
+		SampleRatio: s.Tracing.SampleRatio,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error setting up tracing: %w", err)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			logger.Log("error", fmt.Errorf("error exporting spans: %w", err))
 		}
+	}, nil
+}
+
+// newDataParams builds the selected datasets in sync order.
+func newDataParams(s *settings, st *store) (*syncer, []dataProcessParams, error) {
+	selected, err := s.selectedDatasets()
+	if err != nil {
+		return nil, nil, err
+	}
+	archiver := newArchiver(s)
+	sources := []source.Source{
+		openaq.NewSource(openaq.Options{
+			Endpoint:         s.API.Endpoint,
+			Client:           newAPIClient(s, archiver),
+			PageSize:         s.API.BatchSize,
+			IncrementalParam: s.Sync.IncrementalParam,
+		}),
+	}
+	if s.SensorCommunity.Enabled {
+		sources = append(sources, sensorcommunity.NewSource(sensorcommunity.Options{
+			Endpoint:   s.SensorCommunity.Endpoint,
+			Client:     newAPIClient(s, archiver),
+			StaleAfter: s.SensorCommunity.StaleAfter,
+		}))
+	}
+	syncer := &syncer{
+		state:    st.state,
+		settings: s,
+		archiver: archiver,
+		runs:     st.runs,
+	}
+	measurementsRepo := st.repos[measurementsColName]
+	if s.Rollups.Enabled {
+		syncer.rollups = rollup.NewRollupper(st.history, st.historyReader, st.rollups)
+		measurementsRepo = syncer.rollups.Repository(measurementsRepo)
+	}
+	if s.AirQuality.Enabled {
+		cities, citiesOK := st.repos[citiesColName].(storage.AirQualityWriter)
+		countries, countriesOK := st.repos[countriesColName].(storage.AirQualityWriter)
+		if !citiesOK || !countriesOK {
+			return nil, nil, fmt.Errorf("the %s storage backend does not support the air quality", s.Storage.Backend)
+		}
+		syncer.airQuality = airquality.NewUpdater(st.repos[measurementsColName], cities, countries, s.AirQuality.MaxAge)
+	}
+	// The documents of all sources are stored in the repository of their kind.
+	repos := map[string]storage.Repository{
+		source.Cities:    st.repos[citiesColName],
+		source.Countries: st.repos[countriesColName],
+		source.Locations: measurementsRepo,
+	}
+	dataParams := make([]dataProcessParams, 0)
+	for _, src := range sources {
+		processor := dataprocessor.NewDataProcessor(src.PageSize(), dataprocessor.WithCheckpoints(st.checkpoints, s.Sync.CheckpointMaxAge))
+		for _, d := range src.Datasets() {
+			if selected[d.Name] {
+				dataParams = append(dataParams, dataProcessParams{d.Name, d.Kind, src, repos[d.Kind], processor, d.Incremental, d.StaleAfter})
+			}
+		}
+	}
+	if s.Lease.Enabled {
+		syncer.lock = lease.NewLock(st.leases, s.Lease.Owner, s.Lease.TTL)
 	}
+	return syncer, dataParams, nil
 }
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nhe23/aq-dbsync/pkg/admin"
+	"github.com/nhe23/aq-dbsync/pkg/config"
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"github.com/nhe23/aq-dbsync/pkg/dryrun"
+	"github.com/nhe23/aq-dbsync/pkg/runs"
+	"github.com/nhe23/aq-dbsync/pkg/scheduler"
+	"github.com/nhe23/aq-dbsync/pkg/source/openaq"
+	"github.com/nhe23/aq-dbsync/pkg/source/sensorcommunity"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_settings_selectedDatasets(t *testing.T) {
+	tests := []struct {
+		name            string
+		only            string
+		disabled        string
+		sensorCommunity bool
+		want            map[string]bool
+		wantErr         bool
+	}{
+		{"all", "", "", false, map[string]bool{"cities": true, "countries": true, "measurements": true, "sensorcommunity": false}, false},
+		{"enabled", "", "countries", false, map[string]bool{"cities": true, "countries": false, "measurements": true, "sensorcommunity": false}, false},
+		{"some", "cities, measurements", "", false, map[string]bool{"cities": true, "measurements": true}, false},
+		{"unknown", "cities,weather", "", false, nil, true},
+		{"sensor community", "", "", true, map[string]bool{"cities": true, "countries": true, "measurements": true, "sensorcommunity": true}, false},
+		{"sensor community disabled", "sensorcommunity", "", false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := registerSettings(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.only = tt.only
+			s.SensorCommunity.Enabled = tt.sensorCommunity
+			if tt.disabled != "" {
+				s.Datasets[tt.disabled].Enabled = false
+			}
+			got, err := s.selectedDatasets()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("settings.selectedDatasets() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("settings.selectedDatasets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_configFileArg(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"none", []string{"-only", "cities"}, ""},
+		{"separate", []string{"-only", "cities", "-config", "a.yaml"}, "a.yaml"},
+		{"equals", []string{"--config=b.yaml"}, "b.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(configEnv, "")
+			if got := configFileArg(tt.args); got != tt.want {
+				t.Errorf("configFileArg() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_syncSummary_finish(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []string
+		wantStatus string
+		wantCode   int
+	}{
+		{"ok", []string{statusOK, statusOK}, statusOK, exitOK},
+		{"locked", []string{statusOK, statusLocked}, statusOK, exitOK},
+		{"failed", []string{statusOK, statusFailed}, statusFailed, exitSyncFailed},
+		{"timeout", []string{statusTimeout, statusOK}, statusFailed, exitSyncFailed},
+		{"interrupted", []string{statusFailed, statusInterrupted, statusSkipped}, statusInterrupted, exitInterrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s syncSummary
+			for _, status := range tt.statuses {
+				s.Datasets = append(s.Datasets, runs.Dataset{Status: status})
+			}
+			if got := s.finish(); got != tt.wantCode {
+				t.Errorf("syncSummary.finish() = %v, want %v", got, tt.wantCode)
+			}
+			if s.Status != tt.wantStatus {
+				t.Errorf("syncSummary.Status = %v, want %v", s.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func Test_commandSummary_finish(t *testing.T) {
+	logger = log.NewNopLogger()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		want     commandSummary
+		wantCode int
+	}{
+		{"ok", context.Background(), nil, commandSummary{Status: statusOK}, exitOK},
+		{"failed", context.Background(), fmt.Errorf("VERY BAD ERROR"), commandSummary{Status: statusFailed, Error: "VERY BAD ERROR"}, exitSyncFailed},
+		{"interrupted", cancelled, context.Canceled, commandSummary{Status: statusInterrupted, Error: "context canceled"}, exitInterrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got commandSummary
+			if code := got.finish(tt.ctx, tt.err); got != tt.want || code != tt.wantCode {
+				t.Errorf("commandSummary.finish() = %+v, %v, want %+v, %v", got, code, tt.want, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_datasetStatus(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want string
+	}{
+		{"ok", context.Background(), nil, statusOK},
+		{"locked", context.Background(), fmt.Errorf("not syncing cities: %w", errLeaseHeld), statusLocked},
+		{"interrupted", cancelled, fmt.Errorf("stopped: %w", context.Canceled), statusInterrupted},
+		{"timeout", expired, fmt.Errorf("stopped: %w", context.DeadlineExceeded), statusTimeout},
+		{"failed", context.Background(), fmt.Errorf("VERY BAD ERROR"), statusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := datasetStatus(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("datasetStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkStorage(t *testing.T) {
+	s := testSettings(t)
+	ctx := context.Background()
+	// The check does not create the database.
+	if got := checkStorage(ctx, s); len(got) != 1 || got[0].Status != statusFailed {
+		t.Errorf("checkStorage() of a missing database = %+v", got)
+	}
+	st, err := openStore(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.disconnect(time.Second)
+	if got := checkStorage(ctx, s); len(got) != 2 || got[0].Status != statusOK || got[1].Status != statusOK {
+		t.Errorf("checkStorage() = %+v", got)
+	}
+}
+
+func Test_indexName(t *testing.T) {
+	keys := bson.D{{Key: "coordinates.latitude", Value: 1}, {Key: "coordinates.longitude", Value: 1}}
+	if got := indexName(keys); got != "coordinates.latitude_1_coordinates.longitude_1" {
+		t.Errorf("indexName() = %v", got)
+	}
+}
+
+func Test_dryRunSensorCommunity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "pkg/source/sensorcommunity/testdata/data.json")
+	}))
+	defer server.Close()
+	s := testSettings(t)
+	s.SensorCommunity = config.SensorCommunity{Enabled: true, Endpoint: server.URL, StaleAfter: time.Hour}
+	s.only = "cities,sensorcommunity"
+	s.Archive = config.Archive{Enabled: true, Dir: t.TempDir()}
+
+	ctx := context.Background()
+	created, err := openStore(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.disconnect(time.Second)
+	// Dry runs open the store without changing its schema.
+	st, err := openExistingStore(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.disconnect(time.Second)
+	recorders := dryRunStore(s, st, 1)
+	if s.Archive.Enabled {
+		t.Errorf("dryRunStore() kept the archive enabled")
+	}
+	syncer, dataParams, err := newDataParams(s, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the sensor.community dataset is synced, cities are just planned.
+	if _, err := syncer.process(ctx, dataParams[1]); err != nil {
+		t.Fatal(err)
+	}
+	plans := dryRunPlans(recorders, dataParams)
+	if len(plans) != 2 || plans[0].Collection != citiesColName || plans[1].Collection != measurementsColName {
+		t.Fatalf("dryRunPlans() = %+v, want the plans of cities and measurements", plans)
+	}
+	if plans[1].Inserts != 3 || len(plans[1].Samples) != 1 || len(plans[1].DeleteStale) != 1 || plans[1].DeleteStale[0].Provider != sensorcommunity.ID {
+		t.Errorf("plan of measurements = %+v, want 3 inserts and deleting stale sensors", plans[1])
+	}
+	if got := dryRunPlans(map[string]*dryrun.Recorder{}, dataParams); len(got) != 0 {
+		t.Errorf("dryRunPlans() without recorders = %+v", got)
+	}
+}
+
+func Test_syncer_syncResumedFullSync(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		requested = append(requested, page)
+		fmt.Fprintf(w, `{"meta": {"found": 2}, "results": [{"location": "loc-%s", "country": "DE", "measurements": []}]}`, page)
+	}))
+	defer server.Close()
+	s := testSettings(t)
+	s.API.Endpoint, s.API.BatchSize = server.URL, 1
+	s.Sync.Incremental = false
+	s.only = "measurements"
+
+	ctx := context.Background()
+	st, syncer, dataParams := newTestSyncer(t, s)
+	data := dataParams[0]
+
+	// A crashed run wrote the first 2 of 3 pages and left a checkpoint.
+	runStart := time.Now().UTC().Add(-10 * time.Minute)
+	var docs []storage.Document
+	for i, name := range []string{"loc-1", "loc-2", "gone"} {
+		syncedAt := runStart.Add(time.Minute)
+		if name == "gone" {
+			syncedAt = runStart.Add(-24 * time.Hour)
+		}
+		docs = append(docs, storage.Location{Location: name, Country: "DE", Coordinates: storage.Coordinates{Latitude: float64(i)}, Provider: openaq.ID, SyncedAt: syncedAt})
+	}
+	if _, err := data.repo.Upsert(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+	cp := dataprocessor.Checkpoint{Dataset: data.name, RunID: "crashed", URL: data.source.URL(data.name, time.Time{}), Page: 2, Total: 2, StartedAt: runStart, UpdatedAt: time.Now().UTC()}
+	if err := st.checkpoints.SaveCheckpoint(ctx, cp); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := syncer.process(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(requested, []string{"3"}) {
+		t.Errorf("requested pages %v, want [3]", requested)
+	}
+	stored, err := data.repo.Find(ctx, storage.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, doc := range stored {
+		names = append(names, doc.Key())
+	}
+	if want := []string{"loc-1", "loc-2", "loc-3"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("stored locations %v, want %v", names, want)
+	}
+}
+
+func Test_syncJob_adminTrigger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"meta": {"found": 1}, "results": [{"name": "Berlin", "country": "DE", "count": 1, "locations": 1}]}`))
+	}))
+	defer server.Close()
+	exporter := tracetest.NewInMemoryExporter()
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	s := testSettings(t)
+	s.API.Endpoint = server.URL
+	s.Runs.Enabled = true
+	s.only = "cities"
+
+	ctx := context.Background()
+	st, syncer, dataParams := newTestSyncer(t, s)
+	var interrupted int32
+	sched := scheduler.NewScheduler(ctx, logger)
+	if err := sched.Add(syncJob(s, syncer, dataParams[0], &interrupted)); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/sync/cities", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	admin.NewHandler("secret", sched, syncer, st.runs).ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %v, want %v", rec.Code, http.StatusAccepted)
+	}
+	sched.Stop()
+
+	recent, err := st.runs.Recent(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 1 || recent[0].Trigger != triggerAdmin || recent[0].Status != statusOK {
+		t.Errorf("recorded runs %+v, want an ok run triggered by %s", recent, triggerAdmin)
+	}
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "run" {
+			if want := attribute.String("trigger", triggerAdmin); len(span.Attributes) == 0 || span.Attributes[0] != want {
+				t.Errorf("attributes of the run span = %v, want %v", span.Attributes, want)
+			}
+			return
+		}
+	}
+	t.Errorf("no run span recorded")
+}
+
+func Test_syncer_syncStaleSensors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "pkg/source/sensorcommunity/testdata/data.json")
+	}))
+	defer server.Close()
+	s := testSettings(t)
+	s.SensorCommunity = config.SensorCommunity{Enabled: true, Endpoint: server.URL, StaleAfter: time.Hour}
+	s.only = "sensorcommunity"
+
+	ctx := context.Background()
+	_, syncer, dataParams := newTestSyncer(t, s)
+	data := dataParams[0]
+	// A sensor that left the feed and an OpenAQ location, both not synced for longer than staleAfter.
+	synced := time.Now().UTC().Add(-2 * time.Hour)
+	old := []storage.Document{
+		storage.Location{Location: sensorcommunity.LocationName(1), Country: "DE", Provider: sensorcommunity.ID, LowCost: true, SyncedAt: synced},
+		storage.Location{Location: "openaq", Country: "DE", Provider: openaq.ID, SyncedAt: synced},
+	}
+	if _, err := data.repo.Upsert(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := syncer.process(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := data.repo.Find(ctx, storage.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, doc := range stored {
+		names = append(names, doc.Key())
+	}
+	want := []string{"openaq", sensorcommunity.LocationName(16581), sensorcommunity.LocationName(16582), sensorcommunity.LocationName(22914)}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("stored locations %v, want %v", names, want)
+	}
+}
+
+// testSettings returns the settings of a test that stores in a new sqlite database, without
+// leases, air quality and the history of runs.
+func testSettings(t *testing.T) *settings {
+	t.Helper()
+	logger = log.NewNopLogger()
+	s, err := registerSettings(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Storage = config.Storage{Backend: config.BackendSQLite, SQLitePath: filepath.Join(t.TempDir(), "aq.db")}
+	s.Lease.Enabled, s.AirQuality.Enabled, s.Runs.Enabled = false, false, false
+	return s
+}
+
+// newTestSyncer opens the store of s until the test ends and returns the syncer of its datasets.
+func newTestSyncer(t *testing.T, s *settings) (*store, *syncer, []dataProcessParams) {
+	t.Helper()
+	st, err := openStore(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.disconnect(time.Second) })
+	syncer, dataParams, err := newDataParams(s, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st, syncer, dataParams
+}
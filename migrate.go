@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nhe23/aq-dbsync/pkg/migrate"
+)
+
+type migrateSummary struct {
+	commandSummary
+	Applied []migrate.Record `json:"applied"`
+	Pending []pendingSummary `json:"pending"`
+}
+
+type pendingSummary struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+}
+
+// runMigrate applies the pending migrations of the stored documents and prints the applied and
+// pending migrations.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	s, err := registerSettings(fs, args)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	status := fs.Bool("status", false, "Only print the applied and pending migrations")
+	if err := s.parse(fs, args); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	st, err := openStore(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer st.disconnect(s.Mongo.ConnectTimeout)
+	migrator, err := st.newMigrator(s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+
+	summary := migrateSummary{Applied: []migrate.Record{}, Pending: []pendingSummary{}}
+	if !*status {
+		_, err = migrator.Run(ctx)
+	}
+	var applied []migrate.Record
+	if err == nil {
+		applied, err = migrator.Applied(ctx)
+	}
+	summary.Applied = append(summary.Applied, applied...)
+	var pending []migrate.Migration
+	if err == nil {
+		pending, err = migrator.Pending(ctx)
+	}
+	for _, m := range pending {
+		summary.Pending = append(summary.Pending, pendingSummary{m.Version, m.Description})
+	}
+	code := summary.finish(ctx, err)
+	return printSummary(summary, code)
+}
@@ -0,0 +1,189 @@
+// Package admin serves the authenticated HTTP API to trigger, pause and inspect syncs.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/runs"
+	"github.com/nhe23/aq-dbsync/pkg/scheduler"
+)
+
+// Scheduler runs the syncs of the datasets.
+type Scheduler interface {
+	Trigger(name string) error
+	Pause()
+	Resume()
+	Paused() bool
+	Jobs() []scheduler.JobStatus
+}
+
+// Job is a running sync of a dataset.
+type Job struct {
+	Dataset   string    `json:"dataset"`
+	StartedAt time.Time `json:"startedAt"`
+	// Page is the last processed page of Pages, which are 0 until the first page was processed.
+	Page  int `json:"page"`
+	Pages int `json:"pages"`
+}
+
+// Progress reports the running syncs.
+type Progress interface {
+	Running() []Job
+}
+
+// defaultRunsLimit is the number of runs returned if the request does not set a limit.
+const defaultRunsLimit = 20
+
+// maxRunsLimit is the maximum number of runs returned by a request.
+const maxRunsLimit = 500
+
+type handler struct {
+	token     string
+	scheduler Scheduler
+	progress  Progress
+	// runs is nil if the history of runs is disabled.
+	runs runs.Store
+}
+
+// NewHandler returns the handler of the admin API. Requests have to send token as bearer token.
+//
+//	POST /sync              triggers all datasets
+//	POST /sync/{dataset}    triggers a dataset
+//	GET  /scheduler         the scheduler and its jobs
+//	POST /scheduler/pause   pauses the scheduled runs
+//	POST /scheduler/resume  resumes the scheduled runs
+//	GET  /jobs              the running syncs with their progress
+//	GET  /runs?limit=20     the latest runs
+func NewHandler(token string, scheduler Scheduler, progress Progress, history runs.Store) http.Handler {
+	h := handler{token: token, scheduler: scheduler, progress: progress, runs: history}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync", h.method(http.MethodPost, h.triggerAll))
+	mux.HandleFunc("/sync/", h.method(http.MethodPost, h.trigger))
+	mux.HandleFunc("/scheduler", h.method(http.MethodGet, h.schedulerState))
+	mux.HandleFunc("/scheduler/pause", h.method(http.MethodPost, h.pause))
+	mux.HandleFunc("/scheduler/resume", h.method(http.MethodPost, h.resume))
+	mux.HandleFunc("/jobs", h.method(http.MethodGet, h.jobs))
+	mux.HandleFunc("/runs", h.method(http.MethodGet, h.recentRuns))
+	return h.authenticate(mux)
+}
+
+// authenticate rejects requests without the bearer token.
+func (h handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || h.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="aq-dbsync"`)
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// method restricts handler to requests of method.
+func (h handler) method(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// triggered is the result of triggering the sync of a dataset.
+type triggered struct {
+	Dataset   string `json:"dataset"`
+	Triggered bool   `json:"triggered"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (h handler) triggerAll(w http.ResponseWriter, r *http.Request) {
+	result := make([]triggered, 0)
+	for _, job := range h.scheduler.Jobs() {
+		t := triggered{Dataset: job.Name, Triggered: true}
+		if err := h.scheduler.Trigger(job.Name); err != nil {
+			t.Triggered, t.Error = false, err.Error()
+		}
+		result = append(result, t)
+	}
+	writeJSON(w, http.StatusAccepted, result)
+}
+
+func (h handler) trigger(w http.ResponseWriter, r *http.Request) {
+	dataset := strings.TrimPrefix(r.URL.Path, "/sync/")
+	err := h.scheduler.Trigger(dataset)
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownJob):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, scheduler.ErrJobRunning):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusAccepted, triggered{Dataset: dataset, Triggered: true})
+	}
+}
+
+// schedulerState is the state of the scheduler.
+type schedulerState struct {
+	Paused bool                  `json:"paused"`
+	Jobs   []scheduler.JobStatus `json:"jobs"`
+}
+
+func (h handler) schedulerState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, schedulerState{Paused: h.scheduler.Paused(), Jobs: h.scheduler.Jobs()})
+}
+
+func (h handler) pause(w http.ResponseWriter, r *http.Request) {
+	h.scheduler.Pause()
+	h.schedulerState(w, r)
+}
+
+func (h handler) resume(w http.ResponseWriter, r *http.Request) {
+	h.scheduler.Resume()
+	h.schedulerState(w, r)
+}
+
+func (h handler) jobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.progress.Running())
+}
+
+func (h handler) recentRuns(w http.ResponseWriter, r *http.Request) {
+	if h.runs == nil {
+		writeError(w, http.StatusNotFound, errors.New("the history of runs is disabled"))
+		return
+	}
+	limit := defaultRunsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxRunsLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be a number between 1 and %d", maxRunsLimit))
+			return
+		}
+	}
+	recent, err := h.runs.Recent(r.Context(), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/runs"
+	"github.com/nhe23/aq-dbsync/pkg/scheduler"
+)
+
+type fakeScheduler struct {
+	paused    bool
+	running   map[string]bool
+	triggered []string
+}
+
+func (f *fakeScheduler) Trigger(name string) error {
+	running, ok := f.running[name]
+	if !ok {
+		return fmt.Errorf("not triggering %s: %w", name, scheduler.ErrUnknownJob)
+	}
+	if running {
+		return fmt.Errorf("not triggering %s: %w", name, scheduler.ErrJobRunning)
+	}
+	f.triggered = append(f.triggered, name)
+	return nil
+}
+
+func (f *fakeScheduler) Pause()       { f.paused = true }
+func (f *fakeScheduler) Resume()      { f.paused = false }
+func (f *fakeScheduler) Paused() bool { return f.paused }
+
+func (f *fakeScheduler) Jobs() []scheduler.JobStatus {
+	var jobs []scheduler.JobStatus
+	for _, name := range []string{"cities", "measurements"} {
+		jobs = append(jobs, scheduler.JobStatus{Name: name, Running: f.running[name]})
+	}
+	return jobs
+}
+
+type fakeProgress []Job
+
+func (f fakeProgress) Running() []Job { return f }
+
+type fakeRuns struct {
+	runs.Store
+	runs []runs.Run
+}
+
+func (f fakeRuns) Recent(ctx context.Context, limit int) ([]runs.Run, error) {
+	if limit < len(f.runs) {
+		return f.runs[:limit], nil
+	}
+	return f.runs, nil
+}
+
+func Test_handler(t *testing.T) {
+	startedAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	history := fakeRuns{runs: []runs.Run{{ID: "b", Status: runs.StatusOK}, {ID: "a", Status: "failed"}}}
+	progress := fakeProgress{{Dataset: "measurements", StartedAt: startedAt, Page: 3, Pages: 7}}
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		token         string
+		history       runs.Store
+		wantStatus    int
+		wantBody      string
+		wantTriggered string
+		wantPaused    bool
+	}{
+		{"no token", http.MethodPost, "/sync", "", history, http.StatusUnauthorized, `{"error":"invalid or missing bearer token"}`, "", false},
+		{"wrong token", http.MethodPost, "/sync", "wrong", history, http.StatusUnauthorized, `{"error":"invalid or missing bearer token"}`, "", false},
+		{"trigger all", http.MethodPost, "/sync", "secret", history, http.StatusAccepted,
+			`[{"dataset":"cities","triggered":true},{"dataset":"measurements","triggered":false,"error":"not triggering measurements: job is already running"}]`, "cities", false},
+		{"trigger", http.MethodPost, "/sync/cities", "secret", history, http.StatusAccepted, `{"dataset":"cities","triggered":true}`, "cities", false},
+		{"trigger running", http.MethodPost, "/sync/measurements", "secret", history, http.StatusConflict,
+			`{"error":"not triggering measurements: job is already running"}`, "", false},
+		{"trigger unknown", http.MethodPost, "/sync/countries", "secret", history, http.StatusNotFound, `{"error":"not triggering countries: unknown job"}`, "", false},
+		{"trigger with get", http.MethodGet, "/sync/cities", "secret", history, http.StatusMethodNotAllowed, `{"error":"method GET not allowed"}`, "", false},
+		{"pause", http.MethodPost, "/scheduler/pause", "secret", history, http.StatusOK,
+			`{"paused":true,"jobs":[{"name":"cities","schedule":"","running":false,"next":"0001-01-01T00:00:00Z"},{"name":"measurements","schedule":"","running":true,"next":"0001-01-01T00:00:00Z"}]}`, "", true},
+		{"jobs", http.MethodGet, "/jobs", "secret", history, http.StatusOK, `[{"dataset":"measurements","startedAt":"2021-03-01T12:00:00Z","page":3,"pages":7}]`, "", false},
+		{"runs", http.MethodGet, "/runs?limit=1", "secret", history, http.StatusOK, `[{"id":"b","trigger":"","status":"ok","startedAt":"0001-01-01T00:00:00Z","finishedAt":"0001-01-01T00:00:00Z","datasets":null}]`, "", false},
+		{"runs invalid limit", http.MethodGet, "/runs?limit=0", "secret", history, http.StatusBadRequest, `{"error":"limit must be a number between 1 and 500"}`, "", false},
+		{"runs disabled", http.MethodGet, "/runs", "secret", nil, http.StatusNotFound, `{"error":"the history of runs is disabled"}`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched := &fakeScheduler{running: map[string]bool{"cities": false, "measurements": true}}
+			h := NewHandler("secret", sched, progress, tt.history)
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %v, want %v", got, tt.wantBody)
+			}
+			if !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("body is no valid json")
+			}
+			if got := strings.Join(sched.triggered, ","); got != tt.wantTriggered {
+				t.Errorf("triggered = %v, want %v", got, tt.wantTriggered)
+			}
+			if sched.paused != tt.wantPaused {
+				t.Errorf("paused = %v, want %v", sched.paused, tt.wantPaused)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package airquality
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// pageSize is the number of locations read at once.
+const pageSize = 1000
+
+// Result counts the cities and countries whose air quality was updated.
+type Result struct {
+	Cities    int `json:"cities"`
+	Countries int `json:"countries"`
+}
+
+// Updater computes the current air quality of cities and countries from their locations.
+type Updater struct {
+	locations storage.Repository
+	cities    storage.AirQualityWriter
+	countries storage.AirQualityWriter
+	maxAge    time.Duration
+}
+
+// NewUpdater creates an Updater. Measurements older than maxAge are not current.
+func NewUpdater(locations storage.Repository, cities storage.AirQualityWriter, countries storage.AirQualityWriter, maxAge time.Duration) *Updater {
+	return &Updater{locations: locations, cities: cities, countries: countries, maxAge: maxAge}
+}
+
+// Update computes the air quality of all cities and countries with locations and stores it on them.
+// Cities and countries without locations lose their air quality.
+func (u *Updater) Update(ctx context.Context) (Result, error) {
+	now := time.Now().UTC()
+	s := NewSummary(now.Add(-u.maxAge))
+	filter := storage.Filter{Limit: pageSize}
+	for {
+		docs, err := u.locations.Find(ctx, filter)
+		if err != nil {
+			return Result{}, fmt.Errorf("error reading locations: %w", err)
+		}
+		for _, doc := range docs {
+			if location, ok := doc.(storage.Location); ok {
+				s.Add(location)
+			}
+		}
+		if len(docs) < pageSize {
+			break
+		}
+		filter.After = docs[len(docs)-1].Key()
+	}
+	cities, countries := s.AirQuality(now)
+	if err := u.cities.SetAirQuality(ctx, cities); err != nil {
+		return Result{}, err
+	}
+	if err := u.countries.SetAirQuality(ctx, countries); err != nil {
+		return Result{}, err
+	}
+	return Result{Cities: len(cities), Countries: len(countries)}, nil
+}
+
+type parameter struct {
+	name string
+	unit string
+}
+
+// area collects the current values of the locations of a city or country.
+type area struct {
+	stations int
+	values   map[parameter][]float64
+}
+
+func (a *area) add(values map[parameter]float64) {
+	if len(values) > 0 {
+		a.stations++
+	}
+	for p, v := range values {
+		a.values[p] = append(a.values[p], v)
+	}
+}
+
+// Summary summarises the current measurements of locations per city and country.
+type Summary struct {
+	since     time.Time
+	cities    map[string]*area
+	countries map[string]*area
+}
+
+// NewSummary creates a Summary of the measurements updated since since.
+func NewSummary(since time.Time) *Summary {
+	return &Summary{since: since, cities: make(map[string]*area), countries: make(map[string]*area)}
+}
+
+// Add adds the current measurements of a location. Negative values mark invalid measurements.
+func (s *Summary) Add(location storage.Location) {
+	values := make(map[parameter]float64)
+	for _, m := range location.Measurements {
+		if m.LastUpdated.Before(s.since) || m.Value < 0 {
+			continue
+		}
+		values[parameter{m.Parameter, m.Unit}] = m.Value
+	}
+	if location.City != "" {
+		areaOf(s.cities, location.City).add(values)
+	}
+	if location.Country != "" {
+		areaOf(s.countries, location.Country).add(values)
+	}
+}
+
+func areaOf(areas map[string]*area, name string) *area {
+	a, ok := areas[name]
+	if !ok {
+		a = &area{values: make(map[parameter][]float64)}
+		areas[name] = a
+	}
+	return a
+}
+
+// AirQuality returns the air quality of the cities by name and of the countries by code. Areas
+// without current measurements have no stations.
+func (s *Summary) AirQuality(updatedAt time.Time) (map[string]storage.AirQuality, map[string]storage.AirQuality) {
+	return airQuality(s.cities, updatedAt), airQuality(s.countries, updatedAt)
+}
+
+func airQuality(areas map[string]*area, updatedAt time.Time) map[string]storage.AirQuality {
+	result := make(map[string]storage.AirQuality, len(areas))
+	for name, a := range areas {
+		aq := storage.AirQuality{Stations: a.stations, Parameters: []storage.ParameterQuality{}, UpdatedAt: updatedAt}
+		for p, values := range a.values {
+			sort.Float64s(values)
+			pq := storage.ParameterQuality{
+				Parameter: p.name,
+				Unit:      p.unit,
+				Median:    median(values),
+				Max:       values[len(values)-1],
+				Stations:  len(values),
+			}
+			pq.QualityIndex = dataprocessor.QualityIndex(p.name, p.unit, pq.Median)
+			if pq.QualityIndex > aq.QualityIndex {
+				aq.QualityIndex = pq.QualityIndex
+			}
+			aq.Parameters = append(aq.Parameters, pq)
+		}
+		sort.Slice(aq.Parameters, func(i, j int) bool {
+			if aq.Parameters[i].Parameter != aq.Parameters[j].Parameter {
+				return aq.Parameters[i].Parameter < aq.Parameters[j].Parameter
+			}
+			return aq.Parameters[i].Unit < aq.Parameters[j].Unit
+		})
+		result[name] = aq
+	}
+	return result
+}
+
+// median returns the median of sorted values.
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
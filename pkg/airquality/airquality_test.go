@@ -0,0 +1,137 @@
+package airquality
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func location(name string, city string, country string, age time.Duration, values map[string]float64) storage.Location {
+	l := storage.Location{Location: name, City: city, Country: country}
+	for parameter, value := range values {
+		l.Measurements = append(l.Measurements, storage.Measurement{Parameter: parameter, Value: value, Unit: "µg/m³", LastUpdated: now.Add(-age)})
+	}
+	return l
+}
+
+func Test_Summary(t *testing.T) {
+	s := NewSummary(now.Add(-3 * time.Hour))
+	s.Add(location("a", "Berlin", "DE", time.Hour, map[string]float64{"pm10": 10, "no2": 50}))
+	s.Add(location("b", "Berlin", "DE", time.Hour, map[string]float64{"pm10": 30}))
+	s.Add(location("c", "Berlin", "DE", 2*time.Hour, map[string]float64{"pm10": 40, "no2": -99}))
+	s.Add(location("d", "Berlin", "DE", 5*time.Hour, map[string]float64{"pm10": 200}))
+	s.Add(location("e", "Hamburg", "DE", time.Hour, map[string]float64{"pm25": 95}))
+	cities, countries := s.AirQuality(now)
+
+	wantBerlin := storage.AirQuality{QualityIndex: 2, Stations: 3, UpdatedAt: now, Parameters: []storage.ParameterQuality{
+		{Parameter: "no2", Unit: "µg/m³", Median: 50, Max: 50, QualityIndex: 2, Stations: 1},
+		{Parameter: "pm10", Unit: "µg/m³", Median: 30, Max: 40, QualityIndex: 2, Stations: 3},
+	}}
+	if !reflect.DeepEqual(cities["Berlin"], wantBerlin) {
+		t.Errorf("air quality of Berlin = %+v, want %+v", cities["Berlin"], wantBerlin)
+	}
+	de := countries["DE"]
+	if de.Stations != 4 || de.QualityIndex != 6 || len(de.Parameters) != 3 || de.Parameters[1].Median != 30 {
+		t.Errorf("air quality of DE = %+v", de)
+	}
+	if len(cities) != 2 || len(countries) != 1 {
+		t.Errorf("air quality of %d cities and %d countries, want 2 and 1", len(cities), len(countries))
+	}
+}
+
+func Test_median(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   float64
+	}{
+		{[]float64{4}, 4},
+		{[]float64{1, 2, 9}, 2},
+		{[]float64{1, 2, 3, 9}, 2.5},
+	}
+	for _, tt := range tests {
+		if got := median(tt.values); got != tt.want {
+			t.Errorf("median(%v) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
+
+// memoryLocations serves locations sorted by key in pages.
+type memoryLocations []storage.Location
+
+func (m memoryLocations) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	return storage.UpsertResult{}, fmt.Errorf("not implemented")
+}
+
+func (m memoryLocations) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	var docs []storage.Document
+	for _, l := range m {
+		if l.Key() > filter.After && len(docs) < filter.Limit {
+			docs = append(docs, l)
+		}
+	}
+	return docs, nil
+}
+
+func (m memoryLocations) DeleteStale(ctx context.Context, provider string, before time.Time) (int64, error) {
+	return 0, fmt.Errorf("not implemented")
+}
+
+type memoryWriter map[string]storage.AirQuality
+
+func (m memoryWriter) SetAirQuality(ctx context.Context, airQuality map[string]storage.AirQuality) error {
+	for key := range m {
+		delete(m, key)
+	}
+	for key, aq := range airQuality {
+		m[key] = aq
+	}
+	return nil
+}
+
+func Test_Updater_Update(t *testing.T) {
+	var locations memoryLocations
+	for i := 0; i < pageSize+1; i++ {
+		country := "DE"
+		if i%2 == 1 {
+			country = "FR"
+		}
+		locations = append(locations, location(fmt.Sprintf("%05d", i), country+"-city", country, time.Minute, map[string]float64{"pm10": 10}))
+	}
+	cities, countries := memoryWriter{}, memoryWriter{}
+	// The measurements are a minute old when the updater runs.
+	for i := range locations {
+		locations[i].Measurements[0].LastUpdated = time.Now().UTC().Add(-time.Minute)
+	}
+	result, err := NewUpdater(locations, cities, countries, time.Hour).Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (Result{Cities: 2, Countries: 2}) {
+		t.Errorf("Update() = %+v", result)
+	}
+	if cities["DE-city"].Stations+cities["FR-city"].Stations != pageSize+1 || countries["DE"].QualityIndex != 1 {
+		t.Errorf("air quality = %+v, %+v", cities, countries)
+	}
+}
+
+func Test_Updater_UpdateWithoutLocations(t *testing.T) {
+	locations := memoryLocations{location("a", "Berlin", "DE", 2*time.Hour, map[string]float64{"pm10": 10})}
+	// Paris and France lost all their locations since the last update.
+	cities := memoryWriter{"Berlin": {Stations: 1}, "Paris": {Stations: 2}}
+	countries := memoryWriter{"DE": {Stations: 1}, "FR": {Stations: 2}}
+	if _, err := NewUpdater(locations, cities, countries, time.Hour).Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cities["Paris"]; ok || len(cities) != 1 || cities["Berlin"].Stations != 0 {
+		t.Errorf("air quality of cities = %+v", cities)
+	}
+	if _, ok := countries["FR"]; ok || len(countries) != 1 || countries["DE"].Stations != 0 {
+		t.Errorf("air quality of countries = %+v", countries)
+	}
+}
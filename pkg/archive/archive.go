@@ -0,0 +1,178 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Suffixes of the two objects stored per response.
+const (
+	MetaSuffix = ".json"
+	BodySuffix = ".body.gz"
+)
+
+// Record describes an archived response. It is stored as JSON next to the gzip compressed body.
+type Record struct {
+	URL       string      `json:"url"`
+	FetchedAt time.Time   `json:"fetchedAt"`
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header"`
+	// BodyKey is the key of the gzip compressed body.
+	BodyKey  string `json:"bodyKey"`
+	BodySize int    `json:"bodySize"`
+	SHA256   string `json:"sha256"`
+}
+
+// Retention limits the archive. Zero values disable a limit.
+type Retention struct {
+	MaxAge time.Duration
+	// MaxBytes is the maximum total size of all objects.
+	MaxBytes int64
+}
+
+// Archiver writes responses into a Store.
+type Archiver struct {
+	store     Store
+	retention Retention
+	logger    log.Logger
+	// now is replaced in tests.
+	now func() time.Time
+	mu  sync.Mutex
+	seq int
+}
+
+// NewArchiver creates an Archiver. Errors while archiving are logged and never fail a request.
+func NewArchiver(store Store, retention Retention, logger log.Logger) *Archiver {
+	return &Archiver{store: store, retention: retention, logger: logger, now: time.Now}
+}
+
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// key returns a time ordered key of a response, e.g. 2021/03/01/15/20210301T150405.123Z-0001-v1-latest.
+func (a *Archiver) key(u string, fetchedAt time.Time) string {
+	a.mu.Lock()
+	a.seq = (a.seq + 1) % 10000
+	seq := a.seq
+	a.mu.Unlock()
+	name := u
+	if parsed, err := url.Parse(u); err == nil {
+		name = parsed.Path
+		if page := parsed.Query().Get("page"); page != "" {
+			name += "-p" + page
+		}
+	}
+	name = strings.Trim(unsafeKeyChars.ReplaceAllString(name, "-"), "-")
+	t := fetchedAt.UTC()
+	return fmt.Sprintf("%s/%s-%04d-%s", t.Format("2006/01/02/15"), t.Format("20060102T150405.000Z"), seq, name)
+}
+
+// Archive stores a response body and its metadata.
+func (a *Archiver) Archive(ctx context.Context, u string, fetchedAt time.Time, status int, header http.Header, body []byte) error {
+	key := a.key(u, fetchedAt)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(body); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := a.store.Put(ctx, key+BodySuffix, &compressed); err != nil {
+		return fmt.Errorf("error archiving body of %s: %w", u, err)
+	}
+	sum := sha256.Sum256(body)
+	meta, err := json.Marshal(Record{
+		URL:       u,
+		FetchedAt: fetchedAt.UTC(),
+		Status:    status,
+		Header:    header,
+		BodyKey:   key + BodySuffix,
+		BodySize:  len(body),
+		SHA256:    hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		return err
+	}
+	if err := a.store.Put(ctx, key+MetaSuffix, bytes.NewReader(meta)); err != nil {
+		return fmt.Errorf("error archiving metadata of %s: %w", u, err)
+	}
+	return nil
+}
+
+// Transport returns a RoundTripper that archives every response of next. The body is read
+// completely and handed on unchanged.
+func (a *Archiver) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper{a, next}
+}
+
+type roundTripper struct {
+	archiver *Archiver
+	next     http.RoundTripper
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	fetchedAt := rt.archiver.now()
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err := rt.archiver.Archive(req.Context(), req.URL.String(), fetchedAt, resp.StatusCode, resp.Header, body); err != nil {
+		rt.archiver.logger.Log("error", err)
+	}
+	return resp, nil
+}
+
+// Prune deletes objects older than the maximum age and then the oldest objects until the archive
+// fits the maximum size. It returns the number of deleted objects.
+func (a *Archiver) Prune(ctx context.Context) (int, error) {
+	if a.retention.MaxAge <= 0 && a.retention.MaxBytes <= 0 {
+		return 0, nil
+	}
+	objects, err := a.store.List(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("error listing archive: %w", err)
+	}
+	var total int64
+	for _, o := range objects {
+		total += o.Size
+	}
+	cutoff := a.now().Add(-a.retention.MaxAge)
+	deleted := 0
+	// Keys are time ordered, so the oldest objects come first.
+	for _, o := range objects {
+		expired := a.retention.MaxAge > 0 && o.ModTime.Before(cutoff)
+		tooLarge := a.retention.MaxBytes > 0 && total > a.retention.MaxBytes
+		if !expired && !tooLarge {
+			break
+		}
+		if err := a.store.Delete(ctx, o.Key); err != nil {
+			return deleted, fmt.Errorf("error deleting %s from archive: %w", o.Key, err)
+		}
+		total -= o.Size
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func readObject(t *testing.T, store Store, key string) []byte {
+	t.Helper()
+	r, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func Test_Archiver_Transport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"results": []}`)
+	}))
+	defer server.Close()
+
+	store := NewDirStore(t.TempDir())
+	archiver := NewArchiver(store, Retention{}, log.NewNopLogger())
+	client := &http.Client{Transport: archiver.Transport(nil)}
+	resp, err := client.Get(server.URL + "/v1/latest?limit=10&page=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != `{"results": []}` {
+		t.Errorf("response body = %s", body)
+	}
+
+	objects, err := store.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || !strings.HasSuffix(objects[0].Key, "-v1-latest-p3"+BodySuffix) || !strings.HasSuffix(objects[1].Key, MetaSuffix) {
+		t.Fatalf("archived objects = %v", objects)
+	}
+	var record Record
+	if err := json.Unmarshal(readObject(t, store, objects[1].Key), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != 200 || record.BodySize != len(body) || record.BodyKey != objects[0].Key ||
+		record.Header.Get("Content-Type") != "application/json" || !strings.HasSuffix(record.URL, "page=3") {
+		t.Errorf("unexpected record %+v", record)
+	}
+	gz, err := gzip.NewReader(strings.NewReader(string(readObject(t, store, record.BodyKey))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived, _ := io.ReadAll(gz)
+	if string(archived) != string(body) {
+		t.Errorf("archived body = %s, want %s", archived, body)
+	}
+}
+
+func Test_Archiver_Prune(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		retention Retention
+		want      []string
+	}{
+		{"disabled", Retention{}, []string{"a", "b", "c"}},
+		{"age", Retention{MaxAge: 36 * time.Hour}, []string{"b", "c"}},
+		{"size", Retention{MaxBytes: 30}, []string{"c"}},
+		{"age and size", Retention{MaxAge: 12 * time.Hour, MaxBytes: 100}, []string{"c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			store := NewDirStore(root)
+			for i, key := range []string{"a", "b", "c"} {
+				if err := store.Put(context.Background(), key, strings.NewReader(strings.Repeat("x", 10*(i+1)))); err != nil {
+					t.Fatal(err)
+				}
+				modTime := now.Add(-time.Duration(2-i) * 24 * time.Hour)
+				if err := os.Chtimes(filepath.Join(root, key), modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			archiver := NewArchiver(store, tt.retention, log.NewNopLogger())
+			archiver.now = func() time.Time { return now }
+			if _, err := archiver.Prune(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			objects, _ := store.List(context.Background(), "")
+			var got []string
+			for _, o := range objects {
+				got = append(got, o.Key)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("remaining objects = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Archiver_key(t *testing.T) {
+	archiver := NewArchiver(nil, Retention{}, log.NewNopLogger())
+	fetchedAt := time.Date(2021, 3, 1, 15, 4, 5, 123000000, time.UTC)
+	got := archiver.key("https://api.openaq.org/v1/cities?limit=1000&page=2", fetchedAt)
+	if want := "2021/03/01/15/20210301T150405.123Z-0001-v1-cities-p2"; got != want {
+		t.Errorf("Archiver.key() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,257 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/time/rate"
+)
+
+// HistoryMeasurement is a single historical measurement of a location.
+type HistoryMeasurement struct {
+	Location    string      `bson:"location"`
+	City        string      `bson:"city"`
+	Country     string      `bson:"country"`
+	Parameter   string      `bson:"parameter"`
+	Value       float64     `bson:"value"`
+	Unit        string      `bson:"unit"`
+	Date        time.Time   `bson:"date"`
+	Coordinates coordinates `bson:"coordinates"`
+}
+
+type coordinates struct {
+	Latitude  float64 `bson:"latitude" json:"latitude"`
+	Longitude float64 `bson:"longitude" json:"longitude"`
+}
+
+type apiMeasurement struct {
+	Location  string  `json:"location"`
+	City      string  `json:"city"`
+	Country   string  `json:"country"`
+	Parameter string  `json:"parameter"`
+	Value     float64 `json:"value"`
+	Unit      string  `json:"unit"`
+	Date      struct {
+		UTC time.Time `json:"utc"`
+	} `json:"date"`
+	Coordinates coordinates `json:"coordinates"`
+}
+
+type measurementsPage struct {
+	Meta struct {
+		Found int `json:"found"`
+	} `json:"meta"`
+	Results []apiMeasurement `json:"results"`
+}
+
+// Options describe a backfill.
+type Options struct {
+	// APIEndpoint is the base URL of the AQ api.
+	APIEndpoint string
+	From        time.Time
+	To          time.Time
+	// Window is the length of the date windows the range is split into.
+	Window    time.Duration
+	Country   string
+	Parameter string
+	BatchSize int
+}
+
+// ID identifies the backfill of a range so an interrupted backfill can be resumed.
+func (o Options) ID() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", o.From.UTC().Format(time.RFC3339), o.To.UTC().Format(time.RFC3339), o.Window, o.Country, o.Parameter)
+}
+
+// Result summarises a backfill.
+type Result struct {
+	Windows   int  `json:"windows"`
+	Pages     int  `json:"pages"`
+	Documents int  `json:"documents"`
+	Resumed   bool `json:"resumed"`
+}
+
+// HistoryStore consists of the used functions of the mongo history collection.
+type HistoryStore interface {
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
+
+// Backfiller walks the measurements endpoint of the AQ api and writes the results into the history store.
+type Backfiller struct {
+	httpClient  *http.Client
+	limiter     *rate.Limiter
+	history     HistoryStore
+	checkpoints CheckpointStore
+}
+
+// NewBackfiller creates a Backfiller that sends at most requestsPerSecond requests to the api.
+func NewBackfiller(httpClient *http.Client, requestsPerSecond float64, history HistoryStore, checkpoints CheckpointStore) *Backfiller {
+	return &Backfiller{
+		httpClient:  httpClient,
+		limiter:     rate.NewLimiter(rate.Limit(requestsPerSecond), 1),
+		history:     history,
+		checkpoints: checkpoints,
+	}
+}
+
+// Run backfills the range of opts, resuming from the last checkpoint of the same range unless restart is set.
+// A checkpoint is saved after every completed page.
+func (b *Backfiller) Run(ctx context.Context, opts Options, restart bool) (Result, error) {
+	var result Result
+	if !opts.From.Before(opts.To) {
+		return result, fmt.Errorf("invalid range: from %s is not before to %s", opts.From, opts.To)
+	}
+	if opts.Window <= 0 {
+		return result, fmt.Errorf("invalid window %s", opts.Window)
+	}
+
+	cp := Checkpoint{ID: opts.ID(), WindowStart: opts.From}
+	if !restart {
+		saved, found, err := b.checkpoints.Load(ctx, cp.ID)
+		if err != nil {
+			return result, fmt.Errorf("error loading checkpoint: %w", err)
+		}
+		if found {
+			if saved.Done {
+				return Result{Resumed: true}, nil
+			}
+			cp = saved
+			result.Resumed = true
+		}
+	}
+
+	for windowStart := cp.WindowStart; windowStart.Before(opts.To); windowStart = windowStart.Add(opts.Window) {
+		windowEnd := windowStart.Add(opts.Window)
+		if windowEnd.After(opts.To) {
+			windowEnd = opts.To
+		}
+		page := 1
+		if windowStart.Equal(cp.WindowStart) {
+			page = cp.Page + 1
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			written, found, err := b.processPage(ctx, opts, windowStart, windowEnd, page)
+			if err != nil {
+				return result, fmt.Errorf("error backfilling %s - %s page %d: %w", windowStart.Format(time.RFC3339), windowEnd.Format(time.RFC3339), page, err)
+			}
+			result.Pages++
+			result.Documents += written
+
+			cp.WindowStart, cp.Page = windowStart, page
+			if page*opts.BatchSize >= found {
+				cp.WindowStart, cp.Page = windowEnd, 0
+			}
+			if err := b.checkpoints.Save(ctx, cp); err != nil {
+				return result, fmt.Errorf("error saving checkpoint: %w", err)
+			}
+			if cp.Page == 0 {
+				break
+			}
+			page++
+		}
+		result.Windows++
+	}
+
+	cp.Done = true
+	if err := b.checkpoints.Save(ctx, cp); err != nil {
+		return result, fmt.Errorf("error saving checkpoint: %w", err)
+	}
+	return result, nil
+}
+
+func (b *Backfiller) processPage(ctx context.Context, opts Options, from time.Time, to time.Time, page int) (int, int, error) {
+	measurements, found, err := b.getPage(ctx, pageURL(opts, from, to, page))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(measurements) == 0 {
+		return 0, found, nil
+	}
+	docs := make([]HistoryMeasurement, 0, len(measurements))
+	for _, m := range measurements {
+		docs = append(docs, HistoryMeasurement{
+			Location:    m.Location,
+			City:        m.City,
+			Country:     m.Country,
+			Parameter:   m.Parameter,
+			Value:       m.Value,
+			Unit:        m.Unit,
+			Date:        m.Date.UTC,
+			Coordinates: m.Coordinates,
+		})
+	}
+	if err := WriteHistory(ctx, b.history, docs); err != nil {
+		return 0, found, err
+	}
+	return len(docs), found, nil
+}
+
+// WriteHistory inserts historical measurements, replacing measurements of the same location,
+// parameter and date.
+func WriteHistory(ctx context.Context, history HistoryStore, docs []HistoryMeasurement) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	operations := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		mongoOperation := mongo.NewReplaceOneModel()
+		mongoOperation.SetFilter(bson.M{"location": doc.Location, "parameter": doc.Parameter, "date": doc.Date})
+		mongoOperation.SetReplacement(doc)
+		mongoOperation.SetUpsert(true)
+		operations = append(operations, mongoOperation)
+	}
+	bulkOption := options.BulkWriteOptions{}
+	bulkOption.SetOrdered(false)
+	if _, err := history.BulkWrite(ctx, operations, &bulkOption); err != nil {
+		return fmt.Errorf("error updating history: %w", err)
+	}
+	return nil
+}
+
+func (b *Backfiller) getPage(ctx context.Context, url string) ([]apiMeasurement, int, error) {
+	if err := b.limiter.Wait(ctx); err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to creating a request: %w", err)
+	}
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var page measurementsPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, 0, fmt.Errorf("could not parse measurements: %w", err)
+	}
+	return page.Results, page.Meta.Found, nil
+}
+
+func pageURL(opts Options, from time.Time, to time.Time, page int) string {
+	query := url.Values{}
+	query.Set("date_from", from.UTC().Format(time.RFC3339))
+	query.Set("date_to", to.UTC().Format(time.RFC3339))
+	query.Set("limit", fmt.Sprint(opts.BatchSize))
+	query.Set("page", fmt.Sprint(page))
+	if opts.Country != "" {
+		query.Set("country", opts.Country)
+	}
+	if opts.Parameter != "" {
+		query.Set("parameter", opts.Parameter)
+	}
+	return fmt.Sprintf("%s/v1/measurements?%s", opts.APIEndpoint, query.Encode())
+}
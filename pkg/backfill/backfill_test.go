@@ -0,0 +1,159 @@
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const apiEndpoint = "https://api.openaq.org"
+
+type dataAccess struct {
+	operations int
+}
+
+func (d *dataAccess) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	d.operations += len(models)
+	return &mongo.BulkWriteResult{}, nil
+}
+
+type memoryCheckpoints map[string]Checkpoint
+
+func (m memoryCheckpoints) Load(ctx context.Context, id string) (Checkpoint, bool, error) {
+	cp, found := m[id]
+	return cp, found, nil
+}
+
+func (m memoryCheckpoints) Save(ctx context.Context, cp Checkpoint) error {
+	m[cp.ID] = cp
+	return nil
+}
+
+// registerMeasurements serves 3 measurements per date window in pages of the requested limit.
+func registerMeasurements(requests *[]string) {
+	httpmock.RegisterResponder("GET", apiEndpoint+"/v1/measurements", func(req *http.Request) (*http.Response, error) {
+		query := req.URL.Query()
+		*requests = append(*requests, query.Get("date_from")+"#"+query.Get("page"))
+		result := `{"location": "1-r khoroolol", "parameter": "pm10", "value": 199, "unit": "µg/m³", "date": {"utc": "%s"}}`
+		return httpmock.NewStringResponse(200, fmt.Sprintf(`{"meta": {"found": 3}, "results": [%s, %s]}`,
+			fmt.Sprintf(result, query.Get("date_from")), fmt.Sprintf(result, query.Get("date_to")))), nil
+	})
+}
+
+func TestBackfiller_Run(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	var requests []string
+	registerMeasurements(&requests)
+
+	from := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	opts := Options{
+		APIEndpoint: apiEndpoint,
+		From:        from,
+		To:          from.Add(48 * time.Hour),
+		Window:      24 * time.Hour,
+		BatchSize:   2,
+	}
+	tests := []struct {
+		name         string
+		checkpoint   *Checkpoint
+		restart      bool
+		wantRequests []string
+		wantResult   Result
+	}{
+		{
+			"full", nil, false,
+			[]string{"2019-03-01T00:00:00Z#1", "2019-03-01T00:00:00Z#2", "2019-03-02T00:00:00Z#1", "2019-03-02T00:00:00Z#2"},
+			Result{Windows: 2, Pages: 4, Documents: 8},
+		},
+		{
+			"resume", &Checkpoint{ID: opts.ID(), WindowStart: from.Add(24 * time.Hour), Page: 1}, false,
+			[]string{"2019-03-02T00:00:00Z#2"},
+			Result{Windows: 1, Pages: 1, Documents: 2, Resumed: true},
+		},
+		{
+			"done", &Checkpoint{ID: opts.ID(), WindowStart: opts.To, Done: true}, false,
+			nil,
+			Result{Resumed: true},
+		},
+		{
+			"restart", &Checkpoint{ID: opts.ID(), WindowStart: opts.To, Done: true}, true,
+			[]string{"2019-03-01T00:00:00Z#1", "2019-03-01T00:00:00Z#2", "2019-03-02T00:00:00Z#1", "2019-03-02T00:00:00Z#2"},
+			Result{Windows: 2, Pages: 4, Documents: 8},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = nil
+			checkpoints := memoryCheckpoints{}
+			if tt.checkpoint != nil {
+				checkpoints[tt.checkpoint.ID] = *tt.checkpoint
+			}
+			history := &dataAccess{}
+			b := NewBackfiller(http.DefaultClient, 1000, history, checkpoints)
+			got, err := b.Run(context.Background(), opts, tt.restart)
+			if err != nil {
+				t.Fatalf("Backfiller.Run() error = %v", err)
+			}
+			if got != tt.wantResult {
+				t.Errorf("Backfiller.Run() = %+v, want %+v", got, tt.wantResult)
+			}
+			if fmt.Sprint(requests) != fmt.Sprint(tt.wantRequests) {
+				t.Errorf("requests = %v, want %v", requests, tt.wantRequests)
+			}
+			if history.operations != tt.wantResult.Documents {
+				t.Errorf("history operations = %v, want %v", history.operations, tt.wantResult.Documents)
+			}
+			if cp := checkpoints[opts.ID()]; !cp.Done {
+				t.Errorf("checkpoint = %+v, want done", cp)
+			}
+		})
+	}
+}
+
+func TestBackfiller_RunInvalidOptions(t *testing.T) {
+	from := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"emptyRange", Options{From: from, To: from, Window: time.Hour}},
+		{"noWindow", Options{From: from, To: from.Add(time.Hour)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBackfiller(http.DefaultClient, 1, &dataAccess{}, memoryCheckpoints{})
+			if _, err := b.Run(context.Background(), tt.opts, false); err == nil {
+				t.Errorf("Backfiller.Run() error = nil, want error")
+			}
+		})
+	}
+}
+
+func Test_historyQuery(t *testing.T) {
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		want   bson.M
+	}{
+		{"all", HistoryFilter{}, bson.M{}},
+		{"filtered", HistoryFilter{Country: "DE", Parameter: "pm10", From: from},
+			bson.M{"country": "DE", "parameter": "pm10", "date": bson.M{"$gte": from}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := historyQuery(tt.filter); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("historyQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package backfill
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Checkpoint records the progress of a backfill.
+type Checkpoint struct {
+	ID string `bson:"_id"`
+	// WindowStart is the start of the window in progress.
+	WindowStart time.Time `bson:"windowStart"`
+	// Page is the last completed page of the window in progress.
+	Page      int       `bson:"page"`
+	Done      bool      `bson:"done"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+// CheckpointStore persists backfill checkpoints.
+type CheckpointStore interface {
+	Load(ctx context.Context, id string) (Checkpoint, bool, error)
+	Save(ctx context.Context, cp Checkpoint) error
+}
+
+type mongoCheckpoints struct {
+	col *mongo.Collection
+}
+
+// NewMongoCheckpoints creates a CheckpointStore backed by a mongo collection.
+func NewMongoCheckpoints(col *mongo.Collection) CheckpointStore {
+	return mongoCheckpoints{col}
+}
+
+func (m mongoCheckpoints) Load(ctx context.Context, id string) (Checkpoint, bool, error) {
+	var cp Checkpoint
+	err := m.col.FindOne(ctx, bson.M{"_id": id}).Decode(&cp)
+	if err == mongo.ErrNoDocuments {
+		return cp, false, nil
+	}
+	if err != nil {
+		return cp, false, err
+	}
+	return cp, true, nil
+}
+
+func (m mongoCheckpoints) Save(ctx context.Context, cp Checkpoint) error {
+	cp.UpdatedAt = time.Now().UTC()
+	_, err := m.col.ReplaceOne(ctx, bson.M{"_id": cp.ID}, cp, options.Replace().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,74 @@
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// HistoryFilter selects historical measurements. Empty fields match all measurements, To is exclusive.
+type HistoryFilter struct {
+	Country   string
+	City      string
+	Parameter string
+	From      time.Time
+	To        time.Time
+}
+
+// HistoryReader reads historical measurements.
+type HistoryReader interface {
+	// Each calls fn for every measurement matching filter ordered by location, parameter and date.
+	Each(ctx context.Context, filter HistoryFilter, fn func(HistoryMeasurement) error) error
+}
+
+type mongoHistory struct {
+	col *mongo.Collection
+}
+
+// NewMongoHistory creates a HistoryReader of a mongo collection.
+func NewMongoHistory(col *mongo.Collection) HistoryReader {
+	return mongoHistory{col}
+}
+
+func (m mongoHistory) Each(ctx context.Context, filter HistoryFilter, fn func(HistoryMeasurement) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "location", Value: 1}, {Key: "parameter", Value: 1}, {Key: "date", Value: 1}})
+	cursor, err := m.col.Find(ctx, historyQuery(filter), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var measurement HistoryMeasurement
+		if err := cursor.Decode(&measurement); err != nil {
+			return fmt.Errorf("error decoding history measurement: %w", err)
+		}
+		if err := fn(measurement); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func historyQuery(filter HistoryFilter) bson.M {
+	query := bson.M{}
+	for field, value := range map[string]string{"country": filter.Country, "city": filter.City, "parameter": filter.Parameter} {
+		if value != "" {
+			query[field] = value
+		}
+	}
+	date := bson.M{}
+	if !filter.From.IsZero() {
+		date["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		date["$lt"] = filter.To
+	}
+	if len(date) > 0 {
+		query["date"] = date
+	}
+	return query
+}
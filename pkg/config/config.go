@@ -0,0 +1,491 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/scheduler"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds all settings of the service.
+type Config struct {
+	API             API             `yaml:"api"`
+	SensorCommunity SensorCommunity `yaml:"sensorCommunity"`
+	Storage         Storage         `yaml:"storage"`
+	Mongo           Mongo           `yaml:"mongo"`
+	Sync            Sync            `yaml:"sync"`
+	Lease           Lease           `yaml:"lease"`
+	Archive         Archive         `yaml:"archive"`
+	Rollups         Rollups         `yaml:"rollups"`
+	AirQuality      AirQuality      `yaml:"airQuality"`
+	Migrations      Migrations      `yaml:"migrations"`
+	Tracing         Tracing         `yaml:"tracing"`
+	Runs            Runs            `yaml:"runs"`
+	Admin           Admin           `yaml:"admin"`
+	ReadAPI         ReadAPI         `yaml:"readApi"`
+	Stream          Stream          `yaml:"stream"`
+	Datasets        Datasets        `yaml:"datasets"`
+}
+
+// API configures the access to the AQ api.
+type API struct {
+	Endpoint     string        `yaml:"endpoint"`
+	BatchSize    int           `yaml:"batchSize"`
+	RetryCount   int           `yaml:"retryCount"`
+	RetryWaitMin time.Duration `yaml:"retryWaitMin"`
+	RetryWaitMax time.Duration `yaml:"retryWaitMax"`
+}
+
+// SensorCommunity configures the ingestion of the low-cost sensors of Sensor.Community.
+type SensorCommunity struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint is the base url of the data feed.
+	Endpoint string `yaml:"endpoint"`
+	// StaleAfter deletes sensors that were not in the feed for this long.
+	StaleAfter time.Duration `yaml:"staleAfter"`
+}
+
+// Storage selects where datasets are stored.
+type Storage struct {
+	// Backend is mongo or sqlite.
+	Backend    string `yaml:"backend"`
+	SQLitePath string `yaml:"sqlitePath"`
+}
+
+// Storage backends.
+const (
+	BackendMongo  = "mongo"
+	BackendSQLite = "sqlite"
+)
+
+// Mongo configures the database. Zero values of the connection options keep the value of the
+// uri or the driver default.
+type Mongo struct {
+	URI string `yaml:"uri" secret:"url"`
+	// URIFile is the path of a file containing the uri, e.g. a mounted secret. It takes precedence over URI.
+	URIFile                string        `yaml:"uriFile"`
+	Database               string        `yaml:"database"`
+	ConnectTimeout         time.Duration `yaml:"connectTimeout"`
+	AppName                string        `yaml:"appName"`
+	MinPoolSize            int           `yaml:"minPoolSize"`
+	MaxPoolSize            int           `yaml:"maxPoolSize"`
+	MaxConnIdleTime        time.Duration `yaml:"maxConnIdleTime"`
+	ServerSelectionTimeout time.Duration `yaml:"serverSelectionTimeout"`
+	SocketTimeout          time.Duration `yaml:"socketTimeout"`
+	HeartbeatInterval      time.Duration `yaml:"heartbeatInterval"`
+	// ReadPreference is one of primary, primaryPreferred, secondary, secondaryPreferred or nearest.
+	ReadPreference string        `yaml:"readPreference"`
+	MaxStaleness   time.Duration `yaml:"maxStaleness"`
+	WriteConcern   WriteConcern  `yaml:"writeConcern"`
+	TLS            TLS           `yaml:"tls"`
+	Validation     Validation    `yaml:"validation"`
+}
+
+// WriteConcern configures the acknowledgement of writes.
+type WriteConcern struct {
+	// W is the number of nodes, "majority" or a tag set name.
+	W       string        `yaml:"w"`
+	Journal bool          `yaml:"journal"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// TLS configures encrypted connections to the database.
+type TLS struct {
+	Enabled bool `yaml:"enabled"`
+	// CAFile is a PEM bundle of the certificate authorities trusted in addition to the system pool.
+	CAFile string `yaml:"caFile"`
+	// CertFile and KeyFile are the PEM encoded client certificate and key.
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// Validation configures the $jsonSchema validators of the collections of the datasets.
+type Validation struct {
+	Enabled bool `yaml:"enabled"`
+	// Level is off, strict or moderate, which does not validate updates of invalid documents.
+	Level string `yaml:"level"`
+	// Action is error to reject invalid documents or warn to only log them on the server.
+	Action string `yaml:"action"`
+}
+
+// Sync configures how datasets are synced.
+type Sync struct {
+	// DefaultSchedule is used for datasets without a schedule.
+	DefaultSchedule  string        `yaml:"defaultSchedule"`
+	RunTimeout       time.Duration `yaml:"runTimeout"`
+	RunOnStart       bool          `yaml:"runOnStart"`
+	Incremental      bool          `yaml:"incremental"`
+	IncrementalParam string        `yaml:"incrementalParam"`
+	WatermarkOverlap time.Duration `yaml:"watermarkOverlap"`
+	FullSyncInterval time.Duration `yaml:"fullSyncInterval"`
+	CheckpointMaxAge time.Duration `yaml:"checkpointMaxAge"`
+}
+
+// Lease configures the leader election between replicas.
+type Lease struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+	Owner   string        `yaml:"owner"`
+}
+
+// Archive configures the archive of raw api responses.
+type Archive struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+	// MaxAge and MaxSizeMb limit the archive, 0 disables a limit.
+	MaxAge    time.Duration `yaml:"maxAge"`
+	MaxSizeMb int           `yaml:"maxSizeMb"`
+}
+
+// Rollups configures the hourly and daily rollups of the measurements.
+type Rollups struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// AirQuality configures the current air quality of cities and countries.
+type AirQuality struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxAge is the age after which a measurement is no longer current.
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// Runs configures the history of sync runs.
+type Runs struct {
+	Enabled bool `yaml:"enabled"`
+	// Retention is the time after which a run is removed from the history.
+	Retention time.Duration `yaml:"retention"`
+}
+
+// Admin configures the admin HTTP API of the serve command.
+type Admin struct {
+	Enabled bool   `yaml:"enabled"`
+	Addr    string `yaml:"addr"`
+	// Token is the bearer token the requests have to send.
+	Token string `yaml:"token" secret:"true"`
+	// TokenFile is the path of a file containing the token, e.g. a mounted secret. It takes
+	// precedence over Token.
+	TokenFile string `yaml:"tokenFile"`
+}
+
+// BearerToken returns the content of TokenFile if set or Token otherwise.
+func (a Admin) BearerToken() (string, error) {
+	if a.TokenFile == "" {
+		return a.Token, nil
+	}
+	data, err := os.ReadFile(a.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading admin token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("admin token file %s is empty", a.TokenFile)
+	}
+	return token, nil
+}
+
+// ReadAPI configures the read api of the serve-api command.
+type ReadAPI struct {
+	Addr string `yaml:"addr"`
+}
+
+// Stream configures the Server-Sent Events stream of changed locations of the serve command.
+type Stream struct {
+	Enabled bool   `yaml:"enabled"`
+	Addr    string `yaml:"addr"`
+	// Heartbeat is the interval of comments that keep idle connections open.
+	Heartbeat time.Duration `yaml:"heartbeat"`
+	// Buffer is the number of locations a subscriber may fall behind before it is disconnected.
+	Buffer int `yaml:"buffer"`
+	// AllowOrigin is sent as Access-Control-Allow-Origin, e.g. * for browsers of all origins.
+	AllowOrigin string `yaml:"allowOrigin"`
+}
+
+// Tracing configures the export of OpenTelemetry spans of the syncs.
+type Tracing struct {
+	Enabled bool `yaml:"enabled"`
+	// Exporter is otlp to send the spans to a collector, stdout or file for environments without one.
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the host:port of the OTLP/HTTP collector, empty uses $OTEL_EXPORTER_OTLP_ENDPOINT
+	// or localhost:4318.
+	Endpoint string `yaml:"endpoint"`
+	Insecure bool   `yaml:"insecure"`
+	// File is the path the file exporter appends the spans to.
+	File string `yaml:"file"`
+	// SampleRatio is the fraction of the runs that are traced.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// Exporters of spans.
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Migrations configures the migrations of the stored documents.
+type Migrations struct {
+	// OnStartup applies pending migrations before syncing.
+	OnStartup bool `yaml:"onStartup"`
+}
+
+// Datasets maps dataset names to their settings.
+type Datasets map[string]*Dataset
+
+// UnmarshalYAML merges the settings of the file into the existing settings of each dataset.
+func (d *Datasets) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: datasets must be a mapping", node.Line)
+	}
+	if *d == nil {
+		*d = make(Datasets)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := node.Content[i].Value
+		dataset, found := (*d)[name]
+		if !found {
+			dataset = &Dataset{Enabled: true}
+		}
+		data, err := yaml.Marshal(node.Content[i+1])
+		if err != nil {
+			return err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(dataset); err != nil && err != io.EOF {
+			return fmt.Errorf("dataset %s: %w", name, err)
+		}
+		(*d)[name] = dataset
+	}
+	return nil
+}
+
+// Dataset configures the sync of a single dataset.
+type Dataset struct {
+	Enabled  bool          `yaml:"enabled"`
+	Schedule string        `yaml:"schedule"`
+	Jitter   time.Duration `yaml:"jitter"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// Default returns the default configuration with an entry for each of datasets.
+func Default(datasets []string) *Config {
+	cfg := &Config{
+		API: API{
+			Endpoint:     "https://api.openaq.org",
+			BatchSize:    1000,
+			RetryCount:   3,
+			RetryWaitMin: 5 * time.Second,
+			RetryWaitMax: 30 * time.Second,
+		},
+		SensorCommunity: SensorCommunity{
+			Endpoint:   "https://data.sensor.community",
+			StaleAfter: 24 * time.Hour,
+		},
+		Storage: Storage{
+			Backend:    BackendMongo,
+			SQLitePath: "aq-dbsync.db",
+		},
+		Mongo: Mongo{
+			URI:            "mongodb://localhost:27018",
+			Database:       "AQ_DB",
+			ConnectTimeout: 30 * time.Second,
+			// Validation is opt-in, as documents stored by earlier versions may not match the schema.
+			Validation: Validation{
+				Level:  "moderate",
+				Action: "error",
+			},
+		},
+		Sync: Sync{
+			DefaultSchedule:  "@every 1h",
+			RunTimeout:       30 * time.Minute,
+			RunOnStart:       true,
+			Incremental:      true,
+			IncrementalParam: "date_from",
+			WatermarkOverlap: time.Hour,
+			FullSyncInterval: 24 * time.Hour,
+			CheckpointMaxAge: 2 * time.Hour,
+		},
+		Lease: Lease{
+			Enabled: true,
+			TTL:     time.Minute,
+			Owner:   defaultOwner(),
+		},
+		Archive: Archive{
+			Dir:    "archive",
+			MaxAge: 30 * 24 * time.Hour,
+		},
+		AirQuality: AirQuality{
+			Enabled: true,
+			MaxAge:  3 * time.Hour,
+		},
+		Migrations: Migrations{
+			OnStartup: true,
+		},
+		Runs: Runs{
+			Enabled:   true,
+			Retention: 30 * 24 * time.Hour,
+		},
+		Admin: Admin{
+			Addr: ":8081",
+		},
+		ReadAPI: ReadAPI{
+			Addr: ":8080",
+		},
+		Stream: Stream{
+			Addr:      ":8082",
+			Heartbeat: 30 * time.Second,
+			Buffer:    1000,
+		},
+		Tracing: Tracing{
+			Exporter:    ExporterOTLP,
+			File:        "traces.json",
+			SampleRatio: 1,
+		},
+		Datasets: make(Datasets),
+	}
+	for _, name := range datasets {
+		cfg.Datasets[name] = &Dataset{Enabled: true}
+	}
+	return cfg
+}
+
+func defaultOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Load returns the default configuration for datasets overridden by the config file at path,
+// if path is not empty, and by environment variables.
+func Load(path string, datasets []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default(datasets)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+	}
+	if err := applyEnv(cfg, lookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration and returns all problems found.
+func (c *Config) Validate(datasets []string) error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	endpoint, err := url.Parse(c.API.Endpoint)
+	check(err == nil && endpoint.Scheme != "" && endpoint.Host != "", "api.endpoint %q is not an absolute url", c.API.Endpoint)
+	check(c.API.BatchSize > 0, "api.batchSize must be positive")
+	check(c.API.RetryCount >= 0, "api.retryCount must not be negative")
+	check(c.API.RetryWaitMin >= 0, "api.retryWaitMin must not be negative")
+	check(c.API.RetryWaitMax >= c.API.RetryWaitMin, "api.retryWaitMax must not be smaller than api.retryWaitMin")
+	if c.SensorCommunity.Enabled {
+		endpoint, err := url.Parse(c.SensorCommunity.Endpoint)
+		check(err == nil && endpoint.Scheme != "" && endpoint.Host != "", "sensorCommunity.endpoint %q is not an absolute url", c.SensorCommunity.Endpoint)
+		check(c.SensorCommunity.StaleAfter > 0, "sensorCommunity.staleAfter must be positive")
+	}
+	check(c.Storage.Backend == BackendMongo || c.Storage.Backend == BackendSQLite,
+		"storage.backend %q must be %s or %s", c.Storage.Backend, BackendMongo, BackendSQLite)
+	check(c.Storage.Backend != BackendSQLite || c.Storage.SQLitePath != "", "storage.sqlitePath must be set for the sqlite backend")
+	check(c.Mongo.URI != "" || c.Mongo.URIFile != "", "mongo.uri or mongo.uriFile must be set")
+	check(c.Mongo.Database != "", "mongo.database must be set")
+	check(c.Mongo.ConnectTimeout > 0, "mongo.connectTimeout must be positive")
+	problems = append(problems, c.Mongo.problems()...)
+	_, err = scheduler.ParseSchedule(c.Sync.DefaultSchedule)
+	check(err == nil, "sync.defaultSchedule: %v", err)
+	check(c.Sync.RunTimeout > 0, "sync.runTimeout must be positive")
+	check(!c.Sync.Incremental || c.Sync.IncrementalParam != "", "sync.incrementalParam must be set for incremental syncs")
+	check(c.Sync.WatermarkOverlap >= 0, "sync.watermarkOverlap must not be negative")
+	check(c.Sync.FullSyncInterval > 0, "sync.fullSyncInterval must be positive")
+	check(c.Sync.CheckpointMaxAge >= 0, "sync.checkpointMaxAge must not be negative")
+	check(!c.Lease.Enabled || c.Lease.TTL > 0, "lease.ttl must be positive")
+	check(!c.Lease.Enabled || c.Lease.Owner != "", "lease.owner must be set")
+	check(!c.Archive.Enabled || c.Archive.Dir != "", "archive.dir must be set")
+	check(c.Archive.MaxAge >= 0, "archive.maxAge must not be negative")
+	check(c.Archive.MaxSizeMb >= 0, "archive.maxSizeMb must not be negative")
+	check(!c.Rollups.Enabled || c.Storage.Backend == BackendMongo, "rollups.enabled requires the %s storage backend", BackendMongo)
+	check(!c.AirQuality.Enabled || c.AirQuality.MaxAge > 0, "airQuality.maxAge must be positive")
+	check(!c.Runs.Enabled || c.Runs.Retention >= time.Second, "runs.retention must be at least 1s")
+	check(!c.Admin.Enabled || c.Admin.Addr != "", "admin.addr must be set")
+	check(!c.Admin.Enabled || c.Admin.Token != "" || c.Admin.TokenFile != "", "admin.token or admin.tokenFile must be set")
+	check(c.ReadAPI.Addr != "", "readApi.addr must be set")
+	check(!c.Stream.Enabled || c.Stream.Addr != "", "stream.addr must be set")
+	check(!c.Stream.Enabled || c.Stream.Heartbeat > 0, "stream.heartbeat must be positive")
+	check(!c.Stream.Enabled || c.Stream.Buffer > 0, "stream.buffer must be positive")
+	check(c.Tracing.Exporter == ExporterOTLP || c.Tracing.Exporter == ExporterStdout || c.Tracing.Exporter == ExporterFile,
+		"tracing.exporter %q must be %s, %s or %s", c.Tracing.Exporter, ExporterOTLP, ExporterStdout, ExporterFile)
+	check(c.Tracing.Exporter != ExporterFile || c.Tracing.File != "", "tracing.file must be set for the %s exporter", ExporterFile)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1")
+
+	known := make(map[string]bool)
+	for _, name := range datasets {
+		known[name] = true
+	}
+	for _, name := range c.datasetNames() {
+		dataset := c.Datasets[name]
+		if !known[name] {
+			problems = append(problems, fmt.Sprintf("unknown dataset %q, valid datasets are %s", name, strings.Join(datasets, ",")))
+			continue
+		}
+		if dataset.Schedule != "" {
+			_, err := scheduler.ParseSchedule(dataset.Schedule)
+			check(err == nil, "datasets.%s.schedule: %v", name, err)
+		}
+		check(dataset.Jitter >= 0, "datasets.%s.jitter must not be negative", name)
+		check(dataset.Timeout >= 0, "datasets.%s.timeout must not be negative", name)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Schedule returns the schedule of a dataset.
+func (c *Config) Schedule(dataset string) string {
+	if schedule := c.Datasets[dataset].Schedule; schedule != "" {
+		return schedule
+	}
+	return c.Sync.DefaultSchedule
+}
+
+// Timeout returns the maximum duration of a sync of a dataset.
+func (c *Config) Timeout(dataset string) time.Duration {
+	if timeout := c.Datasets[dataset].Timeout; timeout > 0 {
+		return timeout
+	}
+	return c.Sync.RunTimeout
+}
+
+func (c *Config) datasetNames() []string {
+	names := make([]string, 0, len(c.Datasets))
+	for name := range c.Datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testDatasets = []string{"cities", "countries", "measurements"}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_Load(t *testing.T) {
+	file := `
+api:
+  batchSize: 500
+  retryWaitMin: 2s
+mongo:
+  database: file_db
+datasets:
+  cities:
+    schedule: "0 3 * * *"
+  countries:
+    enabled: false
+`
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		check   func(t *testing.T, cfg *Config)
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.API.BatchSize != 1000 || !cfg.Datasets["cities"].Enabled {
+					t.Errorf("unexpected defaults %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "file",
+			file: file,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.API.BatchSize != 500 || cfg.API.RetryWaitMin != 2*time.Second || cfg.API.RetryCount != 3 {
+					t.Errorf("unexpected api %+v", cfg.API)
+				}
+				if cfg.Schedule("cities") != "0 3 * * *" || !cfg.Datasets["cities"].Enabled {
+					t.Errorf("unexpected cities %+v", cfg.Datasets["cities"])
+				}
+				if cfg.Datasets["countries"].Enabled || cfg.Schedule("countries") != "@every 1h" {
+					t.Errorf("unexpected countries %+v", cfg.Datasets["countries"])
+				}
+			},
+		},
+		{
+			name: "env overrides file",
+			file: file,
+			env: map[string]string{
+				"AQ_API_BATCH_SIZE":           "200",
+				"AQ_MONGO_DATABASE":           "env_db",
+				"AQ_DATASETS_CITIES_SCHEDULE": "@every 5m",
+				"AQ_SYNC_RUN_TIMEOUT":         "1m",
+				"mongodb":                     "mongodb://legacy",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.API.BatchSize != 200 || cfg.Mongo.Database != "env_db" || cfg.Sync.RunTimeout != time.Minute {
+					t.Errorf("unexpected config %+v", cfg)
+				}
+				if cfg.Schedule("cities") != "@every 5m" || cfg.Mongo.URI != "mongodb://legacy" {
+					t.Errorf("unexpected config %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "new mongo env overrides legacy",
+			env:  map[string]string{"mongodb": "mongodb://legacy", "AQ_MONGO_URI": "mongodb://new"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Mongo.URI != "mongodb://new" {
+					t.Errorf("Mongo.URI = %v", cfg.Mongo.URI)
+				}
+			},
+		},
+		{name: "invalid env", env: map[string]string{"AQ_API_BATCH_SIZE": "many"}, wantErr: true},
+		{name: "unknown field", file: "api:\n  batchsize: 1\n", wantErr: true},
+		{name: "unknown dataset field", file: "datasets:\n  cities:\n    interval: 1h\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.file != "" {
+				path = writeFile(t, tt.file)
+			}
+			cfg, err := Load(path, testDatasets, env(tt.env))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				tt.check(t, cfg)
+			}
+		})
+	}
+}
+
+func Test_Config_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr []string
+	}{
+		{"valid", func(cfg *Config) {}, nil},
+		{"several problems", func(cfg *Config) {
+			cfg.API.Endpoint = "api.openaq.org"
+			cfg.API.BatchSize = 0
+			cfg.API.RetryWaitMax = time.Second
+		}, []string{"api.endpoint", "api.batchSize", "api.retryWaitMax"}},
+		{"sensor community", func(cfg *Config) {
+			cfg.SensorCommunity.Enabled = true
+			cfg.SensorCommunity.Endpoint = "/static"
+			cfg.SensorCommunity.StaleAfter = 0
+		}, []string{"sensorCommunity.endpoint", "sensorCommunity.staleAfter"}},
+		{"invalid schedule", func(cfg *Config) {
+			cfg.Datasets["cities"].Schedule = "every hour"
+		}, []string{"datasets.cities.schedule"}},
+		{"archive", func(cfg *Config) {
+			cfg.Archive.Enabled = true
+			cfg.Archive.Dir = ""
+			cfg.Archive.MaxSizeMb = -1
+		}, []string{"archive.dir", "archive.maxSizeMb"}},
+		{"rollups", func(cfg *Config) {
+			cfg.Rollups.Enabled = true
+			cfg.Storage.Backend = BackendSQLite
+			cfg.Storage.SQLitePath = "aq.db"
+		}, []string{"rollups.enabled"}},
+		{"air quality", func(cfg *Config) {
+			cfg.AirQuality.MaxAge = 0
+		}, []string{"airQuality.maxAge"}},
+		{"admin", func(cfg *Config) {
+			cfg.Admin.Enabled = true
+			cfg.Admin.Addr = ""
+		}, []string{"admin.addr", "admin.token"}},
+		{"read api", func(cfg *Config) {
+			cfg.ReadAPI.Addr = ""
+		}, []string{"readApi.addr"}},
+		{"stream", func(cfg *Config) {
+			cfg.Stream.Enabled = true
+			cfg.Stream.Heartbeat = 0
+			cfg.Stream.Buffer = 0
+		}, []string{"stream.heartbeat", "stream.buffer"}},
+		{"unknown dataset", func(cfg *Config) {
+			cfg.Datasets["weather"] = &Dataset{Enabled: true}
+		}, []string{`unknown dataset "weather"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default(testDatasets)
+			tt.modify(cfg)
+			err := cfg.Validate(testDatasets)
+			if (err != nil) != (len(tt.wantErr) > 0) {
+				t.Fatalf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Config.Validate() error = %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_envName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"batchSize", "BATCH_SIZE"},
+		{"uri", "URI"},
+		{"retryWaitMin", "RETRY_WAIT_MIN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := envName(tt.name); got != tt.want {
+				t.Errorf("envName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_redactURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		want   string
+	}{
+		{"empty", "", ""},
+		{"no password", "mongodb://localhost:27018", "mongodb://localhost:27018"},
+		{"password", "mongodb://user:pass@db:27017/?tls=true", "mongodb://user:REDACTED@db:27017/?tls=true"},
+		{"token", "abcdef", "REDACTED"},
+		{"token with scheme", "abc:def", "REDACTED"},
+		{"query password", "mongodb://db/?tls=true&password=pass", "mongodb://db/?password=REDACTED&tls=true"},
+		{"query secrets", "mongodb://db/?authSource=admin&tlsCertificateKeyFilePassword=pass", "mongodb://db/?authSource=admin&tlsCertificateKeyFilePassword=REDACTED"},
+		{"aws session token", "mongodb://db/?authMechanism=MONGODB-AWS&authMechanismProperties=AWS_SESSION_TOKEN:abc", "mongodb://db/?authMechanism=MONGODB-AWS&authMechanismProperties=REDACTED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactURL(tt.secret); got != tt.want {
+				t.Errorf("redactURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Config_Redacted(t *testing.T) {
+	cfg := Default(testDatasets)
+	cfg.Mongo.URI = "mongodb://user:pass@db"
+	redacted := cfg.Redacted()
+	if redacted.Mongo.URI != "mongodb://user:REDACTED@db" {
+		t.Errorf("Redacted().Mongo.URI = %v", redacted.Mongo.URI)
+	}
+	if cfg.Mongo.URI != "mongodb://user:pass@db" {
+		t.Errorf("Redacted() modified the config")
+	}
+	// Secrets that are not urls are replaced even if they parse as one.
+	cfg.Admin.Token = "abc:def"
+	if token := cfg.Redacted().Admin.Token; token != "REDACTED" {
+		t.Errorf("Redacted().Admin.Token = %v", token)
+	}
+}
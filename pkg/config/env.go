@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EnvPrefix is the prefix of all environment variables that override settings.
+const EnvPrefix = "AQ_"
+
+// legacyMongoURIEnv is the environment variable used for the mongo uri before AQ_MONGO_URI.
+const legacyMongoURIEnv = "mongodb"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides settings with environment variables named after their path in the config file,
+// e.g. AQ_API_BATCH_SIZE for api.batchSize or AQ_DATASETS_CITIES_SCHEDULE for datasets.cities.schedule.
+func applyEnv(cfg *Config, lookupEnv func(string) (string, bool)) error {
+	if uri, ok := lookupEnv(legacyMongoURIEnv); ok && uri != "" {
+		cfg.Mongo.URI = uri
+	}
+	return applyEnvValue(reflect.ValueOf(cfg).Elem(), strings.TrimSuffix(EnvPrefix, "_"), lookupEnv)
+}
+
+func applyEnvValue(v reflect.Value, name string, lookupEnv func(string) (string, bool)) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if err := applyEnvValue(v.Field(i), name+"_"+envName(tag), lookupEnv); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			if err := applyEnvValue(v.MapIndex(key).Elem(), name+"_"+envName(key.String()), lookupEnv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	value, ok := lookupEnv(name)
+	if !ok {
+		return nil
+	}
+	if err := setValue(v, value); err != nil {
+		return fmt.Errorf("invalid value of %s: %w", name, err)
+	}
+	return nil
+}
+
+// setValue parses value into v.
+func setValue(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// envName converts a camel case name to upper snake case.
+func envName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		if r == '-' {
+			r = '_'
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// problems returns the invalid connection options.
+func (m Mongo) problems() []string {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(m.MinPoolSize >= 0, "mongo.minPoolSize must not be negative")
+	check(m.MaxPoolSize >= 0, "mongo.maxPoolSize must not be negative")
+	check(m.MaxPoolSize == 0 || m.MinPoolSize <= m.MaxPoolSize, "mongo.minPoolSize must not be greater than mongo.maxPoolSize")
+	check(m.MaxConnIdleTime >= 0, "mongo.maxConnIdleTime must not be negative")
+	check(m.ServerSelectionTimeout >= 0, "mongo.serverSelectionTimeout must not be negative")
+	check(m.SocketTimeout >= 0, "mongo.socketTimeout must not be negative")
+	check(m.HeartbeatInterval >= 0, "mongo.heartbeatInterval must not be negative")
+	if m.ReadPreference != "" {
+		_, err := readpref.ModeFromString(m.ReadPreference)
+		check(err == nil, "mongo.readPreference %q is not a valid read preference", m.ReadPreference)
+	}
+	check(m.MaxStaleness == 0 || m.ReadPreference != "" && m.ReadPreference != "primary",
+		"mongo.maxStaleness requires a read preference other than primary")
+	if w, err := strconv.Atoi(m.WriteConcern.W); err == nil {
+		check(w >= 0, "mongo.writeConcern.w must not be negative")
+	}
+	check(m.WriteConcern.Timeout >= 0, "mongo.writeConcern.timeout must not be negative")
+	tlsFiles := m.TLS.CAFile != "" || m.TLS.CertFile != "" || m.TLS.KeyFile != ""
+	check(m.TLS.Enabled || !tlsFiles, "mongo.tls.enabled must be set to use tls files")
+	check((m.TLS.CertFile == "") == (m.TLS.KeyFile == ""), "mongo.tls.certFile and mongo.tls.keyFile must be set together")
+	if v := m.Validation; v.Enabled {
+		check(v.Level == "off" || v.Level == "strict" || v.Level == "moderate",
+			"mongo.validation.level %q must be off, strict or moderate", v.Level)
+		check(v.Action == "error" || v.Action == "warn", "mongo.validation.action %q must be error or warn", v.Action)
+	}
+	return problems
+}
+
+// ConnectionURI returns the content of URIFile if set or URI otherwise.
+func (m Mongo) ConnectionURI() (string, error) {
+	if m.URIFile == "" {
+		return m.URI, nil
+	}
+	data, err := os.ReadFile(m.URIFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading mongo uri file: %w", err)
+	}
+	uri := strings.TrimSpace(string(data))
+	if uri == "" {
+		return "", fmt.Errorf("mongo uri file %s is empty", m.URIFile)
+	}
+	return uri, nil
+}
+
+// ClientOptions returns the options of the mongo client. Options that are set override the
+// corresponding options of the uri.
+func (m Mongo) ClientOptions() (*options.ClientOptions, error) {
+	uri, err := m.ConnectionURI()
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Client().ApplyURI(uri)
+	if m.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(m.ConnectTimeout)
+	}
+	if m.AppName != "" {
+		opts.SetAppName(m.AppName)
+	}
+	if m.MinPoolSize > 0 {
+		opts.SetMinPoolSize(uint64(m.MinPoolSize))
+	}
+	if m.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(uint64(m.MaxPoolSize))
+	}
+	if m.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(m.MaxConnIdleTime)
+	}
+	if m.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(m.ServerSelectionTimeout)
+	}
+	if m.SocketTimeout > 0 {
+		opts.SetSocketTimeout(m.SocketTimeout)
+	}
+	if m.HeartbeatInterval > 0 {
+		opts.SetHeartbeatInterval(m.HeartbeatInterval)
+	}
+	if m.ReadPreference != "" {
+		rp, err := m.readPreference()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	}
+	if wc := m.WriteConcern.writeConcern(); wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+	if m.TLS.Enabled {
+		tlsConfig, err := m.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mongo options: %w", err)
+	}
+	return opts, nil
+}
+
+func (m Mongo) readPreference() (*readpref.ReadPref, error) {
+	mode, err := readpref.ModeFromString(m.ReadPreference)
+	if err != nil {
+		return nil, err
+	}
+	var rpOpts []readpref.Option
+	if m.MaxStaleness > 0 {
+		rpOpts = append(rpOpts, readpref.WithMaxStaleness(m.MaxStaleness))
+	}
+	return readpref.New(mode, rpOpts...)
+}
+
+// writeConcern returns nil if no write concern is configured.
+func (w WriteConcern) writeConcern() *writeconcern.WriteConcern {
+	var opts []writeconcern.Option
+	switch n, err := strconv.Atoi(w.W); {
+	case w.W == "":
+	case w.W == "majority":
+		opts = append(opts, writeconcern.WMajority())
+	case err == nil:
+		opts = append(opts, writeconcern.W(n))
+	default:
+		opts = append(opts, writeconcern.WTagSet(w.W))
+	}
+	if w.Journal {
+		opts = append(opts, writeconcern.J(true))
+	}
+	if w.Timeout > 0 {
+		opts = append(opts, writeconcern.WTimeout(w.Timeout))
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	return writeconcern.New(opts...)
+}
+
+func (t TLS) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if t.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading mongo ca file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in mongo ca file %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading mongo client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// writeCert writes a self signed certificate and its key and returns their paths.
+func writeCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func Test_Mongo_ConnectionURI(t *testing.T) {
+	uriFile := writeFile(t, "mongodb://user:pass@db:27017\n")
+	tests := []struct {
+		name    string
+		mongo   Mongo
+		want    string
+		wantErr bool
+	}{
+		{"uri", Mongo{URI: "mongodb://localhost"}, "mongodb://localhost", false},
+		{"file overrides uri", Mongo{URI: "mongodb://localhost", URIFile: uriFile}, "mongodb://user:pass@db:27017", false},
+		{"missing file", Mongo{URIFile: filepath.Join(t.TempDir(), "missing")}, "", true},
+		{"empty file", Mongo{URIFile: writeFile(t, " \n")}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.mongo.ConnectionURI()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Mongo.ConnectionURI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Mongo.ConnectionURI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Mongo_ClientOptions(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	m := Mongo{
+		URI:                    "mongodb://localhost:27017/?maxPoolSize=5&appName=uri",
+		ConnectTimeout:         10 * time.Second,
+		AppName:                "aq-dbsync",
+		MinPoolSize:            2,
+		MaxPoolSize:            20,
+		ServerSelectionTimeout: 5 * time.Second,
+		ReadPreference:         "secondaryPreferred",
+		MaxStaleness:           2 * time.Minute,
+		WriteConcern:           WriteConcern{W: "majority", Journal: true, Timeout: time.Second},
+		TLS:                    TLS{Enabled: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "db"},
+	}
+	opts, err := m.ClientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *opts.AppName != "aq-dbsync" || *opts.MaxPoolSize != 20 || *opts.MinPoolSize != 2 {
+		t.Errorf("unexpected pool options %v %v %v", *opts.AppName, *opts.MaxPoolSize, *opts.MinPoolSize)
+	}
+	if *opts.ConnectTimeout != 10*time.Second || *opts.ServerSelectionTimeout != 5*time.Second {
+		t.Errorf("unexpected timeouts %v %v", *opts.ConnectTimeout, *opts.ServerSelectionTimeout)
+	}
+	if opts.ReadPreference.Mode() != readpref.SecondaryPreferredMode {
+		t.Errorf("ReadPreference = %v", opts.ReadPreference.Mode())
+	}
+	if staleness, _ := opts.ReadPreference.MaxStaleness(); staleness != 2*time.Minute {
+		t.Errorf("MaxStaleness = %v", staleness)
+	}
+	if opts.WriteConcern.GetW() != "majority" || !opts.WriteConcern.GetJ() || opts.WriteConcern.GetWTimeout() != time.Second {
+		t.Errorf("unexpected write concern %v", opts.WriteConcern)
+	}
+	if opts.TLSConfig == nil || opts.TLSConfig.ServerName != "db" || len(opts.TLSConfig.Certificates) != 1 || opts.TLSConfig.RootCAs == nil {
+		t.Errorf("unexpected tls config %+v", opts.TLSConfig)
+	}
+
+	m.TLS.CAFile = writeFile(t, "not a certificate")
+	if _, err := m.ClientOptions(); err == nil {
+		t.Errorf("Mongo.ClientOptions() with an invalid ca file did not fail")
+	}
+}
+
+func Test_WriteConcern_writeConcern(t *testing.T) {
+	tests := []struct {
+		name    string
+		wc      WriteConcern
+		wantNil bool
+		wantW   interface{}
+	}{
+		{"unset", WriteConcern{}, true, nil},
+		{"number", WriteConcern{W: "2"}, false, 2},
+		{"tag set", WriteConcern{W: "dc"}, false, "dc"},
+		{"journal only", WriteConcern{Journal: true}, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.wc.writeConcern()
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("WriteConcern.writeConcern() = %v, wantNil %v", got, tt.wantNil)
+			}
+			if got != nil && got.GetW() != tt.wantW {
+				t.Errorf("GetW() = %v, want %v", got.GetW(), tt.wantW)
+			}
+		})
+	}
+}
+
+func Test_Mongo_problems(t *testing.T) {
+	tests := []struct {
+		name    string
+		mongo   Mongo
+		wantErr []string
+	}{
+		{"valid", Mongo{MaxPoolSize: 10, MinPoolSize: 1, ReadPreference: "nearest", WriteConcern: WriteConcern{W: "1"}}, nil},
+		{"pool", Mongo{MinPoolSize: 5, MaxPoolSize: 2}, []string{"mongo.minPoolSize"}},
+		{"read preference", Mongo{ReadPreference: "closest"}, []string{"mongo.readPreference"}},
+		{"staleness", Mongo{MaxStaleness: time.Minute}, []string{"mongo.maxStaleness"}},
+		{"negative w", Mongo{WriteConcern: WriteConcern{W: "-1"}}, []string{"mongo.writeConcern.w"}},
+		{"tls files", Mongo{TLS: TLS{CertFile: "cert.pem"}}, []string{"mongo.tls.enabled", "mongo.tls.keyFile"}},
+		{"validation", Mongo{Validation: Validation{Enabled: true, Level: "lax", Action: "ignore"}}, []string{"mongo.validation.level", "mongo.validation.action"}},
+		{"validation disabled", Mongo{Validation: Validation{Level: "lax"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(tt.mongo.problems(), "\n")
+			if (got != "") != (len(tt.wantErr) > 0) {
+				t.Fatalf("Mongo.problems() = %v, want %v", got, tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(got, want) {
+					t.Errorf("Mongo.problems() = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration with all secrets replaced. Fields tagged
+// secret:"true" are replaced entirely. In urls, tagged secret:"url", only the password and
+// sensitive query parameters are replaced so that the remaining parts stay visible.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.Datasets = make(Datasets, len(c.Datasets))
+	for name, dataset := range c.Datasets {
+		d := *dataset
+		r.Datasets[name] = &d
+	}
+	redactValue(reflect.ValueOf(&r).Elem())
+	return &r
+}
+
+func redactValue(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			redactValue(field)
+			continue
+		}
+		if field.Kind() != reflect.String || field.String() == "" {
+			continue
+		}
+		switch v.Type().Field(i).Tag.Get("secret") {
+		case "true":
+			field.SetString(redacted)
+		case "url":
+			field.SetString(redactURL(field.String()))
+		}
+	}
+}
+
+// sensitiveParams are query parameters of urls that hold credentials, besides those named like
+// passwords, secrets or tokens.
+var sensitiveParams = map[string]bool{"authmechanismproperties": true}
+
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	return sensitiveParams[name] || strings.Contains(name, "password") || strings.Contains(name, "secret") || strings.Contains(name, "token")
+}
+
+// redactURL replaces the password and the sensitive query parameters of a url. Values that are
+// not absolute urls are replaced entirely.
+func redactURL(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	u, err := url.Parse(secret)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return redacted
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	query := u.Query()
+	masked := false
+	for name := range query {
+		if isSensitiveParam(name) {
+			query.Set(name, redacted)
+			masked = true
+		}
+	}
+	if masked {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// YAML returns the configuration in the format of the config file.
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
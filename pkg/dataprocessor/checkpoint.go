@@ -0,0 +1,83 @@
+package dataprocessor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Checkpoint records the progress of a ProcessData run of a dataset.
+type Checkpoint struct {
+	Dataset string `bson:"_id"`
+	RunID   string `bson:"runId"`
+	URL     string `bson:"url"`
+	// Page is the last completed page.
+	Page      int       `bson:"page"`
+	Total     int       `bson:"total"`
+	StartedAt time.Time `bson:"startedAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+// CheckpointStore persists the checkpoints of unfinished runs.
+type CheckpointStore interface {
+	// LoadCheckpoint returns the checkpoint of dataset or nil if there is none.
+	LoadCheckpoint(ctx context.Context, dataset string) (*Checkpoint, error)
+	SaveCheckpoint(ctx context.Context, cp Checkpoint) error
+	DeleteCheckpoint(ctx context.Context, dataset string) error
+}
+
+// WithCheckpoints makes ProcessData save a checkpoint after every completed page and resume
+// unfinished runs that are younger than maxAge.
+func WithCheckpoints(store CheckpointStore, maxAge time.Duration) Option {
+	return func(d *dataProcessor) {
+		d.checkpoints = store
+		d.checkpointMaxAge = maxAge
+	}
+}
+
+// NewRunID returns a random run ID.
+func NewRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startCheckpoint returns the checkpoint of a resumable run or a new checkpoint.
+func (d dataProcessor) startCheckpoint(ctx context.Context, dataset string, url string) (Checkpoint, error) {
+	now := time.Now().UTC()
+	cp := Checkpoint{Dataset: dataset, RunID: NewRunID(), URL: url, StartedAt: now}
+	if d.checkpoints == nil {
+		return cp, nil
+	}
+	saved, err := d.checkpoints.LoadCheckpoint(ctx, dataset)
+	if err != nil {
+		return cp, fmt.Errorf("error loading checkpoint of %s: %w", dataset, err)
+	}
+	if saved != nil && saved.URL == url && now.Sub(saved.UpdatedAt) < d.checkpointMaxAge {
+		return *saved, nil
+	}
+	return cp, nil
+}
+
+func (d dataProcessor) saveCheckpoint(ctx context.Context, cp Checkpoint, page int, total int) error {
+	if d.checkpoints == nil {
+		return nil
+	}
+	cp.Page, cp.Total, cp.UpdatedAt = page, total, time.Now().UTC()
+	if err := d.checkpoints.SaveCheckpoint(context.WithoutCancel(ctx), cp); err != nil {
+		return fmt.Errorf("error saving checkpoint of %s: %w", cp.Dataset, err)
+	}
+	return nil
+}
+
+func (d dataProcessor) finishCheckpoint(ctx context.Context, dataset string) error {
+	if d.checkpoints == nil {
+		return nil
+	}
+	if err := d.checkpoints.DeleteCheckpoint(context.WithoutCancel(ctx), dataset); err != nil {
+		return fmt.Errorf("error deleting checkpoint of %s: %w", dataset, err)
+	}
+	return nil
+}
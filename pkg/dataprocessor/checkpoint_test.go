@@ -0,0 +1,89 @@
+package dataprocessor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+type memoryCheckpoints map[string]Checkpoint
+
+func (m memoryCheckpoints) LoadCheckpoint(ctx context.Context, dataset string) (*Checkpoint, error) {
+	cp, found := m[dataset]
+	if !found {
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+func (m memoryCheckpoints) SaveCheckpoint(ctx context.Context, cp Checkpoint) error {
+	m[cp.Dataset] = cp
+	return nil
+}
+
+func (m memoryCheckpoints) DeleteCheckpoint(ctx context.Context, dataset string) error {
+	delete(m, dataset)
+	return nil
+}
+
+func Test_dataProcessor_ProcessDataCheckpoints(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name           string
+		checkpoint     *Checkpoint
+		failPage       string
+		wantPages      []string
+		wantErr        bool
+		wantCheckpoint *int
+	}{
+		{"new", nil, "", []string{"1", "2", "3", "4", "5", "6"}, false, nil},
+		{"resume", &Checkpoint{Dataset: "test", RunID: "run", URL: "asdt", Page: 3, Total: 500, UpdatedAt: now}, "", []string{"4", "5", "6"}, false, nil},
+		{"expired", &Checkpoint{Dataset: "test", RunID: "run", URL: "asdt", Page: 3, Total: 500, UpdatedAt: now.Add(-2 * time.Hour)}, "", []string{"1", "2", "3", "4", "5", "6"}, false, nil},
+		{"otherURL", &Checkpoint{Dataset: "test", RunID: "run", URL: "other", Page: 3, Total: 500, UpdatedAt: now}, "", []string{"1", "2", "3", "4", "5", "6"}, false, nil},
+		{"pageError", nil, "4", []string{"1", "2", "3", "4", "5", "6"}, true, intPtr(3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages []string
+			dataProcessFunc := func(ctx context.Context, url string, repo storage.Repository) (int, error) {
+				page := strings.TrimPrefix(url, "asdt")
+				pages = append(pages, page)
+				if page == tt.failPage {
+					return 0, fmt.Errorf("VERY BAD ERROR")
+				}
+				return 500, nil
+			}
+			store := memoryCheckpoints{}
+			if tt.checkpoint != nil {
+				store[tt.checkpoint.Dataset] = *tt.checkpoint
+			}
+			d := NewDataProcessor(100, WithCheckpoints(store, time.Hour))
+			err := d.ProcessData(context.Background(), "test", "asdt", dataAcc, dataProcessFunc)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dataProcessor.ProcessData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(pages, tt.wantPages) {
+				t.Errorf("dataProcessor.ProcessData() pages = %v, want %v", pages, tt.wantPages)
+			}
+			cp, found := store["test"]
+			if tt.wantCheckpoint == nil {
+				if found {
+					t.Errorf("checkpoint = %+v, want none", cp)
+				}
+				return
+			}
+			if !found || cp.Page != *tt.wantCheckpoint || cp.Total != 500 {
+				t.Errorf("checkpoint = %+v, want page %d of 500", cp, *tt.wantCheckpoint)
+			}
+		})
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
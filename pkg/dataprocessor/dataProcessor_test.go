@@ -2,94 +2,76 @@ package dataprocessor
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
-	"reflect"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/jarcoal/httpmock"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/nhe23/aq-dbsync/pkg/source"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
 )
 
-const url string = "https://api.openaq.org/v1/latest"
-
-var dataAcc DataAccessInterface
-var dataAccErr DataAccessInterface
+var dataAcc storage.Repository
+var dataAccErr storage.Repository
 
 func init() {
 	dataAcc = NewDataAccess()
 	dataAccErr = NewDataAccessError()
-	httpmock.Activate()
-	// defer httpmock.DeactivateAndReset()
+}
 
-	// Exact URL match
-	httpmock.RegisterResponder("GET", url,
-		httpmock.NewStringResponder(200, `{
-			"meta": {
-				"name": "openaq-api",
-				"license": "CC BY 4.0",
-				"website": "https://docs.openaq.org/",
-				"page": 3,
-				"limit": 1,
-				"found": 12046
-			},
-			"results": [
-				{
-					"location": "1-r khoroolol",
-					"city": "Ulaanbaatar",
-					"country": "MN",
-					"distance": 6563510.382773982,
-					"measurements": [
-						{
-							"parameter": "pm10",
-							"value": 199,
-							"lastUpdated": "2019-03-13T21:45:00.000Z",
-							"unit": "µg/m³",
-							"sourceName": "Agaar.mn"
-						},
-						{
-							"parameter": "pm25",
-							"value": 217,
-							"lastUpdated": "2019-03-13T21:45:00.000Z",
-							"unit": "µg/m³",
-							"sourceName": "Agaar.mn"
-						},
-						{
-							"parameter": "so2",
-							"value": 21,
-							"lastUpdated": "2019-03-13T21:45:00.000Z",
-							"unit": "µg/m³",
-							"sourceName": "Agaar.mn"
-						},
-						{
-							"parameter": "no2",
-							"value": 30,
-							"lastUpdated": "2019-03-13T21:45:00.000Z",
-							"unit": "µg/m³",
-							"sourceName": "Agaar.mn"
-						},
-						{
-							"parameter": "co",
-							"value": 57,
-							"lastUpdated": "2019-03-13T21:45:00.000Z",
-							"unit": "µg/m³",
-							"sourceName": "Agaar.mn"
-						}
-					],
-					"coordinates": { "latitude": 47.91798, "longitude": 106.84806 }
-				}
-			]
-		}
-		`))
+var lastUpdated = time.Date(2019, 3, 13, 21, 45, 0, 0, time.UTC)
+
+// fakeSource serves a single page of results, or fails fetching if err is set.
+type fakeSource struct {
+	results []interface{}
+	err     error
+}
+
+func (f fakeSource) ID() string                                 { return "fake" }
+func (f fakeSource) Datasets() []source.Dataset                 { return nil }
+func (f fakeSource) PageSize() int                              { return 100 }
+func (f fakeSource) URL(dataset string, since time.Time) string { return "fake?page=" }
+
+func (f fakeSource) Fetch(ctx context.Context, url string) ([]interface{}, int, error) {
+	return f.results, 12046, f.err
+}
+
+// Documents maps the results, which are the documents themselves.
+func (f fakeSource) Documents(dataset string, results []interface{}) ([]storage.Document, error) {
+	var docs []storage.Document
+	for _, result := range results {
+		docs = append(docs, result.(storage.Document))
+	}
+	return docs, nil
+}
+
+// unknownDocument is a document of an unknown kind.
+type unknownDocument struct{}
+
+func (unknownDocument) Key() string { return "unknown" }
+
+func newFakeSource(err error) fakeSource {
+	return fakeSource{
+		results: []interface{}{
+			storage.Location{Location: "1-r khoroolol", City: "Ulaanbaatar", Country: "MN", Measurements: []storage.Measurement{
+				{Parameter: "pm10", Value: 199, LastUpdated: lastUpdated, Unit: "µg/m³"},
+				{Parameter: "pm25", Value: 217, LastUpdated: lastUpdated, Unit: "µg/m³"},
+			}},
+			storage.City{Name: "Ulaanbaatar", Country: "MN"},
+		},
+		err: err,
+	}
+}
+
+// recordingRepository records the upserted documents.
+type recordingRepository struct {
+	dataAccess
+	docs *[]storage.Document
 }
 
-func parseLocationResult(results []interface{}) locationResult {
-	res, _ := json.Marshal(results[0])
-	var loc locationResult
-	json.Unmarshal([]byte(res), &loc)
-	return loc
+func (r recordingRepository) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	*r.docs = append(*r.docs, docs...)
+	return storage.UpsertResult{Upserted: int64(len(docs))}, nil
 }
 
 type dataAccess struct {
@@ -98,328 +80,169 @@ type dataAccess struct {
 type dataAccessError struct {
 }
 
-// NewDataProcessor creates a dataProcessor.
-func NewDataAccess() DataAccessInterface {
+// NewDataAccess creates a repository that accepts all writes.
+func NewDataAccess() storage.Repository {
 	return dataAccess{}
 }
 
-func (d dataAccess) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
-	return nil, nil
+func (d dataAccess) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	return storage.UpsertResult{Upserted: int64(len(docs))}, nil
 }
 
-// NewDataProcessor creates a dataProcessor.
-func NewDataAccessError() DataAccessInterface {
-	return dataAccessError{}
+func (d dataAccess) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	return nil, nil
 }
 
-func (d dataAccessError) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
-	return nil, fmt.Errorf("VERY BAD ERROR")
+func (d dataAccess) DeleteStale(ctx context.Context, provider string, before time.Time) (int64, error) {
+	return 0, nil
 }
-func Test_dataProcessor_GetResults(t *testing.T) {
-	results := []interface{}{map[string]interface{}{"city": "Ulaanbaatar", "coordinates": map[string]interface{}{"latitude": 47.91798, "longitude": 106.84806}, "country": "MN", "distance": 6.563510382773982e+06, "location": "1-r khoroolol", "measurements": []interface{}{map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "pm10", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 199}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "pm25", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 217}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "so2", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 21}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "no2", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 30}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "co", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 57}}}}
-	type fields struct {
-		httpClient *http.Client
-	}
-	type args struct {
-		url string
-	}
-	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   []interface{}
-		want1  int
-	}{
-		{"standard", fields{http.DefaultClient}, args{url}, results, 12046},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			d := dataProcessor{
-				httpClient: tt.fields.httpClient,
-			}
-			got, got1, err := d.getResults(tt.args.url)
 
-			gotLoc := parseLocationResult(got)
-			wantLoc := parseLocationResult(tt.want)
-			if err != nil {
-				t.Errorf("dataProcessor.GetResults() got error")
-			}
-			if !reflect.DeepEqual(gotLoc, wantLoc) {
-				t.Errorf("dataProcessor.GetResults() got = %v, want %v", got, tt.want)
-			}
-			if got1 != tt.want1 {
-				t.Errorf("dataProcessor.GetResults() got1 = %v, want %v", got1, tt.want1)
-			}
-		})
-	}
+// NewDataAccessError creates a repository that fails all operations.
+func NewDataAccessError() storage.Repository {
+	return dataAccessError{}
 }
 
-func Test_dataProcessor_upsertCollection(t *testing.T) {
-	results := []interface{}{map[string]interface{}{"city": "Ulaanbaatar", "coordinates": map[string]interface{}{"latitude": 47.91798, "longitude": 106.84806}, "country": "MN", "distance": 6.563510382773982e+06, "location": "1-r khoroolol", "measurements": []interface{}{map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "pm10", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 199}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "pm25", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 217}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "so2", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 21}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "no2", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 30}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "co", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 57}}}}
-	type fields struct {
-		httpClient *http.Client
-	}
-	type args struct {
-		collection   DataAccessInterface
-		resultsSlice []interface{}
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		{"standard", fields{http.DefaultClient}, args{dataAcc, results}, false},
-		{"error", fields{http.DefaultClient}, args{dataAccErr, results}, true},
-	}
-	var locResult locationResult
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			d := dataProcessor{
-				httpClient: tt.fields.httpClient,
-			}
-			if err := d.upsertCollection(tt.args.collection, tt.args.resultsSlice, &locResult, &locResult.Location, "location"); (err != nil) != tt.wantErr {
-				t.Errorf("upsertCollection() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
+func (d dataAccessError) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	return storage.UpsertResult{}, fmt.Errorf("VERY BAD ERROR")
 }
 
-func Test_bulkUpdateResult(t *testing.T) {
-	type args struct {
-		collection DataAccessInterface
-		operations []mongo.WriteModel
-	}
-	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
-	}{
-		{"standard", args{dataAcc, nil}, false},
-		{"error", args{dataAccErr, nil}, true},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := bulkUpdateResult(tt.args.collection, tt.args.operations); (err != nil) != tt.wantErr {
-				t.Errorf("bulkUpdateResult() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
+func (d dataAccessError) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	return nil, fmt.Errorf("VERY BAD ERROR")
 }
 
-func Test_deepCopy(t *testing.T) {
-	type Test struct {
-		Prop1 int
-		Prop2 string
-	}
-	testObj := Test{1, "asdf"}
-	type args struct {
-		v interface{}
-	}
-	tests := []struct {
-		name    string
-		args    args
-		want    interface{}
-		wantErr bool
-	}{
-		{"standard", args{testObj}, testObj, false},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := deepCopy(tt.args.v)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("deepCopy() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("deepCopy() = %v, want %v", got, tt.want)
-			}
-		})
-	}
+func (d dataAccessError) DeleteStale(ctx context.Context, provider string, before time.Time) (int64, error) {
+	return 0, fmt.Errorf("VERY BAD ERROR")
 }
 
 func Test_dataProcessor_ProcessData(t *testing.T) {
-	mockDataProcessFunc := func(url string, collection DataAccessInterface) (int, error) {
+	mockDataProcessFunc := func(ctx context.Context, url string, collection storage.Repository) (int, error) {
 		return 5, nil
 	}
-	mockDataProcessFuncError := func(url string, collection DataAccessInterface) (int, error) {
+	mockDataProcessFuncError := func(ctx context.Context, url string, collection storage.Repository) (int, error) {
 		return 0, fmt.Errorf("VERY BAD ERROR")
 	}
-	type fields struct {
-		httpClient *http.Client
-		batchSize  int
+	mockDataProcessFuncPages := func(ctx context.Context, url string, collection storage.Repository) (int, error) {
+		return 500, ctx.Err()
 	}
-	type args struct {
-		url             string
-		collection      DataAccessInterface
-		dataProcessFunc func(url string, collection DataAccessInterface) (int, error)
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		{"standard", fields{http.DefaultClient, 100}, args{"asdt", dataAcc, mockDataProcessFunc}, false},
-		{"error", fields{http.DefaultClient, 100}, args{"asdt", dataAcc, mockDataProcessFuncError}, true},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			d := dataProcessor{
-				httpClient: tt.fields.httpClient,
-				batchSize:  tt.fields.batchSize,
-			}
-			if err := d.ProcessData(tt.args.url, tt.args.collection, tt.args.dataProcessFunc); (err != nil) != tt.wantErr {
-				t.Errorf("dataProcessor.ProcessData() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
+	mockDataProcessFuncPageError := func(ctx context.Context, url string, collection storage.Repository) (int, error) {
+		if strings.HasSuffix(url, "2") {
+			return 0, fmt.Errorf("VERY BAD ERROR")
+		}
+		return 500, nil
 	}
-}
-
-func Test_dataProcessor_ProcessMeasurements(t *testing.T) {
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
 	type fields struct {
-		httpClient *http.Client
-		batchSize  int
+		batchSize int
 	}
 	type args struct {
-		url        string
-		collection DataAccessInterface
+		ctx             context.Context
+		url             string
+		collection      storage.Repository
+		dataProcessFunc DataProcessFunc
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    int
-		wantErr bool
+		name      string
+		fields    fields
+		args      args
+		wantErr   bool
+		wantPage  int
+		wantPages int
 	}{
-		{"standard", fields{http.DefaultClient, 100}, args{url, dataAcc}, 12046, false},
-		{"error", fields{http.DefaultClient, 100}, args{url, dataAccErr}, 12046, true},
-		{"errorURL", fields{http.DefaultClient, 100}, args{"nonexistanturl.test", dataAccErr}, 0, true},
+		{"standard", fields{100}, args{context.Background(), "asdt", dataAcc, mockDataProcessFunc}, false, 1, 1},
+		{"error", fields{100}, args{context.Background(), "asdt", dataAcc, mockDataProcessFuncError}, true, 0, 0},
+		{"multiplePages", fields{100}, args{context.Background(), "asdt", dataAcc, mockDataProcessFuncPages}, false, 6, 6},
+		{"singlePage", fields{0}, args{context.Background(), "asdt", dataAcc, mockDataProcessFuncPages}, false, 1, 1},
+		{"cancelled", fields{100}, args{cancelledCtx, "asdt", dataAcc, mockDataProcessFuncPages}, true, 1, 6},
+		{"pageError", fields{100}, args{context.Background(), "asdt", dataAcc, mockDataProcessFuncPageError}, true, 6, 6},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			d := dataProcessor{
-				httpClient: tt.fields.httpClient,
-				batchSize:  tt.fields.batchSize,
+				batchSize: tt.fields.batchSize,
 			}
-			got, err := d.ProcessMeasurements(tt.args.url, tt.args.collection)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("dataProcessor.ProcessMeasurements() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			progress := &Progress{}
+			ctx := WithProgress(tt.args.ctx, progress)
+			if err := d.ProcessData(ctx, "test", tt.args.url, tt.args.collection, tt.args.dataProcessFunc); (err != nil) != tt.wantErr {
+				t.Errorf("dataProcessor.ProcessData() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if got != tt.want {
-				t.Errorf("dataProcessor.ProcessMeasurements() = %v, want %v", got, tt.want)
+			if page, pages := progress.Pages(); page != tt.wantPage || pages != tt.wantPages {
+				t.Errorf("Progress.Pages() = %d, %d, want %d, %d", page, pages, tt.wantPage, tt.wantPages)
 			}
 		})
 	}
 }
 
-func Test_dataProcessor_ProcessCities(t *testing.T) {
-	type fields struct {
-		httpClient *http.Client
-		batchSize  int
-	}
-	type args struct {
-		url        string
-		collection DataAccessInterface
-	}
+func Test_dataProcessor_Process(t *testing.T) {
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    int
-		wantErr bool
+		name       string
+		src        fakeSource
+		collection storage.Repository
+		want       int
+		wantErr    bool
 	}{
-		{"standard", fields{http.DefaultClient, 100}, args{url, dataAcc}, 12046, false},
-		{"error", fields{http.DefaultClient, 100}, args{url, dataAccErr}, 12046, true},
-		{"errorURL", fields{http.DefaultClient, 100}, args{"nonexistanturl.test", dataAccErr}, 0, true},
+		{"standard", newFakeSource(nil), dataAcc, 12046, false},
+		{"error", newFakeSource(nil), dataAccErr, 12046, true},
+		{"errorFetch", newFakeSource(fmt.Errorf("VERY BAD ERROR")), dataAcc, 0, true},
+		{"unexpectedDocument", fakeSource{results: []interface{}{unknownDocument{}}}, dataAcc, 0, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			d := dataProcessor{
-				httpClient: tt.fields.httpClient,
-				batchSize:  tt.fields.batchSize,
-			}
-			got, err := d.ProcessCities(tt.args.url, tt.args.collection)
+			d := dataProcessor{batchSize: 100}
+			got, err := d.Process(tt.src, "test")(context.Background(), "fake?page=1", tt.collection)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("dataProcessor.ProcessCities() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("dataProcessor.Process() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if got != tt.want {
-				t.Errorf("dataProcessor.ProcessCities() = %v, want %v", got, tt.want)
+				t.Errorf("dataProcessor.Process() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func Test_dataProcessor_ProcessCountries(t *testing.T) {
-	type fields struct {
-		httpClient *http.Client
-		batchSize  int
+func Test_dataProcessor_ProcessDocuments(t *testing.T) {
+	var docs []storage.Document
+	d := dataProcessor{batchSize: 100}
+	if _, err := d.Process(newFakeSource(nil), "test")(context.Background(), "fake?page=1", recordingRepository{docs: &docs}); err != nil {
+		t.Fatal(err)
 	}
-	type args struct {
-		url        string
-		collection DataAccessInterface
+	if len(docs) != 2 {
+		t.Fatalf("upserted %v", docs)
 	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    int
-		wantErr bool
-	}{
-		{"standard", fields{http.DefaultClient, 100}, args{url, dataAcc}, 12046, false},
-		{"error", fields{http.DefaultClient, 100}, args{url, dataAccErr}, 12046, true},
-		{"errorURL", fields{http.DefaultClient, 100}, args{"nonexistanturl.test", dataAccErr}, 0, true},
+	location := docs[0].(storage.Location)
+	if location.Provider != "fake" || location.SchemaVersion != storage.SchemaVersion || location.SyncedAt.IsZero() ||
+		location.Measurements[0].QualityIndex == 0 || location.Measurements[1].QualityIndex == 0 {
+		t.Errorf("location = %+v", location)
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			d := dataProcessor{
-				httpClient: tt.fields.httpClient,
-				batchSize:  tt.fields.batchSize,
-			}
-			got, err := d.ProcessCountries(tt.args.url, tt.args.collection)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("dataProcessor.ProcessCountries() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if got != tt.want {
-				t.Errorf("dataProcessor.ProcessCountries() = %v, want %v", got, tt.want)
-			}
-		})
+	if city := docs[1].(storage.City); city.Provider != "fake" || city.SchemaVersion != storage.SchemaVersion || city.SyncedAt.IsZero() {
+		t.Errorf("city = %+v", city)
 	}
 }
 
-func Test_dataProcessor_upsertMeasurements(t *testing.T) {
-	var results []locationResult
-	resultsInterface := []interface{}{map[string]interface{}{"city": "Ulaanbaatar", "coordinates": map[string]interface{}{"latitude": 47.91798, "longitude": 106.84806}, "country": "MN", "distance": 6.563510382773982e+06, "location": "1-r khoroolol", "measurements": []interface{}{map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "pm10", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 199}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "pm25", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 217}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "so2", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 21}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "no2", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 30}, map[string]interface{}{"lastUpdated": "2019-03-13T21:45:00.000Z", "parameter": "co", "sourceName": "Agaar.mn", "unit": "µg/m³", "value": 57}}}}
-	resultJSON, _ := json.Marshal(resultsInterface)
-	json.Unmarshal([]byte(resultJSON), &results)
-	type fields struct {
-		httpClient *http.Client
-		batchSize  int
-	}
-	type args struct {
-		collection DataAccessInterface
-		results    []locationResult
-	}
+func Test_dataProcessor_ProcessWatermark(t *testing.T) {
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name       string
+		since      time.Time
+		collection storage.Repository
+		wantErr    bool
 	}{
-		{"standard", fields{http.DefaultClient, 10}, args{dataAcc, results}, false},
-		{"error", fields{http.DefaultClient, 10}, args{dataAccErr, results}, true},
+		{"full", time.Time{}, dataAccErr, true},
+		{"newer", lastUpdated.Add(-time.Hour), dataAccErr, true},
+		{"unchanged", lastUpdated, dataAccErr, false},
 	}
+	src := newFakeSource(nil)
+	src.results = src.results[:1]
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			d := dataProcessor{
-				httpClient: tt.fields.httpClient,
-				batchSize:  tt.fields.batchSize,
+			d := dataProcessor{batchSize: 100}
+			w := NewWatermark(tt.since)
+			_, err := d.Process(src, "test")(WithWatermark(context.Background(), w), "fake?page=1", tt.collection)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dataProcessor.Process() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if err := d.upsertMeasurements(tt.args.collection, tt.args.results); (err != nil) != tt.wantErr {
-				t.Errorf("dataProcessor.upsertMeasurements() error = %v, wantErr %v", err, tt.wantErr)
+			if !w.Max().Equal(lastUpdated) {
+				t.Errorf("Watermark.Max() = %v, want %v", w.Max(), lastUpdated)
 			}
 		})
 	}
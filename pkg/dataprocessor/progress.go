@@ -0,0 +1,65 @@
+package dataprocessor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Progress tracks the pages processed by ProcessData.
+type Progress struct {
+	mu        sync.Mutex
+	page      int
+	pages     int
+	startedAt time.Time
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes ProcessData report its progress to p.
+func WithProgress(ctx context.Context, p *Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// ProgressFromContext returns the Progress of ctx or nil if there is none.
+func ProgressFromContext(ctx context.Context) *Progress {
+	p, _ := ctx.Value(progressKey{}).(*Progress)
+	return p
+}
+
+// Pages returns the last processed page and the number of pages, which is 0 until the first page
+// was processed. Pages skipped by a resumed sync count as processed.
+func (p *Progress) Pages() (page int, pages int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.page, p.pages
+}
+
+// StartedAt returns when the processed run started, which is before ProcessData was called if it
+// resumed the checkpoint of an unfinished run. It is zero if p is nil or no run started.
+func (p *Progress) StartedAt() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.startedAt
+}
+
+func (p *Progress) start(startedAt time.Time) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.startedAt = startedAt
+}
+
+func (p *Progress) set(page int, pages int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.page, p.pages = page, pages
+}
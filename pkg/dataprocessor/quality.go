@@ -0,0 +1,27 @@
+package dataprocessor
+
+// qualityThresholds holds the upper bounds in µg/m³ of the quality indices 1 to 5 of each
+// parameter. Higher values have the index 6.
+var qualityThresholds = map[string][5]float64{
+	"o3":   {60, 90, 130, 180, 240},
+	"pm10": {20, 35, 50, 100, 150},
+	"pm25": {10, 20, 30, 60, 90},
+	"no2":  {45, 100, 140, 200, 400},
+	"so2":  {50, 85, 120, 200, 500},
+	"co":   {2500, 3500, 5000, 10500, 20500},
+}
+
+// QualityIndex returns the quality index from 1 (good) to 6 (very bad) of a measured value, or 0
+// if the value, the parameter or the unit cannot be rated.
+func QualityIndex(parameter string, unit string, value float64) int {
+	thresholds, ok := qualityThresholds[parameter]
+	if !ok || unit != "µg/m³" || value <= 0 {
+		return 0
+	}
+	for i, threshold := range thresholds {
+		if value <= threshold {
+			return i + 1
+		}
+	}
+	return len(thresholds) + 1
+}
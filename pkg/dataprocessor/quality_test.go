@@ -0,0 +1,30 @@
+package dataprocessor
+
+import "testing"
+
+func Test_QualityIndex(t *testing.T) {
+	tests := []struct {
+		name      string
+		parameter string
+		unit      string
+		value     float64
+		want      int
+	}{
+		{"pm10 good", "pm10", "µg/m³", 12, 1},
+		{"pm10 upper bound", "pm10", "µg/m³", 35, 2},
+		{"pm25 very bad", "pm25", "µg/m³", 120, 6},
+		{"o3 moderate", "o3", "µg/m³", 100, 3},
+		{"co bad", "co", "µg/m³", 12000, 5},
+		{"invalid value", "no2", "µg/m³", -99, 0},
+		{"zero", "so2", "µg/m³", 0, 0},
+		{"other unit", "co", "ppm", 0.4, 0},
+		{"unknown parameter", "bc", "µg/m³", 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QualityIndex(tt.parameter, tt.unit, tt.value); got != tt.want {
+				t.Errorf("QualityIndex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
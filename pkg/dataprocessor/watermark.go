@@ -0,0 +1,62 @@
+package dataprocessor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// Watermark tracks the newest lastUpdated timestamp seen during an incremental sync.
+// Locations without a measurement newer than Since are not written.
+type Watermark struct {
+	Since time.Time
+
+	mu  sync.Mutex
+	max time.Time
+}
+
+type watermarkKey struct{}
+
+// NewWatermark creates a Watermark for a sync of data newer than since.
+func NewWatermark(since time.Time) *Watermark {
+	return &Watermark{Since: since}
+}
+
+// WithWatermark returns a context that makes the processing of locations track w.
+func WithWatermark(ctx context.Context, w *Watermark) context.Context {
+	return context.WithValue(ctx, watermarkKey{}, w)
+}
+
+func watermarkFromContext(ctx context.Context) *Watermark {
+	w, _ := ctx.Value(watermarkKey{}).(*Watermark)
+	return w
+}
+
+// Max returns the newest timestamp observed.
+func (w *Watermark) Max() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.max
+}
+
+// observe records t and reports whether it is newer than Since.
+func (w *Watermark) observe(t time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.After(w.max) {
+		w.max = t
+	}
+	return w.Since.IsZero() || t.After(w.Since)
+}
+
+func newestMeasurement(loc storage.Location) time.Time {
+	var newest time.Time
+	for _, m := range loc.Measurements {
+		if m.LastUpdated.After(newest) {
+			newest = m.LastUpdated
+		}
+	}
+	return newest
+}
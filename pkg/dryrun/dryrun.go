@@ -0,0 +1,213 @@
+package dryrun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"github.com/nhe23/aq-dbsync/pkg/syncstate"
+)
+
+// Operations planned for a document.
+const (
+	OpInsert  = "insert"
+	OpReplace = "replace"
+)
+
+// ignoredFields are not compared because they change on every sync or are not written by it.
+var ignoredFields = map[string]bool{"syncedAt": true, "airQuality": true}
+
+// Change is a changed field of a replaced document.
+type Change struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff describes the planned operation on a document.
+type Diff struct {
+	Key     string   `json:"key"`
+	Op      string   `json:"op"`
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// Plan summarises the planned operations on a collection.
+type Plan struct {
+	Collection   string `json:"collection"`
+	Inserts      int    `json:"inserts"`
+	Replacements int    `json:"replacements"`
+	// Unchanged counts replacements that do not change the stored document.
+	Unchanged   int           `json:"unchanged"`
+	DeleteStale []StaleDelete `json:"deleteStale,omitempty"`
+	Samples     []Diff        `json:"samples,omitempty"`
+}
+
+// StaleDelete is a planned deletion of the documents of a provider not synced since Before.
+type StaleDelete struct {
+	Provider string    `json:"provider"`
+	Before   time.Time `json:"before"`
+}
+
+// Recorder is a storage.Repository that records the upserts into a repository instead of
+// executing them. Reads are passed through so the plan is diffed against the stored documents.
+type Recorder struct {
+	repo    storage.Repository
+	samples int
+	mu      sync.Mutex
+	plan    Plan
+}
+
+// NewRecorder creates a Recorder of collection that keeps up to samples diffs.
+func NewRecorder(collection string, repo storage.Repository, samples int) *Recorder {
+	return &Recorder{repo: repo, samples: samples, plan: Plan{Collection: collection}}
+}
+
+// Upsert records the operations of an upsert of docs and returns the result it would have.
+func (r *Recorder) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	var result storage.UpsertResult
+	if len(docs) == 0 {
+		return result, nil
+	}
+	keys := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		keys = append(keys, doc.Key())
+	}
+	stored, err := r.repo.Find(ctx, storage.Filter{Keys: keys})
+	if err != nil {
+		return result, fmt.Errorf("error reading current documents: %w", err)
+	}
+	current := make(map[string]storage.Document, len(stored))
+	for _, doc := range stored {
+		current[doc.Key()] = doc
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, doc := range docs {
+		before, exists := current[doc.Key()]
+		if !exists {
+			result.Upserted++
+			r.plan.Inserts++
+			r.sample(Diff{Key: doc.Key(), Op: OpInsert})
+			continue
+		}
+		result.Matched++
+		r.plan.Replacements++
+		changes, err := diff(before, doc)
+		if err != nil {
+			return result, err
+		}
+		if len(changes) == 0 {
+			r.plan.Unchanged++
+			continue
+		}
+		result.Modified++
+		r.sample(Diff{Key: doc.Key(), Op: OpReplace, Changes: changes})
+	}
+	return result, nil
+}
+
+func (r *Recorder) sample(d Diff) {
+	if len(r.plan.Samples) < r.samples {
+		r.plan.Samples = append(r.plan.Samples, d)
+	}
+}
+
+// Find returns the stored documents.
+func (r *Recorder) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	return r.repo.Find(ctx, filter)
+}
+
+// DeleteStale records the deletion of stale documents without deleting them.
+func (r *Recorder) DeleteStale(ctx context.Context, provider string, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.plan.DeleteStale = append(r.plan.DeleteStale, StaleDelete{Provider: provider, Before: before})
+	return 0, nil
+}
+
+// Plan returns the recorded operations.
+func (r *Recorder) Plan() Plan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	plan := r.plan
+	plan.DeleteStale = append([]StaleDelete(nil), r.plan.DeleteStale...)
+	plan.Samples = append([]Diff(nil), r.plan.Samples...)
+	return plan
+}
+
+// diff returns the changed fields of the JSON representations of two documents.
+func diff(before storage.Document, after storage.Document) ([]Change, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for name := range b {
+		names[name] = true
+	}
+	for name := range a {
+		names[name] = true
+	}
+	var changes []Change
+	for name := range names {
+		if !ignoredFields[name] && !reflect.DeepEqual(b[name], a[name]) {
+			changes = append(changes, Change{Field: name, Before: b[name], After: a[name]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+func fields(doc storage.Document) (map[string]interface{}, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error converting json: %w", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("error converting json: %w", err)
+	}
+	return m, nil
+}
+
+// WriteText writes a human readable summary of plans to w.
+func WriteText(w io.Writer, plans []Plan) {
+	for _, p := range plans {
+		fmt.Fprintf(w, "%s: %d inserts, %d replacements (%d unchanged)\n", p.Collection, p.Inserts, p.Replacements, p.Unchanged)
+		for _, d := range p.DeleteStale {
+			fmt.Fprintf(w, "  delete documents of %s not synced since %s\n", d.Provider, d.Before.Format(time.RFC3339))
+		}
+		for _, d := range p.Samples {
+			fmt.Fprintf(w, "  %s %s\n", d.Op, d.Key)
+			for _, c := range d.Changes {
+				before, _ := json.Marshal(c.Before)
+				after, _ := json.Marshal(c.After)
+				fmt.Fprintf(w, "    %s: %s -> %s\n", c.Field, before, after)
+			}
+		}
+	}
+}
+
+type readOnlyState struct {
+	syncstate.Store
+}
+
+// ReadOnlyState returns a syncstate.Store that loads from store and discards saves.
+func ReadOnlyState(store syncstate.Store) syncstate.Store {
+	return readOnlyState{store}
+}
+
+func (readOnlyState) Save(ctx context.Context, state syncstate.State) error {
+	return nil
+}
@@ -0,0 +1,94 @@
+package dryrun
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// memoryRepository serves stored documents by key and fails on writes.
+type memoryRepository map[string]storage.Document
+
+func (m memoryRepository) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	return storage.UpsertResult{}, fmt.Errorf("not allowed in a dry run")
+}
+
+func (m memoryRepository) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	var docs []storage.Document
+	for _, key := range filter.Keys {
+		if doc, ok := m[key]; ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func (m memoryRepository) DeleteStale(ctx context.Context, provider string, before time.Time) (int64, error) {
+	return 0, fmt.Errorf("not allowed in a dry run")
+}
+
+func Test_Recorder(t *testing.T) {
+	synced := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	stored := storage.City{Name: "Berlin", Country: "DE", Count: 3, Locations: 2, SyncedAt: synced}
+	unchanged := storage.City{Name: "Hamburg", Country: "DE", Count: 1, Locations: 1, SyncedAt: synced}
+	repo := memoryRepository{stored.Key(): stored, unchanged.Key(): unchanged}
+
+	recorder := NewRecorder("cities", repo, 5)
+	changed := stored
+	changed.Count, changed.SyncedAt = 4, synced.Add(time.Hour)
+	unchanged.SyncedAt = synced.Add(time.Hour)
+	inserted := storage.City{Name: "Munich", Country: "DE", Count: 1, Locations: 1}
+	result, err := recorder.Upsert(context.Background(), []storage.Document{changed, unchanged, inserted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (storage.UpsertResult{Matched: 2, Modified: 1, Upserted: 1}); !reflect.DeepEqual(result, want) {
+		t.Errorf("Upsert() = %+v, want %+v", result, want)
+	}
+	before := synced.Add(-time.Hour)
+	if _, err := recorder.DeleteStale(context.Background(), storage.DefaultProvider, before); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Plan{
+		Collection:   "cities",
+		Inserts:      1,
+		Replacements: 2,
+		Unchanged:    1,
+		DeleteStale:  []StaleDelete{{Provider: storage.DefaultProvider, Before: before}},
+		Samples: []Diff{
+			{Key: changed.Key(), Op: OpReplace, Changes: []Change{{Field: "count", Before: float64(3), After: float64(4)}}},
+			{Key: inserted.Key(), Op: OpInsert},
+		},
+	}
+	plan := recorder.Plan()
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("Plan() = %+v, want %+v", plan, want)
+	}
+
+	var buf bytes.Buffer
+	WriteText(&buf, []Plan{plan})
+	for _, line := range []string{"cities: 1 inserts, 2 replacements (1 unchanged)", "replace " + changed.Key(), "count: 3 -> 4", "insert " + inserted.Key(), "delete documents of " + storage.DefaultProvider} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("WriteText() = %q, missing %q", buf.String(), line)
+		}
+	}
+}
+
+func Test_Recorder_samples(t *testing.T) {
+	recorder := NewRecorder("countries", memoryRepository{}, 2)
+	docs := []storage.Document{storage.Country{Code: "DE"}, storage.Country{Code: "FR"}, storage.Country{Code: "PL"}}
+	if _, err := recorder.Upsert(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+	plan := recorder.Plan()
+	if plan.Inserts != 3 || len(plan.Samples) != 2 {
+		t.Errorf("Plan() = %+v, want 3 inserts and 2 samples", plan)
+	}
+}
@@ -0,0 +1,134 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/backfill"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// Exported datasets.
+const (
+	Measurements = "measurements"
+	Cities       = "cities"
+	Countries    = "countries"
+	History      = "history"
+)
+
+// Datasets lists all exportable datasets.
+var Datasets = []string{Measurements, Cities, Countries, History}
+
+// Query selects the exported data. Empty fields match everything. Parameter and the time range
+// only apply to measurements and history, To is exclusive.
+type Query struct {
+	Country   string
+	City      string
+	Parameter string
+	From      time.Time
+	To        time.Time
+}
+
+func (q Query) matchesParameter(parameter string) bool {
+	return q.Parameter == "" || q.Parameter == parameter
+}
+
+func (q Query) matchesTime(t time.Time) bool {
+	return (q.From.IsZero() || !t.Before(q.From)) && (q.To.IsZero() || t.Before(q.To))
+}
+
+// Sources are the stores the datasets are read from.
+type Sources struct {
+	// Repositories of the measurements, cities and countries datasets.
+	Repos map[string]storage.Repository
+	// History is nil if the storage has no history.
+	History backfill.HistoryReader
+	// PageSize is the number of documents read at once.
+	PageSize int
+}
+
+// Export writes the rows of dataset matching q to w and returns the number of written rows.
+// Documents are read in pages so that memory use does not grow with the size of the dataset.
+func Export(ctx context.Context, src Sources, dataset string, q Query, format Format, w io.Writer) (int, error) {
+	prototypes := map[string]interface{}{
+		Measurements: MeasurementRow{},
+		Cities:       CityRow{},
+		Countries:    CountryRow{},
+		History:      HistoryRow{},
+	}
+	prototype, ok := prototypes[dataset]
+	if !ok {
+		return 0, fmt.Errorf("unknown dataset %q", dataset)
+	}
+	if dataset == History && src.History == nil {
+		return 0, fmt.Errorf("the storage has no history")
+	}
+	rw, err := newRowWriter(format, w, prototype)
+	if err != nil {
+		return 0, err
+	}
+
+	rows := 0
+	write := func(row interface{}) error {
+		rows++
+		return rw.Write(row)
+	}
+	if dataset == History {
+		err = src.History.Each(ctx, backfill.HistoryFilter{Country: q.Country, City: q.City, Parameter: q.Parameter, From: q.From, To: q.To},
+			func(m backfill.HistoryMeasurement) error {
+				return write(historyRow(m))
+			})
+	} else {
+		err = exportRepository(ctx, src, dataset, q, write)
+	}
+	if err != nil {
+		return rows, fmt.Errorf("error exporting %s: %w", dataset, err)
+	}
+	if err := rw.Close(); err != nil {
+		return rows, fmt.Errorf("error writing %s: %w", dataset, err)
+	}
+	return rows, nil
+}
+
+// exportRepository pages through the repository of dataset by key.
+func exportRepository(ctx context.Context, src Sources, dataset string, q Query, write func(row interface{}) error) error {
+	repo, ok := src.Repos[dataset]
+	if !ok {
+		return fmt.Errorf("the storage has no %s", dataset)
+	}
+	pageSize := src.PageSize
+	if pageSize <= 0 {
+		pageSize = 1000
+	}
+	filter := storage.Filter{Country: q.Country, City: q.City, Limit: pageSize}
+	for {
+		docs, err := repo.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			var rows []interface{}
+			switch d := doc.(type) {
+			case storage.Location:
+				rows = measurementRows(d, q)
+			case storage.City:
+				rows = []interface{}{cityRow(d)}
+			case storage.Country:
+				rows = []interface{}{countryRow(d)}
+			default:
+				return fmt.Errorf("unexpected document type %T", doc)
+			}
+			for _, row := range rows {
+				if err := write(row); err != nil {
+					return err
+				}
+			}
+		}
+		if len(docs) < pageSize {
+			return nil
+		}
+		filter.After = docs[len(docs)-1].Key()
+	}
+}
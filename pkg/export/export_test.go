@@ -0,0 +1,205 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/backfill"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"github.com/parquet-go/parquet-go"
+)
+
+// memoryRepository serves documents sorted by key and records the filters of Find.
+type memoryRepository struct {
+	docs    []storage.Document
+	filters []storage.Filter
+}
+
+func (m *memoryRepository) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	return storage.UpsertResult{}, fmt.Errorf("not implemented")
+}
+
+func (m *memoryRepository) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	m.filters = append(m.filters, filter)
+	sort.Slice(m.docs, func(i, j int) bool { return m.docs[i].Key() < m.docs[j].Key() })
+	var docs []storage.Document
+	for _, doc := range m.docs {
+		if doc.Key() > filter.After && (filter.Limit == 0 || len(docs) < filter.Limit) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func (m *memoryRepository) DeleteStale(ctx context.Context, provider string, before time.Time) (int64, error) {
+	return 0, fmt.Errorf("not implemented")
+}
+
+type memoryHistory []backfill.HistoryMeasurement
+
+func (m memoryHistory) Each(ctx context.Context, filter backfill.HistoryFilter, fn func(backfill.HistoryMeasurement) error) error {
+	for _, measurement := range m {
+		if err := fn(measurement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var updated = time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func testSources() Sources {
+	location := func(name string, parameters ...string) storage.Location {
+		l := storage.Location{Location: name, City: "Berlin", Country: "DE", Coordinates: storage.Coordinates{Latitude: 52.5, Longitude: 13.4}, SyncedAt: updated}
+		for i, parameter := range parameters {
+			l.Measurements = append(l.Measurements, storage.Measurement{Parameter: parameter, Value: float64(10 * (i + 1)), Unit: "µg/m³", LastUpdated: updated.Add(time.Duration(i) * time.Hour), QualityIndex: 1})
+		}
+		return l
+	}
+	return Sources{
+		Repos: map[string]storage.Repository{
+			Measurements: &memoryRepository{docs: []storage.Document{location("c", "pm10"), location("a", "pm10", "no2"), location("b", "so2")}},
+			Cities:       &memoryRepository{docs: []storage.Document{storage.City{Name: "Berlin", Country: "DE", Count: 3, Locations: 2, SyncedAt: updated}}},
+			Countries:    &memoryRepository{},
+		},
+		History:  memoryHistory{{Location: "a", City: "Berlin", Country: "DE", Parameter: "pm10", Value: 12.5, Unit: "µg/m³", Date: updated}},
+		PageSize: 2,
+	}
+}
+
+func Test_Export_CSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		dataset string
+		query   Query
+		want    string
+		wantErr bool
+	}{
+		{"cities", Cities, Query{}, "name,country,count,locations,synced_at\nBerlin,DE,3,2,2021-03-01T10:00:00Z\n", false},
+		{"empty", Countries, Query{}, "code,name,count,cities,locations,synced_at\n", false},
+		{"measurements filtered", Measurements, Query{Parameter: "pm10", From: updated, To: updated.Add(time.Hour)},
+			"location,city,country,latitude,longitude,parameter,value,unit,last_updated,quality_index,synced_at\n" +
+				"a,Berlin,DE,52.5,13.4,pm10,10,µg/m³,2021-03-01T10:00:00Z,1,2021-03-01T10:00:00Z\n" +
+				"c,Berlin,DE,52.5,13.4,pm10,10,µg/m³,2021-03-01T10:00:00Z,1,2021-03-01T10:00:00Z\n", false},
+		{"history", History, Query{}, "location,city,country,latitude,longitude,parameter,value,unit,date\n" +
+			"a,Berlin,DE,0,0,pm10,12.5,µg/m³,2021-03-01T10:00:00Z\n", false},
+		{"unknown", "weather", Query{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			_, err := Export(context.Background(), testSources(), tt.dataset, tt.query, CSV, &buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Export() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Export() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Export_paging(t *testing.T) {
+	src := testSources()
+	var buf bytes.Buffer
+	rows, err := Export(context.Background(), src, Measurements, Query{}, NDJSON, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 4 {
+		t.Errorf("Export() = %d rows, want 4", rows)
+	}
+	var afters []string
+	for _, filter := range src.Repos[Measurements].(*memoryRepository).filters {
+		afters = append(afters, filter.After)
+	}
+	if got := strings.Join(afters, ","); got != ",b" {
+		t.Errorf("pages after %q, want %q", got, ",b")
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var first MeasurementRow
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 4 || first.Location != "a" || first.Parameter != "pm10" || !first.LastUpdated.Equal(updated) {
+		t.Errorf("unexpected rows %v", lines)
+	}
+}
+
+func Test_Export_Parquet(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := Export(context.Background(), testSources(), Measurements, Query{}, Parquet, &buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := parquet.Read[MeasurementRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || rows[1].Parameter != "no2" || rows[1].Value != 20 || !rows[1].LastUpdated.Equal(updated.Add(time.Hour)) {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+}
+
+func Test_Export_ParquetRowGroups(t *testing.T) {
+	src := testSources()
+	history := make(memoryHistory, parquetRowGroupSize+1)
+	for i := range history {
+		history[i] = backfill.HistoryMeasurement{Location: "a", Parameter: "pm10", Value: float64(i), Date: updated}
+	}
+	src.History = history
+	var buf bytes.Buffer
+	if _, err := Export(context.Background(), src, History, Query{}, Parquet, &buf); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups := len(file.RowGroups()); groups != 2 || file.NumRows() != parquetRowGroupSize+1 {
+		t.Errorf("parquet file has %d rows in %d row groups, want %d in 2", file.NumRows(), groups, parquetRowGroupSize+1)
+	}
+}
+
+func Test_Export_ParquetValueType(t *testing.T) {
+	for _, dataset := range []string{Measurements, History} {
+		var buf bytes.Buffer
+		if _, err := Export(context.Background(), testSources(), dataset, Query{}, Parquet, &buf); err != nil {
+			t.Fatal(err)
+		}
+		file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		column, ok := file.Schema().Lookup("value")
+		if !ok || column.Node.Type().Kind() != parquet.Double {
+			t.Errorf("value column of %s = %+v, want double", dataset, column.Node)
+		}
+	}
+}
+
+func Test_ParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Format
+		wantErr bool
+	}{
+		{"csv", CSV, false},
+		{"NDJSON", NDJSON, false},
+		{"parquet", Parquet, false},
+		{"xlsx", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFormat(tt.name)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseFormat() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package export
+
+import (
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/backfill"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// The row types define the stable column schema of each dataset. Columns are named by the json
+// tags in all formats and keep their order. The value columns of measurements and history are
+// doubles since schema version 3; earlier exports wrote them as int64.
+
+// MeasurementRow is the latest measurement of a parameter at a location.
+type MeasurementRow struct {
+	Location     string    `json:"location" parquet:"location"`
+	City         string    `json:"city" parquet:"city"`
+	Country      string    `json:"country" parquet:"country"`
+	Latitude     float64   `json:"latitude" parquet:"latitude"`
+	Longitude    float64   `json:"longitude" parquet:"longitude"`
+	Parameter    string    `json:"parameter" parquet:"parameter"`
+	Value        float64   `json:"value" parquet:"value"`
+	Unit         string    `json:"unit" parquet:"unit"`
+	LastUpdated  time.Time `json:"last_updated" parquet:"last_updated,timestamp(millisecond)"`
+	QualityIndex int64     `json:"quality_index" parquet:"quality_index"`
+	SyncedAt     time.Time `json:"synced_at" parquet:"synced_at,timestamp(millisecond)"`
+}
+
+// CityRow is a city.
+type CityRow struct {
+	Name      string    `json:"name" parquet:"name"`
+	Country   string    `json:"country" parquet:"country"`
+	Count     int64     `json:"count" parquet:"count"`
+	Locations int64     `json:"locations" parquet:"locations"`
+	SyncedAt  time.Time `json:"synced_at" parquet:"synced_at,timestamp(millisecond)"`
+}
+
+// CountryRow is a country.
+type CountryRow struct {
+	Code      string    `json:"code" parquet:"code"`
+	Name      string    `json:"name" parquet:"name"`
+	Count     int64     `json:"count" parquet:"count"`
+	Cities    int64     `json:"cities" parquet:"cities"`
+	Locations int64     `json:"locations" parquet:"locations"`
+	SyncedAt  time.Time `json:"synced_at" parquet:"synced_at,timestamp(millisecond)"`
+}
+
+// HistoryRow is a historical measurement.
+type HistoryRow struct {
+	Location  string    `json:"location" parquet:"location"`
+	City      string    `json:"city" parquet:"city"`
+	Country   string    `json:"country" parquet:"country"`
+	Latitude  float64   `json:"latitude" parquet:"latitude"`
+	Longitude float64   `json:"longitude" parquet:"longitude"`
+	Parameter string    `json:"parameter" parquet:"parameter"`
+	Value     float64   `json:"value" parquet:"value"`
+	Unit      string    `json:"unit" parquet:"unit"`
+	Date      time.Time `json:"date" parquet:"date,timestamp(millisecond)"`
+}
+
+// measurementRows returns the rows of the measurements of a location matching q.
+func measurementRows(location storage.Location, q Query) []interface{} {
+	var rows []interface{}
+	for _, m := range location.Measurements {
+		if !q.matchesParameter(m.Parameter) || !q.matchesTime(m.LastUpdated) {
+			continue
+		}
+		rows = append(rows, MeasurementRow{
+			Location:     location.Location,
+			City:         location.City,
+			Country:      location.Country,
+			Latitude:     location.Coordinates.Latitude,
+			Longitude:    location.Coordinates.Longitude,
+			Parameter:    m.Parameter,
+			Value:        m.Value,
+			Unit:         m.Unit,
+			LastUpdated:  m.LastUpdated.UTC(),
+			QualityIndex: int64(m.QualityIndex),
+			SyncedAt:     location.SyncedAt.UTC(),
+		})
+	}
+	return rows
+}
+
+func cityRow(city storage.City) CityRow {
+	return CityRow{city.Name, city.Country, int64(city.Count), int64(city.Locations), city.SyncedAt.UTC()}
+}
+
+func countryRow(country storage.Country) CountryRow {
+	return CountryRow{country.Code, country.Name, int64(country.Count), int64(country.Cities), int64(country.Locations), country.SyncedAt.UTC()}
+}
+
+func historyRow(m backfill.HistoryMeasurement) HistoryRow {
+	return HistoryRow{
+		Location:  m.Location,
+		City:      m.City,
+		Country:   m.Country,
+		Latitude:  m.Coordinates.Latitude,
+		Longitude: m.Coordinates.Longitude,
+		Parameter: m.Parameter,
+		Value:     m.Value,
+		Unit:      m.Unit,
+		Date:      m.Date.UTC(),
+	}
+}
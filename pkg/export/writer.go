@@ -0,0 +1,126 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Format is an export file format.
+type Format string
+
+// Supported formats.
+const (
+	CSV     Format = "csv"
+	NDJSON  Format = "ndjson"
+	Parquet Format = "parquet"
+)
+
+// ParseFormat returns the format named s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case CSV, NDJSON, Parquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q, valid formats are csv, ndjson and parquet", s)
+}
+
+// parquetRowGroupSize bounds the rows of a parquet row group, which is buffered in memory until
+// it is complete.
+const parquetRowGroupSize = 10000
+
+// rowWriter writes rows of a single row type.
+type rowWriter interface {
+	Write(row interface{}) error
+	// Close flushes the rows, it does not close the underlying writer.
+	Close() error
+}
+
+// newRowWriter creates a writer of rows of the type of prototype.
+func newRowWriter(format Format, w io.Writer, prototype interface{}) (rowWriter, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w, reflect.TypeOf(prototype))
+	case NDJSON:
+		return ndjsonWriter{json.NewEncoder(w)}, nil
+	case Parquet:
+		return parquetWriter{parquet.NewWriter(w, parquet.SchemaOf(prototype), parquet.MaxRowsPerRowGroup(parquetRowGroupSize))}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// newCSVWriter writes the header named by the json tags of rowType.
+func newCSVWriter(w io.Writer, rowType reflect.Type) (rowWriter, error) {
+	header := make([]string, rowType.NumField())
+	for i := range header {
+		header[i] = strings.Split(rowType.Field(i).Tag.Get("json"), ",")[0]
+	}
+	c := csvWriter{csv.NewWriter(w)}
+	return c, c.w.Write(header)
+}
+
+func (c csvWriter) Write(row interface{}) error {
+	v := reflect.ValueOf(row)
+	record := make([]string, v.NumField())
+	for i := range record {
+		record[i] = formatCSV(v.Field(i).Interface())
+	}
+	return c.w.Write(record)
+}
+
+func (c csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func formatCSV(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n ndjsonWriter) Write(row interface{}) error {
+	return n.enc.Encode(row)
+}
+
+func (n ndjsonWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	w *parquet.Writer
+}
+
+func (p parquetWriter) Write(row interface{}) error {
+	return p.w.Write(row)
+}
+
+func (p parquetWriter) Close() error {
+	return p.w.Close()
+}
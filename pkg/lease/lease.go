@@ -0,0 +1,131 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrLost is the cause of a held context that was cancelled because the lease could not be refreshed.
+var ErrLost = errors.New("lease lost")
+
+// Store persists leases.
+type Store interface {
+	// TryAcquire takes or extends the lease of name until expiresAt if it is free, expired or
+	// already held by owner. It reports whether owner holds the lease.
+	TryAcquire(ctx context.Context, name string, owner string, expiresAt time.Time) (bool, error)
+	// Release frees the lease of name if it is held by owner.
+	Release(ctx context.Context, name string, owner string) error
+}
+
+// Lock is a lease based lock shared by all replicas using the same Store.
+type Lock struct {
+	store Store
+	owner string
+	ttl   time.Duration
+}
+
+// NewLock creates a Lock whose leases are held by owner and expire after ttl unless refreshed.
+func NewLock(store Store, owner string, ttl time.Duration) *Lock {
+	return &Lock{store, owner, ttl}
+}
+
+// Owner returns the owner ID of the lock.
+func (l *Lock) Owner() string {
+	return l.owner
+}
+
+// Hold acquires the lease of name and refreshes it until release is called. It returns false if
+// another owner holds the lease. The returned context is cancelled with ErrLost as cause if a
+// refresh fails, so that work guarded by the lease stops before another owner takes over.
+func (l *Lock) Hold(ctx context.Context, name string) (context.Context, func(), bool, error) {
+	acquired, err := l.store.TryAcquire(ctx, name, l.owner, time.Now().Add(l.ttl))
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("error acquiring lease %s: %w", name, err)
+	}
+	if !acquired {
+		return nil, nil, false, nil
+	}
+
+	heldCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				refreshed, err := l.store.TryAcquire(context.WithoutCancel(ctx), name, l.owner, time.Now().Add(l.ttl))
+				if err != nil || !refreshed {
+					cancel(ErrLost)
+					return
+				}
+			}
+		}
+	}()
+
+	release := func() {
+		close(done)
+		<-stopped
+		cancel(nil)
+		l.store.Release(context.WithoutCancel(ctx), name, l.owner)
+	}
+	return heldCtx, release, true, nil
+}
+
+type mongoStore struct {
+	col *mongo.Collection
+}
+
+// NewMongoStore creates a Store backed by a mongo collection.
+func NewMongoStore(col *mongo.Collection) Store {
+	return mongoStore{col}
+}
+
+func (m mongoStore) TryAcquire(ctx context.Context, name string, owner string, expiresAt time.Time) (bool, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expiresAt": expiresAt.UTC()}}
+	_, err := m.col.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if isDuplicateKey(err) {
+		// The lease exists and is held by another owner.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (m mongoStore) Release(ctx context.Context, name string, owner string) error {
+	_, err := m.col.DeleteOne(ctx, bson.M{"_id": name, "owner": owner})
+	return err
+}
+
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if !errors.As(err, &we) {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == 11000 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryLease struct {
+	owner     string
+	expiresAt time.Time
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	leases  map[string]memoryLease
+	failing bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{leases: make(map[string]memoryLease)}
+}
+
+func (m *memoryStore) TryAcquire(ctx context.Context, name string, owner string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failing {
+		return false, fmt.Errorf("VERY BAD ERROR")
+	}
+	l, found := m.leases[name]
+	if found && l.owner != owner && l.expiresAt.After(time.Now()) {
+		return false, nil
+	}
+	m.leases[name] = memoryLease{owner, expiresAt}
+	return true, nil
+}
+
+func (m *memoryStore) Release(ctx context.Context, name string, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.leases[name].owner == owner {
+		delete(m.leases, name)
+	}
+	return nil
+}
+
+func TestLock_Hold(t *testing.T) {
+	store := newMemoryStore()
+	a := NewLock(store, "a", time.Minute)
+	b := NewLock(store, "b", time.Minute)
+
+	_, release, acquired, err := a.Hold(context.Background(), "cities")
+	if err != nil || !acquired {
+		t.Fatalf("a.Hold() = %v, %v, want acquired", acquired, err)
+	}
+	if _, _, acquired, err := b.Hold(context.Background(), "cities"); err != nil || acquired {
+		t.Errorf("b.Hold() while held by a = %v, %v, want not acquired", acquired, err)
+	}
+	if _, releaseOther, acquired, err := b.Hold(context.Background(), "countries"); err != nil || !acquired {
+		t.Errorf("b.Hold() of other lease = %v, %v, want acquired", acquired, err)
+	} else {
+		releaseOther()
+	}
+	release()
+	_, release, acquired, err = b.Hold(context.Background(), "cities")
+	if err != nil || !acquired {
+		t.Errorf("b.Hold() after release = %v, %v, want acquired", acquired, err)
+	} else {
+		release()
+	}
+}
+
+func TestLock_HoldExpired(t *testing.T) {
+	store := newMemoryStore()
+	store.leases["cities"] = memoryLease{"dead", time.Now().Add(-time.Second)}
+	_, release, acquired, err := NewLock(store, "a", time.Minute).Hold(context.Background(), "cities")
+	if err != nil || !acquired {
+		t.Fatalf("Hold() of expired lease = %v, %v, want acquired", acquired, err)
+	}
+	release()
+}
+
+func TestLock_HoldLost(t *testing.T) {
+	store := newMemoryStore()
+	ctx, release, acquired, err := NewLock(store, "a", 30*time.Millisecond).Hold(context.Background(), "cities")
+	if err != nil || !acquired {
+		t.Fatalf("Hold() = %v, %v, want acquired", acquired, err)
+	}
+	defer release()
+	store.mu.Lock()
+	store.failing = true
+	store.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		if cause := context.Cause(ctx); cause != ErrLost {
+			t.Errorf("context.Cause() = %v, want %v", cause, ErrLost)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("held context not cancelled after failed refresh")
+	}
+}
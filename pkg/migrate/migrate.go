@@ -0,0 +1,137 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/lease"
+)
+
+// LeaseName is the name of the lease held while migrating.
+const LeaseName = "migrations"
+
+// Migration is an up step of the stored schema. Up must be safe to retry after a failure.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+}
+
+// Record is an applied migration.
+type Record struct {
+	Version         int       `bson:"_id" json:"version"`
+	Description     string    `bson:"description" json:"description"`
+	AppliedAt       time.Time `bson:"appliedAt" json:"appliedAt"`
+	DurationSeconds float64   `bson:"durationSeconds" json:"durationSeconds"`
+}
+
+// Store records the applied migrations.
+type Store interface {
+	// Applied returns the applied migrations ordered by version.
+	Applied(ctx context.Context) ([]Record, error)
+	Save(ctx context.Context, record Record) error
+}
+
+// Migrator applies the migrations of a registry that were not applied yet.
+type Migrator struct {
+	store      Store
+	lock       *lease.Lock
+	migrations []Migration
+	// wait is the interval of the attempts to acquire the lease held by another replica.
+	wait time.Duration
+}
+
+// NewMigrator creates a Migrator of migrations. Migrations are applied while holding the
+// migrations lease of lock, unless lock is nil.
+func NewMigrator(store Store, lock *lease.Lock, migrations []Migration) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("invalid version %d of migration %q", m.Version, m.Description)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", m.Version)
+		}
+	}
+	return &Migrator{store: store, lock: lock, migrations: sorted, wait: time.Second}, nil
+}
+
+// Pending returns the migrations that were not applied yet in version order.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.store.Applied(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading applied migrations: %w", err)
+	}
+	done := make(map[int]bool, len(applied))
+	for _, r := range applied {
+		done[r.Version] = true
+	}
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if !done[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Applied returns the applied migrations.
+func (m *Migrator) Applied(ctx context.Context) ([]Record, error) {
+	return m.store.Applied(ctx)
+}
+
+// Run applies the pending migrations in version order and returns their records. It waits for
+// other replicas that are migrating.
+func (m *Migrator) Run(ctx context.Context) ([]Record, error) {
+	if m.lock != nil {
+		heldCtx, release, err := m.hold(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		ctx = heldCtx
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for _, migration := range pending {
+		start := time.Now()
+		if err := migration.Up(ctx); err != nil {
+			return records, fmt.Errorf("error applying migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		record := Record{
+			Version:         migration.Version,
+			Description:     migration.Description,
+			AppliedAt:       time.Now().UTC(),
+			DurationSeconds: time.Since(start).Seconds(),
+		}
+		if err := m.store.Save(ctx, record); err != nil {
+			return records, fmt.Errorf("error recording migration %d: %w", migration.Version, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// hold acquires the migrations lease, waiting while another replica holds it.
+func (m *Migrator) hold(ctx context.Context) (context.Context, func(), error) {
+	for {
+		heldCtx, release, acquired, err := m.lock.Hold(ctx, LeaseName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if acquired {
+			return heldCtx, release, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("waiting for the %s lease: %w", LeaseName, ctx.Err())
+		case <-time.After(m.wait):
+		}
+	}
+}
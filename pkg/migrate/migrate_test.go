@@ -0,0 +1,151 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/lease"
+)
+
+type memoryStore struct {
+	records []Record
+}
+
+func (m *memoryStore) Applied(ctx context.Context) ([]Record, error) {
+	return m.records, nil
+}
+
+func (m *memoryStore) Save(ctx context.Context, record Record) error {
+	m.records = append(m.records, record)
+	return nil
+}
+
+// memoryLeases holds every lease for the first owner that acquires it.
+type memoryLeases struct {
+	mu     sync.Mutex
+	owners map[string]string
+}
+
+func (m *memoryLeases) TryAcquire(ctx context.Context, name string, owner string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if held, ok := m.owners[name]; ok && held != owner {
+		return false, nil
+	}
+	m.owners[name] = owner
+	return true, nil
+}
+
+func (m *memoryLeases) Release(ctx context.Context, name string, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.owners[name] == owner {
+		delete(m.owners, name)
+	}
+	return nil
+}
+
+func Test_Migrator_Run(t *testing.T) {
+	var applied []int
+	migration := func(version int, err error) Migration {
+		return Migration{Version: version, Description: fmt.Sprint("migration ", version), Up: func(ctx context.Context) error {
+			if err == nil {
+				applied = append(applied, version)
+			}
+			return err
+		}}
+	}
+	tests := []struct {
+		name        string
+		recorded    []int
+		migrations  []Migration
+		wantApplied []int
+		wantErr     bool
+	}{
+		{"in version order", nil, []Migration{migration(2, nil), migration(1, nil)}, []int{1, 2}, false},
+		{"skips applied", []int{1}, []Migration{migration(1, nil), migration(2, nil)}, []int{2}, false},
+		{"nothing pending", []int{1, 2}, []Migration{migration(1, nil), migration(2, nil)}, nil, false},
+		{"stops at failure", nil, []Migration{migration(1, nil), migration(2, errors.New("VERY BAD ERROR")), migration(3, nil)}, []int{1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applied = nil
+			store := &memoryStore{}
+			for _, version := range tt.recorded {
+				store.records = append(store.records, Record{Version: version})
+			}
+			migrator, err := NewMigrator(store, nil, tt.migrations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records, err := migrator.Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Migrator.Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(applied, tt.wantApplied) {
+				t.Errorf("applied migrations %v, want %v", applied, tt.wantApplied)
+			}
+			if len(records) != len(tt.wantApplied) || len(store.records) != len(tt.recorded)+len(tt.wantApplied) {
+				t.Errorf("Migrator.Run() recorded %v, store has %v", records, store.records)
+			}
+			pending, err := migrator.Pending(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr == (len(pending) == 0) {
+				t.Errorf("Migrator.Pending() = %v after run", pending)
+			}
+		})
+	}
+}
+
+func Test_NewMigrator(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	tests := []struct {
+		name       string
+		migrations []Migration
+		wantErr    bool
+	}{
+		{"valid", []Migration{{Version: 1, Up: up}, {Version: 2, Up: up}}, false},
+		{"duplicate", []Migration{{Version: 1, Up: up}, {Version: 1, Up: up}}, true},
+		{"zero", []Migration{{Version: 0, Up: up}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMigrator(&memoryStore{}, nil, tt.migrations); (err != nil) != tt.wantErr {
+				t.Errorf("NewMigrator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_Migrator_Run_waitsForLease(t *testing.T) {
+	leases := &memoryLeases{owners: map[string]string{LeaseName: "other"}}
+	var applied bool
+	migrator, err := NewMigrator(&memoryStore{}, lease.NewLock(leases, "me", time.Minute), []Migration{
+		{Version: 1, Up: func(ctx context.Context) error { applied = true; return nil }},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator.wait = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := migrator.Run(ctx); !errors.Is(err, context.DeadlineExceeded) || applied {
+		t.Fatalf("Migrator.Run() with a held lease = %v, applied %v", err, applied)
+	}
+
+	leases.Release(context.Background(), LeaseName, "other")
+	if _, err := migrator.Run(context.Background()); err != nil || !applied {
+		t.Fatalf("Migrator.Run() = %v, applied %v", err, applied)
+	}
+	if _, held := leases.owners[LeaseName]; held {
+		t.Errorf("lease was not released")
+	}
+}
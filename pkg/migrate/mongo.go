@@ -0,0 +1,35 @@
+package migrate
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoStore struct {
+	col *mongo.Collection
+}
+
+// NewMongoStore creates a Store that records the applied migrations in a mongo collection.
+func NewMongoStore(col *mongo.Collection) Store {
+	return mongoStore{col}
+}
+
+func (m mongoStore) Applied(ctx context.Context) ([]Record, error) {
+	cursor, err := m.col.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (m mongoStore) Save(ctx context.Context, record Record) error {
+	_, err := m.col.ReplaceOne(ctx, bson.M{"_id": record.Version}, record, options.Replace().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,336 @@
+// Package readapi serves the current air quality of the synced datasets as HTTP/JSON API.
+package readapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// Spec is the OpenAPI specification of the API.
+//
+//go:embed openapi.yaml
+var Spec []byte
+
+// Paging limits.
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Limits of the nearest stations.
+const (
+	defaultNearestLimit = 10
+	maxNearestLimit     = 100
+	defaultRadiusKm     = 25
+	maxRadiusKm         = 500
+)
+
+// earthRadiusKm is the mean radius of the earth.
+const earthRadiusKm = 6371.0
+
+// Repositories are the repositories the API reads from.
+type Repositories struct {
+	Locations storage.Repository
+	Cities    storage.Repository
+	Countries storage.Repository
+}
+
+type handler struct {
+	repos Repositories
+}
+
+// NewHandler returns the handler of the read API.
+//
+//	GET /v1/locations             latest readings of the locations, paged by key
+//	GET /v1/locations/{location}  latest readings of a location
+//	GET /v1/nearest               locations nearest to a coordinate
+//	GET /v1/cities                current air quality of the cities, paged by name
+//	GET /v1/cities/{city}         current air quality of a city
+//	GET /v1/countries             current air quality of the countries, paged by code
+//	GET /v1/countries/{code}      current air quality of a country
+//	GET /openapi.yaml             the OpenAPI specification
+func NewHandler(repos Repositories) http.Handler {
+	h := handler{repos: repos}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/locations", get(h.locations))
+	mux.HandleFunc("/v1/locations/", get(h.location))
+	mux.HandleFunc("/v1/nearest", get(h.nearest))
+	mux.HandleFunc("/v1/cities", get(h.cities))
+	mux.HandleFunc("/v1/cities/", get(h.city))
+	mux.HandleFunc("/v1/countries", get(h.countries))
+	mux.HandleFunc("/v1/countries/", get(h.country))
+	mux.HandleFunc("/openapi.yaml", get(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(Spec)
+	}))
+	return mux
+}
+
+// get restricts handler to GET and HEAD requests.
+func get(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Page is a page of results. Next is the cursor of the next page, empty on the last page.
+type Page struct {
+	Results interface{} `json:"results"`
+	Next    string      `json:"next,omitempty"`
+}
+
+// Station is a location with its distance to the requested coordinate.
+type Station struct {
+	storage.Location
+	DistanceKm float64 `json:"distanceKm"`
+}
+
+func (h handler) locations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := storage.Filter{Country: q.Get("country"), City: q.Get("city"), Parameter: q.Get("parameter")}
+	h.page(w, r, h.repos.Locations, filter, func(doc storage.Document) interface{} {
+		return withParameter(doc.(storage.Location), filter.Parameter)
+	})
+}
+
+func (h handler) location(w http.ResponseWriter, r *http.Request) {
+	h.one(w, r, h.repos.Locations, strings.TrimPrefix(r.URL.Path, "/v1/locations/"), func(doc storage.Document) interface{} {
+		return withParameter(doc.(storage.Location), r.URL.Query().Get("parameter"))
+	})
+}
+
+func (h handler) cities(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	h.page(w, r, h.repos.Cities, storage.Filter{Country: q.Get("country")}, func(doc storage.Document) interface{} {
+		city := doc.(storage.City)
+		city.AirQuality = airQualityOf(city.AirQuality, q.Get("parameter"))
+		return city
+	})
+}
+
+func (h handler) city(w http.ResponseWriter, r *http.Request) {
+	h.one(w, r, h.repos.Cities, strings.TrimPrefix(r.URL.Path, "/v1/cities/"), func(doc storage.Document) interface{} {
+		city := doc.(storage.City)
+		city.AirQuality = airQualityOf(city.AirQuality, r.URL.Query().Get("parameter"))
+		return city
+	})
+}
+
+func (h handler) countries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	h.page(w, r, h.repos.Countries, storage.Filter{}, func(doc storage.Document) interface{} {
+		country := doc.(storage.Country)
+		country.AirQuality = airQualityOf(country.AirQuality, q.Get("parameter"))
+		return country
+	})
+}
+
+func (h handler) country(w http.ResponseWriter, r *http.Request) {
+	h.one(w, r, h.repos.Countries, strings.TrimPrefix(r.URL.Path, "/v1/countries/"), func(doc storage.Document) interface{} {
+		country := doc.(storage.Country)
+		country.AirQuality = airQualityOf(country.AirQuality, r.URL.Query().Get("parameter"))
+		return country
+	})
+}
+
+// page writes the page of documents of repo matching filter after the cursor of the request.
+func (h handler) page(w http.ResponseWriter, r *http.Request, repo storage.Repository, filter storage.Filter, result func(storage.Document) interface{}) {
+	limit, err := intParam(r, "limit", defaultLimit, 1, maxLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.After = r.URL.Query().Get("after")
+	// One more document tells whether there is a next page.
+	filter.Limit = limit + 1
+	docs, err := repo.Find(r.Context(), filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	page := Page{}
+	if len(docs) > limit {
+		docs = docs[:limit]
+		page.Next = docs[limit-1].Key()
+	}
+	results := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		results = append(results, result(doc))
+	}
+	page.Results = results
+	writeJSON(w, http.StatusOK, page)
+}
+
+// one writes the document of repo with key.
+func (h handler) one(w http.ResponseWriter, r *http.Request, repo storage.Repository, key string, result func(storage.Document) interface{}) {
+	if key == "" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	docs, err := repo.Find(r.Context(), storage.Filter{Keys: []string{key}, Limit: 1})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(docs) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", key))
+		return
+	}
+	writeJSON(w, http.StatusOK, result(docs[0]))
+}
+
+func (h handler) nearest(w http.ResponseWriter, r *http.Request) {
+	latitude, err := floatParam(r, "lat", -90, 90)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	longitude, err := floatParam(r, "lon", -180, 180)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	radius, err := intParam(r, "radius", defaultRadiusKm, 1, maxRadiusKm)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := intParam(r, "limit", defaultNearestLimit, 1, maxNearestLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	parameter := r.URL.Query().Get("parameter")
+	origin := storage.Coordinates{Latitude: latitude, Longitude: longitude}
+	docs, err := h.repos.Locations.Find(r.Context(), storage.Filter{Parameter: parameter, Bounds: BoundsAround(origin, float64(radius))})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	stations := make([]Station, 0)
+	for _, doc := range docs {
+		location := doc.(storage.Location)
+		if distance := DistanceKm(origin, location.Coordinates); distance <= float64(radius) {
+			stations = append(stations, Station{Location: withParameter(location, parameter), DistanceKm: math.Round(distance*1000) / 1000})
+		}
+	}
+	sort.SliceStable(stations, func(i, j int) bool { return stations[i].DistanceKm < stations[j].DistanceKm })
+	if len(stations) > limit {
+		stations = stations[:limit]
+	}
+	writeJSON(w, http.StatusOK, Page{Results: stations})
+}
+
+// withParameter returns location with only the measurements of parameter, or all measurements if
+// parameter is empty.
+func withParameter(location storage.Location, parameter string) storage.Location {
+	if parameter == "" {
+		return location
+	}
+	measurements := []storage.Measurement{}
+	for _, m := range location.Measurements {
+		if m.Parameter == parameter {
+			measurements = append(measurements, m)
+		}
+	}
+	location.Measurements = measurements
+	return location
+}
+
+// airQualityOf returns aq with only the parameters of parameter, or all parameters if parameter is
+// empty.
+func airQualityOf(aq *storage.AirQuality, parameter string) *storage.AirQuality {
+	if aq == nil || parameter == "" {
+		return aq
+	}
+	filtered := *aq
+	filtered.Parameters = []storage.ParameterQuality{}
+	for _, p := range aq.Parameters {
+		if p.Parameter == parameter {
+			filtered.Parameters = append(filtered.Parameters, p)
+		}
+	}
+	return &filtered
+}
+
+// DistanceKm returns the great-circle distance between a and b.
+func DistanceKm(a, b storage.Coordinates) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat, dLon := lat2-lat1, (b.Longitude-a.Longitude)*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// BoundsAround returns bounds containing all coordinates within radiusKm of c.
+func BoundsAround(c storage.Coordinates, radiusKm float64) *storage.Bounds {
+	dLat := radiusKm / earthRadiusKm * 180 / math.Pi
+	b := &storage.Bounds{South: c.Latitude - dLat, North: c.Latitude + dLat, West: -180, East: 180}
+	if b.South <= -90 || b.North >= 90 {
+		// Bounds around a pole contain all longitudes.
+		b.South, b.North = math.Max(b.South, -90), math.Min(b.North, 90)
+		return b
+	}
+	dLon := dLat / math.Cos(c.Latitude*math.Pi/180)
+	if dLon >= 180 {
+		return b
+	}
+	b.West, b.East = wrapLongitude(c.Longitude-dLon), wrapLongitude(c.Longitude+dLon)
+	return b
+}
+
+// wrapLongitude wraps longitude into [-180, 180].
+func wrapLongitude(longitude float64) float64 {
+	if longitude < -180 {
+		return longitude + 360
+	}
+	if longitude > 180 {
+		return longitude - 360
+	}
+	return longitude
+}
+
+// intParam returns the query parameter name as number between min and max, or def if it is not set.
+func intParam(r *http.Request, name string, def int, min int, max int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be a number between %d and %d", name, min, max)
+	}
+	return n, nil
+}
+
+// floatParam returns the required query parameter name as number between min and max.
+func floatParam(r *http.Request, name string, min float64, max float64) (float64, error) {
+	n, err := strconv.ParseFloat(r.URL.Query().Get(name), 64)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be a number between %v and %v", name, min, max)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,210 @@
+package readapi
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeRepository finds documents in memory, ignoring the filters that do not apply to them.
+type fakeRepository struct {
+	storage.Repository
+	docs []storage.Document
+}
+
+func (f fakeRepository) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	var found []storage.Document
+	for _, doc := range f.docs {
+		if filter.After != "" && doc.Key() <= filter.After || len(filter.Keys) > 0 && filter.Keys[0] != doc.Key() {
+			continue
+		}
+		if location, ok := doc.(storage.Location); ok {
+			if filter.Country != "" && location.Country != filter.Country ||
+				filter.Bounds != nil && !filter.Bounds.Contains(location.Coordinates) ||
+				filter.Parameter != "" && len(withParameter(location, filter.Parameter).Measurements) == 0 {
+				continue
+			}
+		}
+		found = append(found, doc)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Key() < found[j].Key() })
+	if filter.Limit > 0 && len(found) > filter.Limit {
+		found = found[:filter.Limit]
+	}
+	return found, nil
+}
+
+func testLocation(name string, country string, latitude float64, longitude float64, parameters ...string) storage.Location {
+	location := storage.Location{Location: name, Country: country, Coordinates: storage.Coordinates{Latitude: latitude, Longitude: longitude}}
+	for _, p := range parameters {
+		location.Measurements = append(location.Measurements, storage.Measurement{Parameter: p, Value: 10})
+	}
+	return location
+}
+
+func Test_handler(t *testing.T) {
+	repos := Repositories{
+		Locations: fakeRepository{docs: []storage.Document{
+			testLocation("Berlin Mitte", "DE", 52.52, 13.40, "no2", "pm25"),
+			testLocation("Berlin Wedding", "DE", 52.55, 13.36, "no2"),
+			testLocation("Potsdam", "DE", 52.40, 13.06, "pm25"),
+			testLocation("Paris", "FR", 48.86, 2.35, "pm25"),
+		}},
+		Cities: fakeRepository{docs: []storage.Document{
+			storage.City{Name: "Berlin", Country: "DE", AirQuality: &storage.AirQuality{QualityIndex: 2, Parameters: []storage.ParameterQuality{
+				{Parameter: "no2", QualityIndex: 1}, {Parameter: "pm25", QualityIndex: 2},
+			}}},
+		}},
+		Countries: fakeRepository{docs: []storage.Document{storage.Country{Code: "DE"}, storage.Country{Code: "FR"}}},
+	}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		// wantKeys are the keys of the results, or of the document of single documents.
+		wantKeys []string
+		wantNext string
+	}{
+		{"locations", http.MethodGet, "/v1/locations", http.StatusOK, []string{"Berlin Mitte", "Berlin Wedding", "Paris", "Potsdam"}, ""},
+		{"locations page", http.MethodGet, "/v1/locations?limit=2", http.StatusOK, []string{"Berlin Mitte", "Berlin Wedding"}, "Berlin Wedding"},
+		{"locations next page", http.MethodGet, "/v1/locations?limit=2&after=Berlin+Wedding", http.StatusOK, []string{"Paris", "Potsdam"}, ""},
+		{"locations by country and parameter", http.MethodGet, "/v1/locations?country=DE&parameter=pm25", http.StatusOK, []string{"Berlin Mitte", "Potsdam"}, ""},
+		{"locations invalid limit", http.MethodGet, "/v1/locations?limit=1001", http.StatusBadRequest, nil, ""},
+		{"location", http.MethodGet, "/v1/locations/Berlin%20Mitte", http.StatusOK, []string{"Berlin Mitte"}, ""},
+		{"unknown location", http.MethodGet, "/v1/locations/Hamburg", http.StatusNotFound, nil, ""},
+		{"nearest", http.MethodGet, "/v1/nearest?lat=52.53&lon=13.38&radius=50", http.StatusOK, []string{"Berlin Mitte", "Berlin Wedding", "Potsdam"}, ""},
+		{"nearest with parameter", http.MethodGet, "/v1/nearest?lat=52.53&lon=13.38&radius=50&parameter=pm25&limit=1", http.StatusOK, []string{"Berlin Mitte"}, ""},
+		{"nearest out of radius", http.MethodGet, "/v1/nearest?lat=52.53&lon=13.38&radius=5", http.StatusOK, []string{"Berlin Mitte", "Berlin Wedding"}, ""},
+		{"nearest without coordinate", http.MethodGet, "/v1/nearest?lat=52.53", http.StatusBadRequest, nil, ""},
+		{"city", http.MethodGet, "/v1/cities/Berlin", http.StatusOK, []string{"Berlin"}, ""},
+		{"cities", http.MethodGet, "/v1/cities?country=DE", http.StatusOK, []string{"Berlin"}, ""},
+		{"countries", http.MethodGet, "/v1/countries?limit=1", http.StatusOK, []string{"DE"}, "DE"},
+		{"country", http.MethodGet, "/v1/countries/FR", http.StatusOK, []string{"FR"}, ""},
+		{"post", http.MethodPost, "/v1/locations", http.StatusMethodNotAllowed, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			NewHandler(repos).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Results []map[string]interface{} `json:"results"`
+				Next    string                   `json:"next"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Results == nil {
+				// A single document.
+				var doc map[string]interface{}
+				json.Unmarshal(rec.Body.Bytes(), &doc)
+				body.Results = append(body.Results, doc)
+			}
+			var keys []string
+			for _, result := range body.Results {
+				for _, field := range []string{"location", "code", "name"} {
+					if key, ok := result[field].(string); ok {
+						keys = append(keys, key)
+						break
+					}
+				}
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.wantKeys, ",") {
+				t.Errorf("keys = %v, want %v", keys, tt.wantKeys)
+			}
+			if body.Next != tt.wantNext {
+				t.Errorf("next = %v, want %v", body.Next, tt.wantNext)
+			}
+		})
+	}
+}
+
+func Test_handler_parameter(t *testing.T) {
+	repos := Repositories{
+		Locations: fakeRepository{docs: []storage.Document{testLocation("Berlin Mitte", "DE", 52.52, 13.40, "no2", "pm25")}},
+		Cities: fakeRepository{docs: []storage.Document{storage.City{Name: "Berlin", AirQuality: &storage.AirQuality{Parameters: []storage.ParameterQuality{
+			{Parameter: "no2"}, {Parameter: "pm25"},
+		}}}}},
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v1/locations/Berlin%20Mitte?parameter=pm25", `"measurements":[{"parameter":"pm25"`},
+		{"/v1/nearest?lat=52.52&lon=13.4&parameter=pm25", `"measurements":[{"parameter":"pm25"`},
+		{"/v1/cities/Berlin?parameter=no2", `"parameters":[{"parameter":"no2"`},
+		{"/v1/cities?parameter=pm25", `"parameters":[{"parameter":"pm25"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			NewHandler(repos).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if body := rec.Body.String(); rec.Code != http.StatusOK || !strings.Contains(body, tt.want) || strings.Count(body, `"parameter"`) != 1 {
+				t.Errorf("body = %v, want only %v", body, tt.want)
+			}
+		})
+	}
+}
+
+func Test_BoundsAround(t *testing.T) {
+	tests := []struct {
+		name   string
+		center storage.Coordinates
+		radius float64
+		want   storage.Bounds
+	}{
+		{"equator", storage.Coordinates{}, 111.195, storage.Bounds{South: -1, West: -1, North: 1, East: 1}},
+		{"antimeridian", storage.Coordinates{Longitude: 179.5}, 111.195, storage.Bounds{South: -1, West: 178.5, North: 1, East: -179.5}},
+		{"pole", storage.Coordinates{Latitude: 89.5}, 111.195, storage.Bounds{South: 88.5, West: -180, North: 90, East: 180}},
+	}
+	round := func(f float64) float64 { return math.Round(f*1000) / 1000 }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := BoundsAround(tt.center, tt.radius)
+			got := storage.Bounds{South: round(b.South), West: round(b.West), North: round(b.North), East: round(b.East)}
+			if got != tt.want {
+				t.Errorf("BoundsAround() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_DistanceKm(t *testing.T) {
+	berlin := storage.Coordinates{Latitude: 52.52, Longitude: 13.405}
+	paris := storage.Coordinates{Latitude: 48.8566, Longitude: 2.3522}
+	if got := DistanceKm(berlin, paris); math.Abs(got-878) > 2 {
+		t.Errorf("DistanceKm() = %v, want about 878", got)
+	}
+}
+
+func Test_Spec(t *testing.T) {
+	var spec struct {
+		OpenAPI string                 `yaml:"openapi"`
+		Paths   map[string]interface{} `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(Spec, &spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/v1/locations", "/v1/locations/{location}", "/v1/nearest", "/v1/cities", "/v1/cities/{city}", "/v1/countries", "/v1/countries/{code}"} {
+		if spec.Paths[path] == nil {
+			t.Errorf("spec misses %s", path)
+		}
+	}
+	if len(spec.Paths) != 7 || !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("spec = %v %v", spec.OpenAPI, spec.Paths)
+	}
+}
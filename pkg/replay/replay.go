@@ -0,0 +1,213 @@
+package replay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/archive"
+	"github.com/nhe23/aq-dbsync/pkg/source"
+	"github.com/nhe23/aq-dbsync/pkg/source/openaq"
+)
+
+// Endpoints maps the paths of the api endpoints to the names of their datasets.
+var Endpoints = map[string]string{
+	"/v1/cities":    "cities",
+	"/v1/countries": "countries",
+	"/v1/latest":    "measurements",
+}
+
+// Page is a recorded page of a dataset.
+type Page struct {
+	Dataset string
+	// URL identifies the page in the Source it was listed by.
+	URL string
+}
+
+// Source provides recorded pages of the OpenAQ api and lists them.
+type Source interface {
+	// Page returns the results of the page at url and the total number of results of the endpoint.
+	Page(ctx context.Context, url string) ([]interface{}, int, error)
+	// Pages returns the pages of datasets, grouped by dataset in the order of datasets and in
+	// recording order within a dataset.
+	Pages(ctx context.Context, datasets []string) ([]Page, error)
+}
+
+type dir struct {
+	root string
+}
+
+// NewDir creates a Source of the JSON files in root. The pages of a dataset are read from
+// root/<dataset>.json and all .json files below root/<dataset>/ in lexical order. A file holds
+// an api page or a bare array of results.
+func NewDir(root string) Source {
+	return dir{root}
+}
+
+func (d dir) Pages(ctx context.Context, datasets []string) ([]Page, error) {
+	var pages []Page
+	for _, dataset := range datasets {
+		var files []string
+		file := filepath.Join(d.root, dataset+".json")
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+		var nested []string
+		err := filepath.Walk(filepath.Join(d.root, dataset), func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(path, ".json") {
+				nested = append(nested, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s files: %w", dataset, err)
+		}
+		sort.Strings(nested)
+		for _, file := range append(files, nested...) {
+			pages = append(pages, Page{Dataset: dataset, URL: file})
+		}
+	}
+	return pages, nil
+}
+
+func (d dir) Page(ctx context.Context, path string) ([]interface{}, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return decodeFile(data)
+}
+
+// decodeFile decodes an api page or a bare array of results. The total of a page without
+// metadata is the number of its results.
+func decodeFile(data []byte) ([]interface{}, int, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		var results []interface{}
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, 0, fmt.Errorf("error decoding results: %w", err)
+		}
+		return results, len(results), nil
+	}
+	var page map[string]interface{}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, 0, fmt.Errorf("error decoding page: %w", err)
+	}
+	if _, hasMeta := page["meta"]; !hasMeta {
+		page["meta"] = map[string]interface{}{}
+	}
+	if meta, ok := page["meta"].(map[string]interface{}); ok {
+		if _, hasFound := meta["found"]; !hasFound {
+			results, _ := page["results"].([]interface{})
+			meta["found"] = float64(len(results))
+		}
+	}
+	return openaq.ParsePage(page)
+}
+
+type archiveSource struct {
+	store archive.Store
+	from  time.Time
+	to    time.Time
+}
+
+// NewArchive creates a Source of the successful responses in an archive that were fetched in
+// the range from to to. Zero times do not limit the range, to is exclusive.
+func NewArchive(store archive.Store, from time.Time, to time.Time) Source {
+	return archiveSource{store, from, to}
+}
+
+func (a archiveSource) Pages(ctx context.Context, datasets []string) ([]Page, error) {
+	objects, err := a.store.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("error listing archive: %w", err)
+	}
+	byDataset := make(map[string][]Page)
+	for _, o := range objects {
+		if !strings.HasSuffix(o.Key, archive.MetaSuffix) {
+			continue
+		}
+		record, err := a.record(ctx, o.Key)
+		if err != nil {
+			return nil, err
+		}
+		if record.Status != 200 || (!a.from.IsZero() && record.FetchedAt.Before(a.from)) || (!a.to.IsZero() && !record.FetchedAt.Before(a.to)) {
+			continue
+		}
+		u, err := url.Parse(record.URL)
+		if err != nil {
+			continue
+		}
+		if dataset, ok := Endpoints[u.Path]; ok {
+			byDataset[dataset] = append(byDataset[dataset], Page{Dataset: dataset, URL: o.Key})
+		}
+	}
+	var pages []Page
+	for _, dataset := range datasets {
+		pages = append(pages, byDataset[dataset]...)
+	}
+	return pages, nil
+}
+
+func (a archiveSource) record(ctx context.Context, key string) (archive.Record, error) {
+	var record archive.Record
+	r, err := a.store.Get(ctx, key)
+	if err != nil {
+		return record, fmt.Errorf("error reading %s: %w", key, err)
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(&record); err != nil {
+		return record, fmt.Errorf("error decoding %s: %w", key, err)
+	}
+	return record, nil
+}
+
+func (a archiveSource) Page(ctx context.Context, key string) ([]interface{}, int, error) {
+	record, err := a.record(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := a.store.Get(ctx, record.BodyKey)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading %s: %w", record.BodyKey, err)
+	}
+	defer r.Close()
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error decompressing %s: %w", record.BodyKey, err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error decompressing %s: %w", record.BodyKey, err)
+	}
+	return decodeFile(body)
+}
+
+// Recorded returns provider reading its pages from pages, e.g. to map recorded pages to documents.
+func Recorded(provider source.Source, pages Source) source.Source {
+	return recorded{provider, pages}
+}
+
+type recorded struct {
+	source.Source
+	pages Source
+}
+
+func (r recorded) Fetch(ctx context.Context, url string) ([]interface{}, int, error) {
+	return r.pages.Page(ctx, url)
+}
@@ -0,0 +1,151 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nhe23/aq-dbsync/pkg/archive"
+	"github.com/nhe23/aq-dbsync/pkg/source/openaq"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_decodeFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		results int
+		total   int
+		wantErr bool
+	}{
+		{"array", `[{"city": "a"}, {"city": "b"}]`, 2, 2, false},
+		{"page", `{"meta": {"found": 12}, "results": [{"city": "a"}]}`, 1, 12, false},
+		{"page without found", `{"meta": {}, "results": [{"city": "a"}]}`, 1, 1, false},
+		{"page without meta", `{"results": [{"city": "a"}, {"city": "b"}]}`, 2, 2, false},
+		{"invalid", `{"results": `, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, total, err := decodeFile([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(results) != tt.results || total != tt.total {
+				t.Errorf("decodeFile() = %d results, total %d, want %d, %d", len(results), total, tt.results, tt.total)
+			}
+		})
+	}
+}
+
+func Test_dir_sample_files(t *testing.T) {
+	for _, file := range []string{"../../result.json", "../../testutils/results.json"} {
+		results, total, err := NewDir("").Page(context.Background(), file)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if len(results) == 0 || total < len(results) {
+			t.Errorf("%s: %d results, total %d", file, len(results), total)
+		}
+	}
+}
+
+func Test_Recorded(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "measurements.json"), `[{"location": "a", "measurements": [{"parameter": "pm25", "value": 5}]}]`)
+	src := Recorded(openaq.NewSource(openaq.Options{PageSize: 100}), NewDir(root))
+	results, total, err := src.Fetch(context.Background(), filepath.Join(root, "measurements.json"))
+	if err != nil || total != 1 {
+		t.Fatalf("Fetch() = %v, %v, %v", results, total, err)
+	}
+	docs, err := src.Documents("measurements", results)
+	if err != nil || len(docs) != 1 || docs[0].Key() != "a" || src.ID() != openaq.ID {
+		t.Errorf("Documents() = %v, %v", docs, err)
+	}
+}
+
+func Test_dir_Pages(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "cities.json"), `[]`)
+	writeFile(t, filepath.Join(root, "measurements", "2.json"), `[]`)
+	writeFile(t, filepath.Join(root, "measurements", "1.json"), `[]`)
+	writeFile(t, filepath.Join(root, "measurements", "notes.txt"), ``)
+
+	pages, err := NewDir(root).Pages(context.Background(), []string{"cities", "countries", "measurements"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Page{
+		{"cities", filepath.Join(root, "cities.json")},
+		{"measurements", filepath.Join(root, "measurements", "1.json")},
+		{"measurements", filepath.Join(root, "measurements", "2.json")},
+	}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("Pages() = %v, want %v", pages, want)
+	}
+}
+
+func Test_archiveSource(t *testing.T) {
+	ctx := context.Background()
+	store := archive.NewDirStore(t.TempDir())
+	archiver := archive.NewArchiver(store, archive.Retention{}, log.NewNopLogger())
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	responses := []struct {
+		url       string
+		fetchedAt time.Time
+		status    int
+		body      string
+	}{
+		{"http://api/v1/latest?limit=1&page=1", day.Add(time.Hour), 200, `{"meta": {"found": 2}, "results": [{"location": "a"}]}`},
+		{"http://api/v1/cities?limit=1&page=1", day.Add(2 * time.Hour), 200, `{"meta": {"found": 1}, "results": [{"city": "a"}]}`},
+		{"http://api/v1/latest?limit=1&page=2", day.Add(3 * time.Hour), 500, `error`},
+		{"http://api/v1/latest?limit=1&page=2", day.Add(4 * time.Hour), 200, `{"meta": {"found": 2}, "results": [{"location": "b"}]}`},
+		{"http://api/v1/latest?limit=1&page=1", day.Add(25 * time.Hour), 200, `{"meta": {"found": 1}, "results": [{"location": "c"}]}`},
+		{"http://api/v1/parameters", day.Add(time.Hour), 200, `{"results": []}`},
+	}
+	for _, r := range responses {
+		if err := archiver.Archive(ctx, r.url, r.fetchedAt, r.status, http.Header{}, []byte(r.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := NewArchive(store, day, day.Add(24*time.Hour))
+	pages, err := src.Pages(ctx, []string{"cities", "measurements"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, page := range pages {
+		results, total, err := src.Page(ctx, page.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("page %s has %d results", page.URL, len(results))
+		}
+		result := results[0].(map[string]interface{})
+		name := result["location"]
+		if name == nil {
+			name = result["city"]
+		}
+		got = append(got, fmt.Sprintf("%s:%s:%d", page.Dataset, name, total))
+	}
+	want := []string{"cities:a:1", "measurements:a:2", "measurements:b:2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed pages = %v, want %v", got, want)
+	}
+}
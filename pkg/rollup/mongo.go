@@ -0,0 +1,57 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection consists of the used functions of a mongo rollup collection.
+type Collection interface {
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
+
+type mongoStore struct {
+	cols map[string]Collection
+}
+
+// NewMongoStore creates a Store of the hourly and daily rollups in two mongo collections.
+func NewMongoStore(hourly Collection, daily Collection) Store {
+	return mongoStore{map[string]Collection{Hour: hourly, Day: daily}}
+}
+
+// Keys are the fields identifying a rollup, e.g. for a unique index.
+var Keys = []string{"level", "country", "city", "location", "parameter", "unit", "start"}
+
+// Replace deletes the rollups of the range before upserting rollups in the same ordered bulk write.
+func (m mongoStore) Replace(ctx context.Context, period string, from time.Time, to time.Time, rollups []Rollup) error {
+	col, ok := m.cols[period]
+	if !ok {
+		return fmt.Errorf("unknown period %q", period)
+	}
+	operations := make([]mongo.WriteModel, 0, len(rollups)+1)
+	operations = append(operations, mongo.NewDeleteManyModel().SetFilter(bson.M{"start": bson.M{"$gte": from, "$lt": to}}))
+	for _, r := range rollups {
+		mongoOperation := mongo.NewReplaceOneModel()
+		mongoOperation.SetFilter(bson.M{
+			"level":     r.Level,
+			"country":   r.Country,
+			"city":      r.City,
+			"location":  r.Location,
+			"parameter": r.Parameter,
+			"unit":      r.Unit,
+			"start":     r.Start,
+		})
+		mongoOperation.SetReplacement(r)
+		mongoOperation.SetUpsert(true)
+		operations = append(operations, mongoOperation)
+	}
+	bulkOption := options.BulkWriteOptions{}
+	bulkOption.SetOrdered(true)
+	_, err := col.BulkWrite(ctx, operations, &bulkOption)
+	return err
+}
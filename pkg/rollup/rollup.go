@@ -0,0 +1,287 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/backfill"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// Levels of the rollups.
+const (
+	LevelLocation = "location"
+	LevelCity     = "city"
+	LevelCountry  = "country"
+)
+
+// Periods of the rollups.
+const (
+	Hour = "hour"
+	Day  = "day"
+)
+
+// Rollup aggregates the measurements of a parameter of a location, city or country in a period.
+// The fields below the level are empty, e.g. Location for city rollups.
+type Rollup struct {
+	Level     string    `bson:"level" json:"level"`
+	Country   string    `bson:"country" json:"country"`
+	City      string    `bson:"city" json:"city"`
+	Location  string    `bson:"location" json:"location"`
+	Parameter string    `bson:"parameter" json:"parameter"`
+	Unit      string    `bson:"unit" json:"unit"`
+	Start     time.Time `bson:"start" json:"start"`
+	Count     int       `bson:"count" json:"count"`
+	Min       float64   `bson:"min" json:"min"`
+	Max       float64   `bson:"max" json:"max"`
+	Mean      float64   `bson:"mean" json:"mean"`
+	P95       float64   `bson:"p95" json:"p95"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Store stores the rollups of a period.
+type Store interface {
+	// Replace replaces all stored rollups of period that start in [from, to) by rollups, so
+	// groups without rollups in that range are deleted.
+	Replace(ctx context.Context, period string, from time.Time, to time.Time, rollups []Rollup) error
+}
+
+// Result summarises an update of the rollups.
+type Result struct {
+	Days    int `json:"days"`
+	Samples int `json:"samples"`
+	Rollups int `json:"rollups"`
+}
+
+// Rollupper keeps the measurements of the synced locations in the history and recomputes the
+// rollups of the days they fall into.
+type Rollupper struct {
+	history backfill.HistoryStore
+	reader  backfill.HistoryReader
+	store   Store
+	mu      sync.Mutex
+	days    map[time.Time]bool
+}
+
+// NewRollupper creates a Rollupper.
+func NewRollupper(history backfill.HistoryStore, reader backfill.HistoryReader, store Store) *Rollupper {
+	return &Rollupper{history: history, reader: reader, store: store, days: make(map[time.Time]bool)}
+}
+
+// Add writes the measurements of locations into the history and marks their days for Flush.
+func (r *Rollupper) Add(ctx context.Context, locations []storage.Location) error {
+	var docs []backfill.HistoryMeasurement
+	for _, l := range locations {
+		for _, m := range l.Measurements {
+			if m.LastUpdated.IsZero() {
+				continue
+			}
+			doc := backfill.HistoryMeasurement{
+				Location:  l.Location,
+				City:      l.City,
+				Country:   l.Country,
+				Parameter: m.Parameter,
+				Value:     m.Value,
+				Unit:      m.Unit,
+				Date:      m.LastUpdated.UTC(),
+			}
+			doc.Coordinates.Latitude = l.Coordinates.Latitude
+			doc.Coordinates.Longitude = l.Coordinates.Longitude
+			docs = append(docs, doc)
+		}
+	}
+	if err := backfill.WriteHistory(ctx, r.history, docs); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, doc := range docs {
+		r.days[doc.Date.Truncate(24*time.Hour)] = true
+	}
+	return nil
+}
+
+// Flush recomputes the rollups of the days of the measurements added since the last Flush.
+func (r *Rollupper) Flush(ctx context.Context) (Result, error) {
+	r.mu.Lock()
+	days := make([]time.Time, 0, len(r.days))
+	for day := range r.days {
+		days = append(days, day)
+	}
+	r.days = make(map[time.Time]bool)
+	r.mu.Unlock()
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	var result Result
+	for i, day := range days {
+		dayResult, err := r.recomputeDay(ctx, day)
+		if err != nil {
+			// The remaining days are kept for the next Flush.
+			r.mu.Lock()
+			for _, day := range days[i:] {
+				r.days[day] = true
+			}
+			r.mu.Unlock()
+			return result, err
+		}
+		result.Days++
+		result.Samples += dayResult.Samples
+		result.Rollups += dayResult.Rollups
+	}
+	return result, nil
+}
+
+// Recompute recomputes the rollups of all days overlapping the range from to to from the history.
+func (r *Rollupper) Recompute(ctx context.Context, from time.Time, to time.Time) (Result, error) {
+	var result Result
+	for day := from.UTC().Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		dayResult, err := r.recomputeDay(ctx, day)
+		if err != nil {
+			return result, err
+		}
+		result.Days++
+		result.Samples += dayResult.Samples
+		result.Rollups += dayResult.Rollups
+	}
+	return result, nil
+}
+
+// recomputeDay computes the hourly and daily rollups of a day from all its measurements in the
+// history, so recomputing a day after late or corrected measurements arrived replaces its rollups.
+// Rollups of groups without valid measurements left are deleted.
+func (r *Rollupper) recomputeDay(ctx context.Context, day time.Time) (Result, error) {
+	var result Result
+	hourly := newAggregator(Hour)
+	daily := newAggregator(Day)
+	filter := backfill.HistoryFilter{From: day, To: day.Add(24 * time.Hour)}
+	err := r.reader.Each(ctx, filter, func(m backfill.HistoryMeasurement) error {
+		// Negative values mark invalid measurements of the api.
+		if m.Value < 0 {
+			return nil
+		}
+		result.Samples++
+		hourly.add(m)
+		daily.add(m)
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("error reading history of %s: %w", day.Format("2006-01-02"), err)
+	}
+	now := time.Now().UTC()
+	for _, a := range []*aggregator{hourly, daily} {
+		rollups := a.rollups(now)
+		if err := r.store.Replace(ctx, a.period, day, day.Add(24*time.Hour), rollups); err != nil {
+			return result, fmt.Errorf("error storing %s rollups of %s: %w", a.period, day.Format("2006-01-02"), err)
+		}
+		result.Rollups += len(rollups)
+	}
+	return result, nil
+}
+
+type group struct {
+	level, country, city, location, parameter, unit string
+	start                                           time.Time
+}
+
+// aggregator collects the values of the groups of a period.
+type aggregator struct {
+	period string
+	values map[group][]float64
+}
+
+func newAggregator(period string) *aggregator {
+	return &aggregator{period: period, values: make(map[group][]float64)}
+}
+
+func (a *aggregator) add(m backfill.HistoryMeasurement) {
+	start := m.Date.UTC().Truncate(time.Hour)
+	if a.period == Day {
+		start = m.Date.UTC().Truncate(24 * time.Hour)
+	}
+	for _, g := range []group{
+		{LevelLocation, m.Country, m.City, m.Location, m.Parameter, m.Unit, start},
+		{LevelCity, m.Country, m.City, "", m.Parameter, m.Unit, start},
+		{LevelCountry, m.Country, "", "", m.Parameter, m.Unit, start},
+	} {
+		a.values[g] = append(a.values[g], m.Value)
+	}
+}
+
+// rollups returns the rollups of all groups ordered by group.
+func (a *aggregator) rollups(updatedAt time.Time) []Rollup {
+	rollups := make([]Rollup, 0, len(a.values))
+	for g, values := range a.values {
+		r := Rollup{
+			Level:     g.level,
+			Country:   g.country,
+			City:      g.city,
+			Location:  g.location,
+			Parameter: g.parameter,
+			Unit:      g.unit,
+			Start:     g.start,
+			UpdatedAt: updatedAt,
+		}
+		r.Count, r.Min, r.Max, r.Mean, r.P95 = stats(values)
+		rollups = append(rollups, r)
+	}
+	sort.Slice(rollups, func(i, j int) bool { return less(rollups[i], rollups[j]) })
+	return rollups
+}
+
+func less(a Rollup, b Rollup) bool {
+	for _, f := range [][2]string{{a.Level, b.Level}, {a.Country, b.Country}, {a.City, b.City}, {a.Location, b.Location}, {a.Parameter, b.Parameter}, {a.Unit, b.Unit}} {
+		if f[0] != f[1] {
+			return f[0] < f[1]
+		}
+	}
+	return a.Start.Before(b.Start)
+}
+
+// stats returns the count, min, max, mean and 95th percentile of values. The percentile uses the
+// nearest-rank method.
+func stats(values []float64) (int, float64, float64, float64, float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	n := len(sorted)
+	rank := int(math.Ceil(0.95*float64(n))) - 1
+	return n, sorted[0], sorted[n-1], sum / float64(n), sorted[rank]
+}
+
+type repository struct {
+	storage.Repository
+	rollupper *Rollupper
+}
+
+// Repository wraps a repository of locations so that the measurements of upserted locations
+// are added to the rollupper.
+func (r *Rollupper) Repository(repo storage.Repository) storage.Repository {
+	return repository{repo, r}
+}
+
+func (r repository) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	result, err := r.Repository.Upsert(ctx, docs)
+	if err != nil {
+		return result, err
+	}
+	locations := make([]storage.Location, 0, len(docs))
+	for _, doc := range docs {
+		if l, ok := doc.(storage.Location); ok {
+			locations = append(locations, l)
+		}
+	}
+	if err := r.rollupper.Add(ctx, locations); err != nil {
+		return result, fmt.Errorf("error adding measurements to rollups: %w", err)
+	}
+	return result, nil
+}
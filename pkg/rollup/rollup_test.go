@@ -0,0 +1,247 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/backfill"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// memoryHistory stores historical measurements by location, parameter and date.
+type memoryHistory map[string]backfill.HistoryMeasurement
+
+func (m memoryHistory) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	for _, model := range models {
+		doc := model.(*mongo.ReplaceOneModel).Replacement.(backfill.HistoryMeasurement)
+		m[fmt.Sprint(doc.Location, doc.Parameter, doc.Date)] = doc
+	}
+	return &mongo.BulkWriteResult{}, nil
+}
+
+func (m memoryHistory) Each(ctx context.Context, filter backfill.HistoryFilter, fn func(backfill.HistoryMeasurement) error) error {
+	for _, doc := range m {
+		if !doc.Date.Before(filter.From) && doc.Date.Before(filter.To) {
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// memoryStore stores rollups by period and group.
+type memoryStore map[string]Rollup
+
+func (m memoryStore) Replace(ctx context.Context, period string, from time.Time, to time.Time, rollups []Rollup) error {
+	for key, r := range m {
+		if key[:len(period)] == period && !r.Start.Before(from) && r.Start.Before(to) {
+			delete(m, key)
+		}
+	}
+	for _, r := range rollups {
+		m[fmt.Sprint(period, r.Level, r.Country, r.City, r.Location, r.Parameter, r.Unit, r.Start)] = r
+	}
+	return nil
+}
+
+func (m memoryStore) get(period string, level string, location string, start time.Time) (Rollup, bool) {
+	for key, r := range m {
+		if key[:len(period)] == period && r.Level == level && r.Location == location && r.Start.Equal(start) {
+			return r, true
+		}
+	}
+	return Rollup{}, false
+}
+
+type nopRepository struct{}
+
+func (nopRepository) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	return storage.UpsertResult{Upserted: int64(len(docs))}, nil
+}
+
+func (nopRepository) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	return nil, nil
+}
+
+func (nopRepository) DeleteStale(ctx context.Context, provider string, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func Test_stats(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		count  int
+		min    float64
+		max    float64
+		mean   float64
+		p95    float64
+	}{
+		{"single", []float64{7}, 1, 7, 7, 7, 7},
+		{"unsorted", []float64{3, 1, 2}, 3, 1, 3, 2, 3},
+		{"twenty", []float64{20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, 20, 1, 20, 10.5, 19},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, min, max, mean, p95 := stats(tt.values)
+			if count != tt.count || min != tt.min || max != tt.max || mean != tt.mean || p95 != tt.p95 {
+				t.Errorf("stats() = %d, %v, %v, %v, %v, want %d, %v, %v, %v, %v", count, min, max, mean, p95, tt.count, tt.min, tt.max, tt.mean, tt.p95)
+			}
+		})
+	}
+}
+
+func Test_Rollupper(t *testing.T) {
+	ctx := context.Background()
+	history, store := memoryHistory{}, memoryStore{}
+	rollupper := NewRollupper(history, history, store)
+	repo := rollupper.Repository(nopRepository{})
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	location := func(name string, city string, values map[time.Time]float64) storage.Location {
+		l := storage.Location{Location: name, City: city, Country: "DE"}
+		for date, value := range values {
+			l.Measurements = append(l.Measurements, storage.Measurement{Parameter: "pm10", Value: value, Unit: "µg/m³", LastUpdated: date})
+		}
+		return l
+	}
+
+	docs := []storage.Document{
+		location("a", "Berlin", map[time.Time]float64{day.Add(10 * time.Hour): 10, day.Add(10*time.Hour + 30*time.Minute): 20, day.Add(11 * time.Hour): -99}),
+		location("b", "Berlin", map[time.Time]float64{day.Add(10 * time.Hour): 30}),
+	}
+	if _, err := repo.Upsert(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+	result, err := rollupper.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Days != 1 || result.Samples != 3 {
+		t.Errorf("Flush() = %+v, want 1 day and 3 samples", result)
+	}
+	if r, _ := store.get(Hour, LevelLocation, "a", day.Add(10*time.Hour)); r.Count != 2 || r.Mean != 15 || r.Max != 20 {
+		t.Errorf("hourly rollup of a = %+v", r)
+	}
+	if r, _ := store.get(Day, LevelCity, "", day); r.Count != 3 || r.Min != 10 || r.Max != 30 || r.City != "Berlin" {
+		t.Errorf("daily rollup of Berlin = %+v", r)
+	}
+	if _, found := store.get(Hour, LevelLocation, "a", day.Add(11*time.Hour)); found {
+		t.Errorf("invalid measurement was rolled up")
+	}
+	rollups := len(store)
+
+	// A late measurement of an earlier hour recomputes its day without duplicating rollups.
+	late := location("c", "Hamburg", map[time.Time]float64{day.Add(10 * time.Hour): 50})
+	if _, err := repo.Upsert(ctx, []storage.Document{late}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rollupper.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := store.get(Day, LevelCountry, "", day); r.Count != 4 || r.Max != 50 || r.P95 != 50 {
+		t.Errorf("daily rollup of DE = %+v", r)
+	}
+	// c adds hourly and daily rollups for the location and its city.
+	if len(store) != rollups+4 {
+		t.Errorf("%d rollups stored, want %d", len(store), rollups+4)
+	}
+	if result, _ := rollupper.Flush(ctx); result.Days != 0 {
+		t.Errorf("Flush() without new measurements = %+v", result)
+	}
+}
+
+func Test_Rollupper_Recompute(t *testing.T) {
+	history, store := memoryHistory{}, memoryStore{}
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	history.BulkWrite(context.Background(), []mongo.WriteModel{
+		mongo.NewReplaceOneModel().SetReplacement(backfill.HistoryMeasurement{Location: "a", Country: "DE", Parameter: "no2", Value: 5, Date: day.Add(time.Hour)}),
+		mongo.NewReplaceOneModel().SetReplacement(backfill.HistoryMeasurement{Location: "a", Country: "DE", Parameter: "no2", Value: 7, Date: day.Add(49 * time.Hour)}),
+	})
+	result, err := NewRollupper(history, history, store).Recompute(context.Background(), day.Add(12*time.Hour), day.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Days != 2 || result.Samples != 1 {
+		t.Errorf("Recompute() = %+v, want 2 days and 1 sample", result)
+	}
+	if _, found := store.get(Day, LevelLocation, "a", day.Add(48*time.Hour)); found {
+		t.Errorf("day after the range was recomputed")
+	}
+}
+
+func Test_Rollupper_RecomputeInvalidated(t *testing.T) {
+	history, store := memoryHistory{}, memoryStore{}
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	measurement := func(location string, value float64) mongo.WriteModel {
+		return mongo.NewReplaceOneModel().SetReplacement(backfill.HistoryMeasurement{Location: location, Country: "DE", Parameter: "no2", Value: value, Date: day.Add(time.Hour)})
+	}
+	rollupper := NewRollupper(history, history, store)
+	history.BulkWrite(context.Background(), []mongo.WriteModel{measurement("a", 5), measurement("b", 7)})
+	if _, err := rollupper.Recompute(context.Background(), day, day.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := store.get(Hour, LevelLocation, "a", day.Add(time.Hour)); !found {
+		t.Fatalf("hourly rollup of a missing")
+	}
+
+	// A backfill corrected the measurement of a to an invalid value.
+	history.BulkWrite(context.Background(), []mongo.WriteModel{measurement("a", -99)})
+	if _, err := rollupper.Recompute(context.Background(), day, day.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for period, start := range map[string]time.Time{Hour: day.Add(time.Hour), Day: day} {
+		if r, found := store.get(period, LevelLocation, "a", start); found {
+			t.Errorf("%s rollup of a without valid measurements = %+v", period, r)
+		}
+		if r, _ := store.get(period, LevelCountry, "", start); r.Count != 1 || r.Max != 7 {
+			t.Errorf("%s rollup of DE = %+v, want only b", period, r)
+		}
+	}
+}
+
+// recordingCollection records the bulk writes of a mongo collection.
+type recordingCollection struct {
+	models  []mongo.WriteModel
+	ordered bool
+}
+
+func (c *recordingCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	c.models = models
+	c.ordered = opts[0].Ordered != nil && *opts[0].Ordered
+	return &mongo.BulkWriteResult{}, nil
+}
+
+func Test_mongoStore_Replace(t *testing.T) {
+	hourly, daily := &recordingCollection{}, &recordingCollection{}
+	store := NewMongoStore(hourly, daily)
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	if err := store.Replace(context.Background(), Day, day, day.Add(24*time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Replace(context.Background(), Hour, day, day.Add(24*time.Hour), []Rollup{{Level: LevelLocation, Location: "a", Start: day}}); err != nil {
+		t.Fatal(err)
+	}
+	// The rollups of the range are deleted first, even if there are no rollups left.
+	for _, c := range []*recordingCollection{daily, hourly} {
+		if len(c.models) == 0 || !c.ordered {
+			t.Fatalf("bulk write %+v, want an ordered write", c)
+		}
+		deleteModel, ok := c.models[0].(*mongo.DeleteManyModel)
+		if !ok || !reflect.DeepEqual(deleteModel.Filter, bson.M{"start": bson.M{"$gte": day, "$lt": day.Add(24 * time.Hour)}}) {
+			t.Errorf("first operation = %+v, want deleting the rollups of the day", c.models[0])
+		}
+	}
+	if len(daily.models) != 1 || len(hourly.models) != 2 {
+		t.Errorf("%d daily and %d hourly operations, want 1 and 2", len(daily.models), len(hourly.models))
+	}
+	if err := store.Replace(context.Background(), "week", day, day, nil); err == nil {
+		t.Errorf("Replace() of an unknown period succeeded")
+	}
+}
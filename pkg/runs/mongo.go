@@ -0,0 +1,77 @@
+package runs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexOptionsConflict is the error code of creating an index that exists with other options.
+const indexOptionsConflict = 85
+
+type mongoStore struct {
+	col *mongo.Collection
+}
+
+// NewMongoStore creates a Store backed by a mongo collection. Expired runs are removed by the
+// index of EnsureRetention.
+func NewMongoStore(col *mongo.Collection) Store {
+	return mongoStore{col}
+}
+
+func (m mongoStore) Save(ctx context.Context, run Run) error {
+	_, err := m.col.ReplaceOne(ctx, bson.M{"_id": run.ID}, run, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m mongoStore) Recent(ctx context.Context, limit int) ([]Run, error) {
+	return m.find(ctx, bson.M{}, limit)
+}
+
+func (m mongoStore) LastSuccess(ctx context.Context, dataset string) (*Run, error) {
+	runs, err := m.find(ctx, bson.M{"datasets": bson.M{"$elemMatch": bson.M{"name": dataset, "status": StatusOK}}}, 1)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return &runs[0], nil
+}
+
+func (m mongoStore) find(ctx context.Context, filter bson.M, limit int) ([]Run, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "finishedAt", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := m.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]Run, 0)
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// RetentionIndex returns the TTL index removing runs retention after they finished.
+func RetentionIndex(retention time.Duration) mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: "finishedAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds())),
+	}
+}
+
+// EnsureRetention creates the TTL index of retention on the runs collection col of db or updates
+// the retention of the existing index.
+func EnsureRetention(ctx context.Context, db *mongo.Database, col string, retention time.Duration) error {
+	index := RetentionIndex(retention)
+	_, err := db.Collection(col).Indexes().CreateOne(ctx, index)
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Code != indexOptionsConflict {
+		return err
+	}
+	return db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: col},
+		{Key: "index", Value: bson.M{"keyPattern": index.Keys, "expireAfterSeconds": *index.Options.ExpireAfterSeconds}},
+	}).Err()
+}
@@ -0,0 +1,63 @@
+// Package runs keeps the history of sync runs.
+package runs
+
+import (
+	"context"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// StatusOK is the status of a successfully synced dataset.
+const StatusOK = "ok"
+
+// MaxRejectedDocs is the maximum number of rejected documents listed per dataset.
+const MaxRejectedDocs = 20
+
+// Run is a sync of one or more datasets.
+type Run struct {
+	ID string `bson:"_id" json:"id"`
+	// Trigger is what started the run, e.g. a command or the schedule.
+	Trigger    string    `bson:"trigger" json:"trigger"`
+	Status     string    `bson:"status" json:"status"`
+	StartedAt  time.Time `bson:"startedAt" json:"startedAt"`
+	FinishedAt time.Time `bson:"finishedAt" json:"finishedAt"`
+	Datasets   []Dataset `bson:"datasets" json:"datasets"`
+}
+
+// Dataset is the sync of a dataset within a run.
+type Dataset struct {
+	Name            string    `bson:"name" json:"name"`
+	Status          string    `bson:"status" json:"status"`
+	StartedAt       time.Time `bson:"startedAt" json:"startedAt"`
+	DurationSeconds float64   `bson:"durationSeconds" json:"durationSeconds"`
+	// Pages is the number of api pages processed.
+	Pages    int   `bson:"pages" json:"pages"`
+	Matched  int64 `bson:"matched" json:"matched"`
+	Modified int64 `bson:"modified" json:"modified"`
+	Upserted int64 `bson:"upserted" json:"upserted"`
+	// Rejected is the number of documents the storage refused to write, the first of them are
+	// listed in RejectedDocs.
+	Rejected     int                 `bson:"rejected" json:"rejected"`
+	RejectedDocs []storage.Rejection `bson:"rejectedDocs,omitempty" json:"rejectedDocs,omitempty"`
+	Error        string              `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// SetResult sets the counts of the written documents.
+func (d *Dataset) SetResult(result storage.UpsertResult) {
+	d.Matched, d.Modified, d.Upserted = result.Matched, result.Modified, result.Upserted
+	d.Rejected = len(result.Rejected)
+	d.RejectedDocs = result.Rejected
+	if len(d.RejectedDocs) > MaxRejectedDocs {
+		d.RejectedDocs = d.RejectedDocs[:MaxRejectedDocs]
+	}
+}
+
+// Store keeps the history of runs.
+type Store interface {
+	Save(ctx context.Context, run Run) error
+	// Recent returns the latest limit runs, newest first.
+	Recent(ctx context.Context, limit int) ([]Run, error)
+	// LastSuccess returns the latest run that synced dataset successfully or nil if there is none.
+	LastSuccess(ctx context.Context, dataset string) (*Run, error)
+}
@@ -0,0 +1,48 @@
+package runs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+func Test_Dataset_SetResult(t *testing.T) {
+	rejected := func(n int) []storage.Rejection {
+		var r []storage.Rejection
+		for i := 0; i < n; i++ {
+			r = append(r, storage.Rejection{Key: fmt.Sprint(i), Reason: "Document failed validation"})
+		}
+		return r
+	}
+	tests := []struct {
+		name         string
+		result       storage.UpsertResult
+		wantRejected int
+		wantDocs     int
+	}{
+		{"no rejections", storage.UpsertResult{Matched: 3, Modified: 2, Upserted: 1}, 0, 0},
+		{"rejections", storage.UpsertResult{Matched: 3, Modified: 2, Upserted: 1, Rejected: rejected(2)}, 2, 2},
+		{"truncated", storage.UpsertResult{Matched: 3, Modified: 2, Upserted: 1, Rejected: rejected(MaxRejectedDocs + 5)}, MaxRejectedDocs + 5, MaxRejectedDocs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d Dataset
+			d.SetResult(tt.result)
+			if d.Matched != 3 || d.Modified != 2 || d.Upserted != 1 || d.Rejected != tt.wantRejected {
+				t.Errorf("Dataset = %+v", d)
+			}
+			if len(d.RejectedDocs) != tt.wantDocs || tt.wantDocs > 0 && d.RejectedDocs[0].Key != "0" {
+				t.Errorf("Dataset.RejectedDocs = %v", d.RejectedDocs)
+			}
+		})
+	}
+}
+
+func Test_RetentionIndex(t *testing.T) {
+	index := RetentionIndex(30 * 24 * time.Hour)
+	if got := *index.Options.ExpireAfterSeconds; got != 30*24*3600 {
+		t.Errorf("ExpireAfterSeconds = %v", got)
+	}
+}
@@ -0,0 +1,220 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/robfig/cron/v3"
+)
+
+// Job is a task that is run periodically by the Scheduler.
+type Job struct {
+	// Name identifies the job in logs.
+	Name string
+	// Schedule is a cron expression ("*/10 * * * *", "@daily", "@every 1h") or a plain interval ("10m").
+	Schedule string
+	// Jitter is the upper bound of a random delay added before each run.
+	Jitter time.Duration
+	// Timeout limits the duration of a single run. Zero means no limit.
+	Timeout time.Duration
+	// Run performs the work. The context is cancelled on shutdown or when the timeout is exceeded.
+	Run func(ctx context.Context) error
+}
+
+// Errors of Trigger.
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrJobRunning = errors.New("job is already running")
+)
+
+// Scheduler runs jobs on their own schedules and never runs the same job twice at the same time.
+type Scheduler struct {
+	ctx    context.Context
+	cron   *cron.Cron
+	logger log.Logger
+	jobs   []*scheduledJob
+	paused atomic.Bool
+}
+
+type scheduledJob struct {
+	Job
+	schedule cron.Schedule
+	running  sync.Mutex
+	// startedAt is the start of the current run in unix nanoseconds, 0 while the job is idle.
+	startedAt atomic.Int64
+}
+
+// JobStatus is the state of a job.
+type JobStatus struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Running  bool   `json:"running"`
+	// StartedAt is the start of the current run, nil while the job is idle.
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	// Next is the time of the next scheduled run.
+	Next time.Time `json:"next"`
+}
+
+type triggeredKey struct{}
+
+// Triggered reports whether the run of ctx was started by Trigger instead of the schedule.
+func Triggered(ctx context.Context) bool {
+	triggered, _ := ctx.Value(triggeredKey{}).(bool)
+	return triggered
+}
+
+// NewScheduler creates a Scheduler whose jobs are run with contexts derived from ctx.
+func NewScheduler(ctx context.Context, logger log.Logger) *Scheduler {
+	return &Scheduler{
+		ctx:    ctx,
+		cron:   cron.New(),
+		logger: logger,
+	}
+}
+
+// ParseSchedule parses a cron expression or a plain interval.
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval %q must be positive", spec)
+		}
+		return cron.Every(interval), nil
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	return schedule, nil
+}
+
+// Add registers a job. It must be called before Start.
+func (s *Scheduler) Add(job Job) error {
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("error scheduling job %s: %w", job.Name, err)
+	}
+	j := &scheduledJob{Job: job, schedule: schedule}
+	s.jobs = append(s.jobs, j)
+	s.cron.Schedule(schedule, cron.FuncJob(func() { s.run(j, true) }))
+	return nil
+}
+
+// Start starts the scheduler. If runNow is set every job is additionally run once immediately.
+func (s *Scheduler) Start(runNow bool) {
+	s.cron.Start()
+	if !runNow {
+		return
+	}
+	for _, j := range s.jobs {
+		go s.run(j, false)
+	}
+}
+
+// Stop stops scheduling new runs and waits until all running jobs returned.
+func (s *Scheduler) Stop() {
+	<-s.cron.Stop().Done()
+	for _, j := range s.jobs {
+		j.running.Lock()
+		j.running.Unlock()
+	}
+}
+
+// Pause skips the scheduled runs until Resume is called. Running jobs are not stopped and jobs can
+// still be triggered.
+func (s *Scheduler) Pause() {
+	s.paused.Store(true)
+}
+
+// Resume resumes the scheduled runs.
+func (s *Scheduler) Resume() {
+	s.paused.Store(false)
+}
+
+// Paused reports whether the scheduled runs are paused.
+func (s *Scheduler) Paused() bool {
+	return s.paused.Load()
+}
+
+// Trigger runs the job name now without jitter, unless it is already running.
+func (s *Scheduler) Trigger(name string) error {
+	for _, j := range s.jobs {
+		if j.Name != name {
+			continue
+		}
+		if !j.running.TryLock() {
+			return fmt.Errorf("not triggering %s: %w", name, ErrJobRunning)
+		}
+		go func(j *scheduledJob) {
+			defer j.running.Unlock()
+			s.execute(context.WithValue(s.ctx, triggeredKey{}, true), j, false)
+		}(j)
+		return nil
+	}
+	return fmt.Errorf("not triggering %s: %w", name, ErrUnknownJob)
+}
+
+// Jobs returns the state of all jobs.
+func (s *Scheduler) Jobs() []JobStatus {
+	now := time.Now()
+	jobs := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		status := JobStatus{Name: j.Name, Schedule: j.Schedule, Next: j.schedule.Next(now)}
+		if startedAt := j.startedAt.Load(); startedAt != 0 {
+			t := time.Unix(0, startedAt).UTC()
+			status.Running, status.StartedAt = true, &t
+		}
+		jobs = append(jobs, status)
+	}
+	return jobs
+}
+
+// run runs j unless it is running. Scheduled runs are delayed by the jitter and skipped while paused.
+func (s *Scheduler) run(j *scheduledJob, scheduled bool) {
+	if scheduled && s.Paused() {
+		s.logger.Log("info", fmt.Sprintf("Skipping %s: scheduler is paused", j.Name))
+		return
+	}
+	if !j.running.TryLock() {
+		s.logger.Log("info", fmt.Sprintf("Skipping %s: previous run still in progress", j.Name))
+		return
+	}
+	defer j.running.Unlock()
+	s.execute(s.ctx, j, scheduled)
+}
+
+// execute runs j with a context derived from ctx, the caller holds the running lock of j.
+func (s *Scheduler) execute(ctx context.Context, j *scheduledJob, withJitter bool) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	if withJitter && j.Jitter > 0 {
+		delay := time.Duration(rand.Int63n(int64(j.Jitter)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	cancel := context.CancelFunc(func() {})
+	if j.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+	}
+	defer cancel()
+
+	start := time.Now()
+	j.startedAt.Store(start.UnixNano())
+	defer j.startedAt.Store(0)
+	if err := j.Run(ctx); err != nil {
+		s.logger.Log("error", fmt.Errorf("job %s failed after %s: %w", j.Name, time.Since(start), err))
+		return
+	}
+	s.logger.Log("info", fmt.Sprintf("Job %s finished in %s, next run at %s", j.Name, time.Since(start), j.schedule.Next(time.Now()).Format(time.RFC3339)))
+}
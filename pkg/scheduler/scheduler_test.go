@@ -0,0 +1,172 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2021, 1, 1, 10, 7, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		spec    string
+		want    time.Time
+		wantErr bool
+	}{
+		{"interval", "10m", now.Add(10 * time.Minute), false},
+		{"every", "@every 1h", now.Add(time.Hour), false},
+		{"cron", "*/15 * * * *", time.Date(2021, 1, 1, 10, 15, 0, 0, time.UTC), false},
+		{"descriptor", "@daily", time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), false},
+		{"negativeInterval", "-5m", time.Time{}, true},
+		{"invalid", "every now and then", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSchedule(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if next := got.Next(now); !next.Equal(tt.want) {
+				t.Errorf("ParseSchedule().Next() = %v, want %v", next, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduler_runSkipsOverlappingRuns(t *testing.T) {
+	var runs int32
+	release := make(chan struct{})
+	s := NewScheduler(context.Background(), log.NewNopLogger())
+	err := s.Add(Job{
+		Name:     "test",
+		Schedule: "@yearly",
+		Run: func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			<-release
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	j := s.jobs[0]
+	done := make(chan struct{})
+	go func() {
+		s.run(j, false)
+		close(done)
+	}()
+	for atomic.LoadInt32(&runs) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s.run(j, false)
+	close(release)
+	<-done
+	if got := atomic.LoadInt32(&runs); got != 1 {
+		t.Errorf("runs = %v, want 1", got)
+	}
+}
+
+func TestScheduler_runTimeout(t *testing.T) {
+	var gotErr error
+	s := NewScheduler(context.Background(), log.NewNopLogger())
+	s.Add(Job{
+		Name:     "test",
+		Schedule: "@yearly",
+		Timeout:  10 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			gotErr = ctx.Err()
+			return gotErr
+		},
+	})
+	s.run(s.jobs[0], false)
+	if gotErr != context.DeadlineExceeded {
+		t.Errorf("run() ctx error = %v, want %v", gotErr, context.DeadlineExceeded)
+	}
+}
+
+func TestScheduler_Pause(t *testing.T) {
+	var runs int32
+	s := NewScheduler(context.Background(), log.NewNopLogger())
+	s.Add(Job{
+		Name:     "test",
+		Schedule: "@yearly",
+		Run: func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		},
+	})
+	tests := []struct {
+		name      string
+		pause     bool
+		scheduled bool
+		wantRuns  int32
+	}{
+		{"scheduled", false, true, 1},
+		{"paused", true, true, 1},
+		{"paused on start", true, false, 2},
+		{"resumed", false, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.pause {
+				s.Pause()
+			} else {
+				s.Resume()
+			}
+			if s.Paused() != tt.pause {
+				t.Errorf("Paused() = %v, want %v", s.Paused(), tt.pause)
+			}
+			s.run(s.jobs[0], tt.scheduled)
+			if got := atomic.LoadInt32(&runs); got != tt.wantRuns {
+				t.Errorf("runs = %v, want %v", got, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestScheduler_Trigger(t *testing.T) {
+	started := make(chan bool)
+	release := make(chan struct{})
+	s := NewScheduler(context.Background(), log.NewNopLogger())
+	s.Add(Job{
+		Name:     "test",
+		Schedule: "@yearly",
+		Run: func(ctx context.Context) error {
+			started <- Triggered(ctx)
+			<-release
+			return nil
+		},
+	})
+	s.Pause()
+	if err := s.Trigger("test"); err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+	if triggered := <-started; !triggered {
+		t.Errorf("Triggered() = false in a triggered run")
+	}
+	jobs := s.Jobs()
+	if len(jobs) != 1 || !jobs[0].Running || jobs[0].StartedAt == nil || jobs[0].Schedule != "@yearly" {
+		t.Errorf("Jobs() = %+v", jobs)
+	}
+	if err := s.Trigger("test"); !errors.Is(err, ErrJobRunning) {
+		t.Errorf("Trigger() of a running job error = %v, want %v", err, ErrJobRunning)
+	}
+	if err := s.Trigger("other"); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("Trigger() of an unknown job error = %v, want %v", err, ErrUnknownJob)
+	}
+	close(release)
+	s.Stop()
+	if jobs := s.Jobs(); jobs[0].Running {
+		t.Errorf("Jobs() after the run = %+v", jobs)
+	}
+}
@@ -0,0 +1,153 @@
+// Package openaq ingests the cities, countries and latest measurements of the OpenAQ api.
+package openaq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/source"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"github.com/nhe23/aq-dbsync/pkg/tracing"
+)
+
+// ID identifies OpenAQ on the stored documents.
+const ID = storage.DefaultProvider
+
+// Datasets are the datasets of OpenAQ in the order they are synced.
+var Datasets = []source.Dataset{
+	{Name: "cities", Kind: source.Cities},
+	{Name: "countries", Kind: source.Countries},
+	{Name: "measurements", Kind: source.Locations, Incremental: true},
+}
+
+// paths are the api endpoints of the datasets.
+var paths = map[string]string{
+	"cities":       "/v1/cities",
+	"countries":    "/v1/countries",
+	"measurements": "/v1/latest",
+}
+
+// Options configures the OpenAQ source.
+type Options struct {
+	// Endpoint is the base url of the api.
+	Endpoint string
+	Client   *http.Client
+	PageSize int
+	// IncrementalParam is the query parameter that limits a request to the records updated since a time.
+	IncrementalParam string
+}
+
+type openAQ struct {
+	opts Options
+}
+
+// NewSource creates the OpenAQ source.
+func NewSource(opts Options) source.Source {
+	return openAQ{opts}
+}
+
+func (o openAQ) ID() string {
+	return ID
+}
+
+func (o openAQ) Datasets() []source.Dataset {
+	return Datasets
+}
+
+func (o openAQ) PageSize() int {
+	return o.opts.PageSize
+}
+
+func (o openAQ) URL(dataset string, since time.Time) string {
+	dataURL := fmt.Sprintf("%s%s?limit=%d&page=", o.opts.Endpoint, paths[dataset], o.opts.PageSize)
+	if since.IsZero() {
+		return dataURL
+	}
+	return withQueryParam(dataURL, o.opts.IncrementalParam, since.Format(time.RFC3339))
+}
+
+// withQueryParam adds a query parameter to a url that ends with the page parameter.
+func withQueryParam(dataURL string, key string, value string) string {
+	return fmt.Sprintf("%s%s=%s&page=", strings.TrimSuffix(dataURL, "page="), key, url.QueryEscape(value))
+}
+
+func (o openAQ) Fetch(ctx context.Context, url string) ([]interface{}, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to creating a request: %w", err)
+	}
+
+	resp, err := o.opts.Client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	_, span := tracing.Tracer().Start(ctx, "decode")
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	results, total, err := ParsePage(result)
+	tracing.End(span, err)
+	return results, total, err
+}
+
+// ParsePage returns the results and the total number of results of a decoded api page.
+func ParsePage(result map[string]interface{}) ([]interface{}, int, error) {
+	results, exists := result["results"]
+	if !exists {
+		return nil, 0, fmt.Errorf("no results object present")
+	}
+
+	resultsArray, ok := results.([]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("could not pars results array")
+	}
+
+	meta, exists := result["meta"]
+	if !exists {
+		return resultsArray, 0, fmt.Errorf("no meta data available")
+	}
+	metaMap, ok := meta.(map[string]interface{})
+	if !ok {
+		return resultsArray, 0, fmt.Errorf("could not pars meta object")
+	}
+	total, exists := metaMap["found"].(float64)
+	if !exists {
+		return resultsArray, 0, fmt.Errorf("No valid meta data found")
+	}
+	return resultsArray, int(total), nil
+}
+
+// Documents converts the results, which have the shape of the documents, by their JSON.
+func (o openAQ) Documents(dataset string, results []interface{}) ([]storage.Document, error) {
+	docs := make([]storage.Document, 0, len(results))
+	for _, result := range results {
+		resultJSON, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("error converting json: %w", err)
+		}
+		switch dataset {
+		case "cities":
+			var city storage.City
+			json.Unmarshal(resultJSON, &city)
+			docs = append(docs, city)
+		case "countries":
+			var country storage.Country
+			json.Unmarshal(resultJSON, &country)
+			docs = append(docs, country)
+		case "measurements":
+			var location storage.Location
+			json.Unmarshal(resultJSON, &location)
+			docs = append(docs, location)
+		default:
+			return nil, fmt.Errorf("unknown dataset %q", dataset)
+		}
+	}
+	return docs, nil
+}
@@ -0,0 +1,119 @@
+package openaq
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+const latestPage = `{
+	"meta": {"name": "openaq-api", "page": 1, "limit": 100, "found": 12046},
+	"results": [
+		{
+			"location": "1-r khoroolol",
+			"city": "Ulaanbaatar",
+			"country": "MN",
+			"distance": 6563510.382773982,
+			"measurements": [
+				{"parameter": "pm10", "value": 199, "lastUpdated": "2019-03-13T21:45:00.000Z", "unit": "µg/m³", "sourceName": "Agaar.mn"},
+				{"parameter": "pm25", "value": 217, "lastUpdated": "2019-03-13T21:45:00.000Z", "unit": "µg/m³", "sourceName": "Agaar.mn"}
+			],
+			"coordinates": {"latitude": 47.91798, "longitude": 106.84806}
+		}
+	]
+}`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/latest":
+			w.Write([]byte(latestPage))
+		case "/v1/cities":
+			w.Write([]byte(`{"meta": {"found": 2}, "results": [{"name": "Ulaanbaatar", "country": "MN", "count": 10, "locations": 2}]}`))
+		default:
+			w.Write([]byte(`{"error": "not found"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_openAQ_Fetch(t *testing.T) {
+	server := newTestServer(t)
+	src := NewSource(Options{Endpoint: server.URL, Client: server.Client(), PageSize: 100})
+	tests := []struct {
+		name        string
+		url         string
+		wantResults int
+		wantTotal   int
+		wantErr     bool
+	}{
+		{"standard", src.URL("measurements", time.Time{}) + "1", 1, 12046, false},
+		{"no results", server.URL + "/v1/unknown", 0, 0, true},
+		{"errorURL", "nonexistanturl.test", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := src.Fetch(context.Background(), tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openAQ.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.wantResults || total != tt.wantTotal {
+				t.Errorf("openAQ.Fetch() = %d results, %d, want %d, %d", len(got), total, tt.wantResults, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func Test_openAQ_URL(t *testing.T) {
+	src := NewSource(Options{Endpoint: "https://api.openaq.org", PageSize: 10, IncrementalParam: "date_from"})
+	tests := []struct {
+		dataset string
+		since   time.Time
+		want    string
+	}{
+		{"cities", time.Time{}, "https://api.openaq.org/v1/cities?limit=10&page="},
+		{"measurements", time.Time{}, "https://api.openaq.org/v1/latest?limit=10&page="},
+		{"measurements", time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC), "https://api.openaq.org/v1/latest?limit=10&date_from=2021-01-02T12%3A00%3A00Z&page="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dataset, func(t *testing.T) {
+			if got := src.URL(tt.dataset, tt.since); got != tt.want {
+				t.Errorf("openAQ.URL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_openAQ_Documents(t *testing.T) {
+	server := newTestServer(t)
+	src := NewSource(Options{Endpoint: server.URL, Client: server.Client(), PageSize: 100})
+	for _, dataset := range []string{"measurements", "cities"} {
+		results, _, err := src.Fetch(context.Background(), src.URL(dataset, time.Time{})+"1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs, err := src.Documents(dataset, results)
+		if err != nil || len(docs) != 1 {
+			t.Fatalf("openAQ.Documents(%s) = %v, %v", dataset, docs, err)
+		}
+		switch doc := docs[0].(type) {
+		case storage.Location:
+			if doc.Location != "1-r khoroolol" || len(doc.Measurements) != 2 || doc.Measurements[1].Value != 217 || doc.Coordinates.Latitude != 47.91798 {
+				t.Errorf("location = %+v", doc)
+			}
+		case storage.City:
+			if doc.Name != "Ulaanbaatar" || doc.Count != 10 {
+				t.Errorf("city = %+v", doc)
+			}
+		}
+	}
+	if _, err := src.Documents("unknown", []interface{}{map[string]interface{}{}}); err == nil {
+		t.Errorf("openAQ.Documents() of an unknown dataset succeeded")
+	}
+}
@@ -0,0 +1,266 @@
+// Package sensorcommunity ingests the latest readings of the low-cost sensors of Sensor.Community
+// (formerly luftdaten.info) from its JSON data feed.
+package sensorcommunity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/source"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"github.com/nhe23/aq-dbsync/pkg/tracing"
+)
+
+// ID identifies Sensor.Community on the stored documents.
+const ID = "sensor.community"
+
+// Dataset is the name of the dataset of the latest readings.
+const Dataset = "sensorcommunity"
+
+// Datasets are the datasets of Sensor.Community. The source sets their StaleAfter.
+var Datasets = []source.Dataset{
+	{Name: Dataset, Kind: source.Locations},
+}
+
+// feedPath is the path of the feed of the readings of the last 5 minutes averaged per sensor.
+const feedPath = "/static/v2/data.json"
+
+// timestampLayout is the layout of the UTC timestamps of the feed.
+const timestampLayout = "2006-01-02 15:04:05"
+
+// reading maps a value type of a sensor to a measurement.
+type reading struct {
+	parameter string
+	unit      string
+	// divisor converts the value to the unit.
+	divisor float64
+}
+
+var pmReadings = map[string]reading{
+	"P0": {"pm1", "µg/m³", 1},
+	"P1": {"pm10", "µg/m³", 1},
+	"P2": {"pm25", "µg/m³", 1},
+}
+
+var bme280Readings = map[string]reading{
+	"temperature": {"temperature", "°C", 1},
+	"humidity":    {"humidity", "%", 1},
+	"pressure":    {"pressure", "hPa", 100},
+}
+
+// readings returns the readings of a sensor type, nil if its readings are not ingested.
+func readings(sensorType string) map[string]reading {
+	switch {
+	case sensorType == "SDS011" || strings.HasPrefix(sensorType, "PMS"):
+		return pmReadings
+	case sensorType == "BME280":
+		return bme280Readings
+	default:
+		return nil
+	}
+}
+
+// record is an entry of the feed, the reading of a sensor.
+type record struct {
+	Timestamp string `json:"timestamp"`
+	Location  struct {
+		Latitude  string `json:"latitude"`
+		Longitude string `json:"longitude"`
+		Country   string `json:"country"`
+		Indoor    int    `json:"indoor"`
+	} `json:"location"`
+	Sensor struct {
+		ID         int `json:"id"`
+		SensorType struct {
+			Name string `json:"name"`
+		} `json:"sensor_type"`
+	} `json:"sensor"`
+	Values []struct {
+		Value     string `json:"value"`
+		ValueType string `json:"value_type"`
+	} `json:"sensordatavalues"`
+}
+
+// Options configures the Sensor.Community source.
+type Options struct {
+	// Endpoint is the base url of the data feed.
+	Endpoint string
+	Client   *http.Client
+	// StaleAfter is the time after which sensors that are no longer in the feed are deleted, as the
+	// feed only holds the sensors that sent readings in the last minutes.
+	StaleAfter time.Duration
+}
+
+type sensorCommunity struct {
+	opts Options
+}
+
+// NewSource creates the Sensor.Community source.
+func NewSource(opts Options) source.Source {
+	return sensorCommunity{opts}
+}
+
+func (s sensorCommunity) ID() string {
+	return ID
+}
+
+func (s sensorCommunity) Datasets() []source.Dataset {
+	datasets := make([]source.Dataset, 0, len(Datasets))
+	for _, d := range Datasets {
+		d.StaleAfter = s.opts.StaleAfter
+		datasets = append(datasets, d)
+	}
+	return datasets
+}
+
+// PageSize returns 0, the feed is a single page.
+func (s sensorCommunity) PageSize() int {
+	return 0
+}
+
+// URL returns the url of the feed. The feed ignores the page parameter and since as it only
+// holds the latest readings.
+func (s sensorCommunity) URL(dataset string, since time.Time) string {
+	return s.opts.Endpoint + feedPath + "?page="
+}
+
+func (s sensorCommunity) Fetch(ctx context.Context, url string) ([]interface{}, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to creating a request: %w", err)
+	}
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	_, span := tracing.Tracer().Start(ctx, "decode")
+	var results []interface{}
+	err = json.NewDecoder(resp.Body).Decode(&results)
+	if err != nil {
+		err = fmt.Errorf("error decoding feed: %w", err)
+	}
+	tracing.End(span, err)
+	return results, len(results), err
+}
+
+// Documents maps the readings of SDS011, PMS and BME280 sensors outdoors to a location per sensor,
+// keyed by LocationName, with the newest reading of each value. Other sensors and readings that
+// cannot be parsed are skipped.
+func (s sensorCommunity) Documents(dataset string, results []interface{}) ([]storage.Document, error) {
+	if dataset != Dataset {
+		return nil, fmt.Errorf("unknown dataset %q", dataset)
+	}
+	var order []int
+	locations := make(map[int]*storage.Location)
+	for _, result := range results {
+		resultJSON, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("error converting json: %w", err)
+		}
+		var r record
+		if err := json.Unmarshal(resultJSON, &r); err != nil {
+			continue
+		}
+		measurements := r.measurements()
+		if r.Location.Indoor != 0 || len(measurements) == 0 {
+			continue
+		}
+		location, ok := locations[r.Sensor.ID]
+		if !ok {
+			coordinates, err := r.coordinates()
+			if err != nil {
+				continue
+			}
+			location = &storage.Location{
+				Location:    LocationName(r.Sensor.ID),
+				Country:     r.Location.Country,
+				Coordinates: coordinates,
+				LowCost:     true,
+			}
+			locations[r.Sensor.ID] = location
+			order = append(order, r.Sensor.ID)
+		}
+		location.Measurements = newest(location.Measurements, measurements)
+	}
+	docs := make([]storage.Document, 0, len(order))
+	for _, id := range order {
+		docs = append(docs, *locations[id])
+	}
+	return docs, nil
+}
+
+// LocationName returns the name of the location of a sensor.
+func LocationName(sensorID int) string {
+	return fmt.Sprintf("%s-%d", ID, sensorID)
+}
+
+// measurements returns the measurements of the readings of r that are ingested.
+func (r record) measurements() []storage.Measurement {
+	timestamp, err := time.Parse(timestampLayout, r.Timestamp)
+	if err != nil {
+		return nil
+	}
+	known := readings(r.Sensor.SensorType.Name)
+	var measurements []storage.Measurement
+	for _, v := range r.Values {
+		reading, ok := known[v.ValueType]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(v.Value, 64)
+		if err != nil {
+			continue
+		}
+		measurements = append(measurements, storage.Measurement{
+			Parameter:   reading.parameter,
+			Value:       value / reading.divisor,
+			LastUpdated: timestamp,
+			Unit:        reading.unit,
+		})
+	}
+	return measurements
+}
+
+func (r record) coordinates() (storage.Coordinates, error) {
+	latitude, err := strconv.ParseFloat(r.Location.Latitude, 64)
+	if err != nil {
+		return storage.Coordinates{}, err
+	}
+	longitude, err := strconv.ParseFloat(r.Location.Longitude, 64)
+	if err != nil {
+		return storage.Coordinates{}, err
+	}
+	return storage.Coordinates{Latitude: latitude, Longitude: longitude}, nil
+}
+
+// newest merges measurements into current keeping the newest measurement of each parameter.
+func newest(current []storage.Measurement, measurements []storage.Measurement) []storage.Measurement {
+	for _, m := range measurements {
+		found := false
+		for i, c := range current {
+			if c.Parameter == m.Parameter {
+				if m.LastUpdated.After(c.LastUpdated) {
+					current[i] = m
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			current = append(current, m)
+		}
+	}
+	return current
+}
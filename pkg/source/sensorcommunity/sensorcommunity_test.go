@@ -0,0 +1,139 @@
+package sensorcommunity
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// newTestServer serves the feed recorded in testdata.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != feedPath {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, "testdata/data.json")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+type recordingRepository struct {
+	storage.Repository
+	docs []storage.Document
+}
+
+func (r *recordingRepository) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	r.docs = append(r.docs, docs...)
+	return storage.UpsertResult{Upserted: int64(len(docs))}, nil
+}
+
+func Test_sensorCommunity_Fetch(t *testing.T) {
+	server := newTestServer(t)
+	tests := []struct {
+		name      string
+		endpoint  string
+		wantTotal int
+		wantErr   bool
+	}{
+		{"standard", server.URL, 6, false},
+		{"not found", server.URL + "/missing", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := NewSource(Options{Endpoint: tt.endpoint, Client: server.Client()})
+			results, total, err := src.Fetch(context.Background(), src.URL(Dataset, time.Time{})+"1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sensorCommunity.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(results) != tt.wantTotal || total != tt.wantTotal {
+				t.Errorf("sensorCommunity.Fetch() = %d results, %d, want %d", len(results), total, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func Test_sensorCommunity_Documents(t *testing.T) {
+	server := newTestServer(t)
+	src := NewSource(Options{Endpoint: server.URL, Client: server.Client()})
+	results, _, err := src.Fetch(context.Background(), src.URL(Dataset, time.Time{})+"1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := src.Documents(Dataset, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(minute int, second int) time.Time {
+		return time.Date(2021, 3, 1, 11, minute, second, 0, time.UTC)
+	}
+	berlin := storage.Coordinates{Latitude: 52.516, Longitude: 13.377}
+	// The pressure in Pa is divided at run time, a constant expression would be exact.
+	pressure := 101283.44
+	want := []storage.Document{
+		// The older reading of the sensor later in the feed is ignored.
+		storage.Location{Location: "sensor.community-16581", Country: "DE", Coordinates: berlin, LowCost: true, Measurements: []storage.Measurement{
+			{Parameter: "pm10", Value: 18.43, LastUpdated: at(55, 12), Unit: "µg/m³"},
+			{Parameter: "pm25", Value: 9.27, LastUpdated: at(55, 12), Unit: "µg/m³"},
+		}},
+		storage.Location{Location: "sensor.community-16582", Country: "DE", Coordinates: berlin, LowCost: true, Measurements: []storage.Measurement{
+			{Parameter: "temperature", Value: 7.61, LastUpdated: at(55, 14), Unit: "°C"},
+			{Parameter: "humidity", Value: 81.05, LastUpdated: at(55, 14), Unit: "%"},
+			{Parameter: "pressure", Value: pressure / 100, LastUpdated: at(55, 14), Unit: "hPa"},
+		}},
+		storage.Location{Location: "sensor.community-22914", Country: "DE", Coordinates: storage.Coordinates{Latitude: 48.137, Longitude: 11.575}, LowCost: true,
+			Measurements: []storage.Measurement{
+				{Parameter: "pm1", Value: 4, LastUpdated: at(56, 40), Unit: "µg/m³"},
+				{Parameter: "pm10", Value: 41, LastUpdated: at(56, 40), Unit: "µg/m³"},
+				{Parameter: "pm25", Value: 33, LastUpdated: at(56, 40), Unit: "µg/m³"},
+			}},
+	}
+	if !reflect.DeepEqual(docs, want) {
+		t.Errorf("sensorCommunity.Documents() = %+v, want %+v", docs, want)
+	}
+	if _, err := src.Documents("measurements", results); err == nil {
+		t.Errorf("sensorCommunity.Documents() of an unknown dataset succeeded")
+	}
+}
+
+func Test_sensorCommunity_Datasets(t *testing.T) {
+	datasets := NewSource(Options{StaleAfter: time.Hour}).Datasets()
+	if len(datasets) != 1 || datasets[0].Name != Dataset || datasets[0].StaleAfter != time.Hour {
+		t.Errorf("sensorCommunity.Datasets() = %+v", datasets)
+	}
+	if Datasets[0].StaleAfter != 0 {
+		t.Errorf("sensorCommunity.Datasets() modified Datasets")
+	}
+}
+
+func Test_sensorCommunity_process(t *testing.T) {
+	server := newTestServer(t)
+	src := NewSource(Options{Endpoint: server.URL, Client: server.Client()})
+	processor := dataprocessor.NewDataProcessor(src.PageSize())
+	repo := &recordingRepository{}
+	err := processor.ProcessData(context.Background(), Dataset, src.URL(Dataset, time.Time{}), repo, processor.Process(src, Dataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.docs) != 3 {
+		t.Fatalf("upserted %d documents, want 3", len(repo.docs))
+	}
+	location := repo.docs[2].(storage.Location)
+	if location.Provider != ID || !location.LowCost || location.SyncedAt.IsZero() {
+		t.Errorf("location = %+v", location)
+	}
+	for _, m := range location.Measurements {
+		want := dataprocessor.QualityIndex(m.Parameter, m.Unit, m.Value)
+		if m.QualityIndex != want || m.Parameter == "pm25" && m.QualityIndex != 4 {
+			t.Errorf("quality index of %s = %d, want %d", m.Parameter, m.QualityIndex, want)
+		}
+	}
+}
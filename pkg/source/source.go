@@ -0,0 +1,59 @@
+// Package source defines the providers the datasets are ingested from. Every provider lists its
+// datasets, fetches their pages and maps the results to the canonical documents, which are
+// stored together with those of the other providers and tagged with its ID.
+package source
+
+import (
+	"context"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// Kinds of the documents of a dataset.
+const (
+	Cities    = "cities"
+	Countries = "countries"
+	Locations = "locations"
+)
+
+// Dataset is a dataset of a source.
+type Dataset struct {
+	// Name identifies the dataset in the config, the sync state, the leases and the runs, so it
+	// is unique across all sources.
+	Name string
+	// Kind is the kind of the documents of the dataset.
+	Kind string
+	// Incremental datasets can be limited to the records updated since a time.
+	Incremental bool
+	// StaleAfter is set for datasets that always hold all current records, like feeds of the
+	// latest readings. After every sync the documents of the source that were not synced for
+	// StaleAfter are deleted.
+	StaleAfter time.Duration
+}
+
+// Source is a provider of datasets.
+type Source interface {
+	// ID identifies the provider on the stored documents.
+	ID() string
+	// Datasets returns the datasets in the order they are synced.
+	Datasets() []Dataset
+	// PageSize returns the number of results per page, or 0 if all results are on a single page.
+	PageSize() int
+	// URL returns the url of the pages of dataset, to which the page number is appended. Unless
+	// since is zero it only requests the records updated since then.
+	URL(dataset string, since time.Time) string
+	// Fetch returns the results of the page at url and the total number of results of its dataset.
+	Fetch(ctx context.Context, url string) ([]interface{}, int, error)
+	// Documents maps results of dataset to documents of its kind.
+	Documents(dataset string, results []interface{}) ([]storage.Document, error)
+}
+
+// Names returns the names of datasets.
+func Names(datasets []Dataset) []string {
+	names := make([]string, 0, len(datasets))
+	for _, d := range datasets {
+		names = append(names, d.Name)
+	}
+	return names
+}
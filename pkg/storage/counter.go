@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// Counter is a Repository that adds up the results of the upserts into a repository.
+type Counter struct {
+	Repository
+	mu     sync.Mutex
+	result UpsertResult
+}
+
+// NewCounter creates a Counter of repo.
+func NewCounter(repo Repository) *Counter {
+	return &Counter{Repository: repo}
+}
+
+// Upsert upserts docs into the repository and counts the result.
+func (c *Counter) Upsert(ctx context.Context, docs []Document) (UpsertResult, error) {
+	result, err := c.Repository.Upsert(ctx, docs)
+	c.mu.Lock()
+	c.result.Add(result)
+	c.mu.Unlock()
+	return result, err
+}
+
+// Result returns the sum of the results of all upserts.
+func (c *Counter) Result() UpsertResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := c.result
+	result.Rejected = append([]Rejection(nil), c.result.Rejected...)
+	return result
+}
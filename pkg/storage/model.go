@@ -0,0 +1,109 @@
+package storage
+
+import "time"
+
+// SchemaVersion is the version of the documents written by this version of the service. Stored
+// documents of older versions are upgraded by migrations.
+const SchemaVersion = 3
+
+// DefaultProvider is the provider of the documents stored before the provider was recorded, which
+// were all synced from OpenAQ.
+const DefaultProvider = "openaq"
+
+// City is a city with air quality locations.
+type City struct {
+	Name      string    `bson:"name" json:"name"`
+	Country   string    `bson:"country" json:"country"`
+	Count     int       `bson:"count" json:"count"`
+	Locations int       `bson:"locations" json:"locations"`
+	SyncedAt  time.Time `bson:"syncedAt" json:"syncedAt"`
+	// Provider identifies the source the document was synced from.
+	Provider string `bson:"provider" json:"provider"`
+	// SchemaVersion is the SchemaVersion the document was written with.
+	SchemaVersion int `bson:"schemaVersion" json:"schemaVersion"`
+	// AirQuality is computed from the locations of the city, it is not part of the api.
+	AirQuality *AirQuality `bson:"airQuality,omitempty" json:"airQuality,omitempty"`
+}
+
+// Key returns the name of the city.
+func (c City) Key() string {
+	return c.Name
+}
+
+// Country is a country with air quality locations.
+type Country struct {
+	Code      string    `bson:"code" json:"code"`
+	Name      string    `bson:"name" json:"name"`
+	Count     int       `bson:"count" json:"count"`
+	Cities    int       `bson:"cities" json:"cities"`
+	Locations int       `bson:"locations" json:"locations"`
+	SyncedAt  time.Time `bson:"syncedAt" json:"syncedAt"`
+	// Provider identifies the source the document was synced from.
+	Provider string `bson:"provider" json:"provider"`
+	// SchemaVersion is the SchemaVersion the document was written with.
+	SchemaVersion int `bson:"schemaVersion" json:"schemaVersion"`
+	// AirQuality is computed from the locations of the country, it is not part of the api.
+	AirQuality *AirQuality `bson:"airQuality,omitempty" json:"airQuality,omitempty"`
+}
+
+// Key returns the country code.
+func (c Country) Key() string {
+	return c.Code
+}
+
+// Location is a measuring station with its latest measurements.
+type Location struct {
+	Location     string        `bson:"location" json:"location"`
+	City         string        `bson:"city" json:"city"`
+	Country      string        `bson:"country" json:"country"`
+	Measurements []Measurement `bson:"measurements" json:"measurements"`
+	Coordinates  Coordinates   `bson:"coordinates" json:"coordinates"`
+	SyncedAt     time.Time     `bson:"syncedAt" json:"syncedAt"`
+	// Provider identifies the source the document was synced from.
+	Provider string `bson:"provider" json:"provider"`
+	// LowCost marks the locations of low-cost sensors, which are less accurate than reference stations.
+	LowCost bool `bson:"lowCost,omitempty" json:"lowCost,omitempty"`
+	// SchemaVersion is the SchemaVersion the document was written with.
+	SchemaVersion int `bson:"schemaVersion" json:"schemaVersion"`
+}
+
+// Key returns the name of the location.
+func (l Location) Key() string {
+	return l.Location
+}
+
+// Measurement is the latest value of a parameter at a location.
+type Measurement struct {
+	Parameter    string    `bson:"parameter" json:"parameter"`
+	Value        float64   `bson:"value" json:"value"`
+	LastUpdated  time.Time `bson:"lastUpdated" json:"lastUpdated"`
+	Unit         string    `bson:"unit" json:"unit"`
+	QualityIndex int       `bson:"qualityIndex" json:"qualityIndex"`
+}
+
+// Coordinates of a location.
+type Coordinates struct {
+	Latitude  float64 `bson:"latitude" json:"latitude"`
+	Longitude float64 `bson:"longitude" json:"longitude"`
+}
+
+// AirQuality summarises the current measurements of the locations of a city or country.
+type AirQuality struct {
+	// QualityIndex is the worst quality index of the medians of the parameters.
+	QualityIndex int `bson:"qualityIndex" json:"qualityIndex"`
+	// Stations is the number of locations with current measurements.
+	Stations   int                `bson:"stations" json:"stations"`
+	Parameters []ParameterQuality `bson:"parameters" json:"parameters"`
+	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ParameterQuality summarises the current measurements of a parameter.
+type ParameterQuality struct {
+	Parameter string  `bson:"parameter" json:"parameter"`
+	Unit      string  `bson:"unit" json:"unit"`
+	Median    float64 `bson:"median" json:"median"`
+	Max       float64 `bson:"max" json:"max"`
+	// QualityIndex is the quality index of the median.
+	QualityIndex int `bson:"qualityIndex" json:"qualityIndex"`
+	Stations     int `bson:"stations" json:"stations"`
+}
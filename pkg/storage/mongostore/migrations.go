@@ -0,0 +1,49 @@
+package mongostore
+
+import (
+	"context"
+
+	"github.com/nhe23/aq-dbsync/pkg/migrate"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migrations returns the migrations of the collections of the datasets.
+func Migrations(cities Collection, countries Collection, locations Collection) []migrate.Migration {
+	cols := []Collection{cities, countries, locations}
+	return []migrate.Migration{
+		{Version: 1, Description: "add the schema version to cities, countries and locations", Up: func(ctx context.Context) error {
+			// Unversioned documents have the shape of version 1.
+			return updateAll(ctx, cols, bson.M{"schemaVersion": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"schemaVersion": 1}})
+		}},
+		{Version: 2, Description: "add the provider to cities, countries and locations", Up: func(ctx context.Context) error {
+			return updateAll(ctx, cols, bson.M{"provider": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"provider": storage.DefaultProvider, "schemaVersion": 2}})
+		}},
+		{Version: 3, Description: "store measurement values as floating point numbers", Up: func(ctx context.Context) error {
+			values := mongo.NewUpdateManyModel()
+			values.SetFilter(bson.M{"schemaVersion": bson.M{"$lt": 3}, "measurements": bson.M{"$type": "array"}})
+			values.SetUpdate(bson.A{bson.M{"$set": bson.M{"measurements": bson.M{"$map": bson.M{
+				"input": "$measurements",
+				"in":    bson.M{"$mergeObjects": bson.A{"$$this", bson.M{"value": bson.M{"$toDouble": "$$this.value"}}}},
+			}}}}})
+			if _, err := locations.BulkWrite(ctx, []mongo.WriteModel{values}); err != nil {
+				return err
+			}
+			return updateAll(ctx, cols, bson.M{"schemaVersion": bson.M{"$lt": 3}}, bson.M{"$set": bson.M{"schemaVersion": 3}})
+		}},
+	}
+}
+
+func updateAll(ctx context.Context, cols []Collection, filter bson.M, update bson.M) error {
+	for _, col := range cols {
+		operation := mongo.NewUpdateManyModel()
+		operation.SetFilter(filter)
+		operation.SetUpdate(update)
+		if _, err := col.BulkWrite(ctx, []mongo.WriteModel{operation}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
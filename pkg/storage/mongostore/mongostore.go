@@ -0,0 +1,249 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"github.com/nhe23/aq-dbsync/pkg/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Collection consists of the used functions of a mongo collection.
+type Collection interface {
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+}
+
+// fields names the document fields a repository filters on. Empty fields are not filtered.
+type fields struct {
+	key     string
+	country string
+	city    string
+	// parameter and coordinates are the fields of the measured parameters and the coordinates of locations.
+	parameter   string
+	coordinates string
+	// merge updates the fields of stored documents instead of replacing them, so fields that are
+	// not synced, like the air quality, are kept.
+	merge bool
+}
+
+type repository struct {
+	col    Collection
+	fields fields
+	decode func(raw bson.Raw) (storage.Document, error)
+}
+
+// NewCities creates a repository of cities keyed by name.
+func NewCities(col Collection) storage.Repository {
+	return airQualityRepository{repository{col, fields{key: "name", country: "country", city: "name", merge: true}, func(raw bson.Raw) (storage.Document, error) {
+		var city storage.City
+		err := bson.Unmarshal(raw, &city)
+		return city, err
+	}}}
+}
+
+// NewCountries creates a repository of countries keyed by code.
+func NewCountries(col Collection) storage.Repository {
+	return airQualityRepository{repository{col, fields{key: "code", country: "code", merge: true}, func(raw bson.Raw) (storage.Document, error) {
+		var country storage.Country
+		err := bson.Unmarshal(raw, &country)
+		return country, err
+	}}}
+}
+
+// NewLocations creates a repository of locations with their latest measurements keyed by location.
+func NewLocations(col Collection) storage.Repository {
+	return repository{col, fields{key: "location", country: "country", city: "city", parameter: "measurements.parameter", coordinates: "coordinates"}, func(raw bson.Raw) (storage.Document, error) {
+		var location storage.Location
+		err := bson.Unmarshal(raw, &location)
+		return location, err
+	}}
+}
+
+func (r repository) Upsert(ctx context.Context, docs []storage.Document) (upserted storage.UpsertResult, err error) {
+	if len(docs) == 0 {
+		return storage.UpsertResult{}, nil
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "BulkWrite", trace.WithAttributes(
+		attribute.String("db.system", "mongodb"),
+		attribute.Int("documents", len(docs)),
+	))
+	defer func() {
+		span.SetAttributes(
+			attribute.Int64("matched", upserted.Matched),
+			attribute.Int64("modified", upserted.Modified),
+			attribute.Int64("upserted", upserted.Upserted),
+			attribute.Int("rejected", len(upserted.Rejected)),
+		)
+		tracing.End(span, err)
+	}()
+	operations := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		operations = append(operations, r.upsertModel(doc))
+	}
+	// Unordered so that documents rejected by validation do not stop the remaining ones.
+	bulkOption := options.BulkWriteOptions{}
+	bulkOption.SetOrdered(false)
+	result, err := r.col.BulkWrite(ctx, operations, &bulkOption)
+	rejected, err := rejections(docs, err)
+	if err != nil {
+		return storage.UpsertResult{}, err
+	}
+	if result == nil {
+		return storage.UpsertResult{Rejected: rejected}, nil
+	}
+	return storage.UpsertResult{
+		Matched:  result.MatchedCount,
+		Modified: result.ModifiedCount,
+		Upserted: result.UpsertedCount,
+		Rejected: rejected,
+	}, nil
+}
+
+// documentValidationFailure is the error code of writes rejected by a validator.
+const documentValidationFailure = 121
+
+// rejections returns the documents rejected by validation if they are the only errors of a bulk
+// write, otherwise it returns err.
+func rejections(docs []storage.Document, err error) ([]storage.Rejection, error) {
+	var bwe mongo.BulkWriteException
+	if err == nil || !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+		return nil, err
+	}
+	rejected := make([]storage.Rejection, 0, len(bwe.WriteErrors))
+	for _, we := range bwe.WriteErrors {
+		if we.Code != documentValidationFailure || we.Index < 0 || we.Index >= len(docs) {
+			return nil, err
+		}
+		rejected = append(rejected, storage.Rejection{Key: docs[we.Index].Key(), Reason: we.Message})
+	}
+	return rejected, nil
+}
+
+// upsertModel returns the write model that inserts or replaces doc.
+func (r repository) upsertModel(doc storage.Document) mongo.WriteModel {
+	if r.fields.merge {
+		operation := mongo.NewUpdateOneModel()
+		operation.SetFilter(bson.M{r.fields.key: doc.Key()})
+		operation.SetUpdate(bson.M{"$set": doc})
+		operation.SetUpsert(true)
+		return operation
+	}
+	operation := mongo.NewReplaceOneModel()
+	operation.SetFilter(bson.M{r.fields.key: doc.Key()})
+	operation.SetReplacement(doc)
+	operation.SetUpsert(true)
+	return operation
+}
+
+func (r repository) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	opts := options.Find().SetSort(bson.D{{Key: r.fields.key, Value: 1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	if filter.Skip > 0 {
+		opts.SetSkip(int64(filter.Skip))
+	}
+	cursor, err := r.col.Find(ctx, r.filter(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []storage.Document
+	for cursor.Next(ctx) {
+		doc, err := r.decode(cursor.Current)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding document: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, cursor.Err()
+}
+
+// filter returns the mongo filter of filter.
+func (r repository) filter(filter storage.Filter) bson.M {
+	f := bson.M{}
+	if len(filter.Keys) > 0 || filter.After != "" {
+		key := bson.M{}
+		if len(filter.Keys) > 0 {
+			key["$in"] = filter.Keys
+		}
+		if filter.After != "" {
+			key["$gt"] = filter.After
+		}
+		f[r.fields.key] = key
+	}
+	if filter.Country != "" && r.fields.country != "" {
+		f[r.fields.country] = filter.Country
+	}
+	if filter.City != "" && r.fields.city != "" {
+		f[r.fields.city] = filter.City
+	}
+	if filter.Parameter != "" && r.fields.parameter != "" {
+		f[r.fields.parameter] = filter.Parameter
+	}
+	if b := filter.Bounds; b != nil && r.fields.coordinates != "" {
+		latitude, longitude := r.fields.coordinates+".latitude", r.fields.coordinates+".longitude"
+		f[latitude] = bson.M{"$gte": b.South, "$lte": b.North}
+		if b.West <= b.East {
+			f[longitude] = bson.M{"$gte": b.West, "$lte": b.East}
+		} else {
+			f["$or"] = bson.A{bson.M{longitude: bson.M{"$gte": b.West}}, bson.M{longitude: bson.M{"$lte": b.East}}}
+		}
+	}
+	return f
+}
+
+func (r repository) DeleteStale(ctx context.Context, provider string, before time.Time) (int64, error) {
+	deleteOperation := mongo.NewDeleteManyModel()
+	deleteOperation.SetFilter(bson.M{"provider": provider, "$or": bson.A{
+		bson.M{"syncedAt": bson.M{"$lt": before}},
+		bson.M{"syncedAt": bson.M{"$exists": false}},
+	}})
+	result, err := r.col.BulkWrite(ctx, []mongo.WriteModel{deleteOperation})
+	if err != nil {
+		return 0, fmt.Errorf("error deleting stale documents: %w", err)
+	}
+	if result == nil {
+		return 0, nil
+	}
+	return result.DeletedCount, nil
+}
+
+// airQualityRepository is a repository of cities or countries that stores their air quality.
+type airQualityRepository struct {
+	repository
+}
+
+func (r airQualityRepository) SetAirQuality(ctx context.Context, airQuality map[string]storage.AirQuality) error {
+	operations := make([]mongo.WriteModel, 0, len(airQuality)+1)
+	keys := make([]string, 0, len(airQuality))
+	for key, aq := range airQuality {
+		operation := mongo.NewUpdateOneModel()
+		operation.SetFilter(bson.M{r.fields.key: key})
+		operation.SetUpdate(bson.M{"$set": bson.M{"airQuality": aq}})
+		operations = append(operations, operation)
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// Areas without current locations lose their air quality.
+	clearOperation := mongo.NewUpdateManyModel()
+	clearOperation.SetFilter(bson.M{r.fields.key: bson.M{"$nin": keys}, "airQuality": bson.M{"$exists": true}})
+	clearOperation.SetUpdate(bson.M{"$unset": bson.M{"airQuality": ""}})
+	operations = append(operations, clearOperation)
+	bulkOption := options.BulkWriteOptions{}
+	bulkOption.SetOrdered(false)
+	if _, err := r.col.BulkWrite(ctx, operations, &bulkOption); err != nil {
+		return fmt.Errorf("error setting air quality: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type collection struct {
+	models []mongo.WriteModel
+	err    error
+	// result is returned together with err.
+	result *mongo.BulkWriteResult
+}
+
+func (c *collection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	if c.err != nil {
+		return c.result, c.err
+	}
+	c.models = append(c.models, models...)
+	return &mongo.BulkWriteResult{MatchedCount: 1, ModifiedCount: 1, UpsertedCount: int64(len(models) - 1), DeletedCount: 3}, nil
+}
+
+func (c *collection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func Test_repository_Upsert(t *testing.T) {
+	docs := []storage.Document{storage.City{Name: "Berlin", Country: "DE"}, storage.City{Name: "Paris", Country: "FR"}}
+	tests := []struct {
+		name    string
+		col     *collection
+		docs    []storage.Document
+		want    storage.UpsertResult
+		wantErr bool
+	}{
+		{"standard", &collection{}, docs, storage.UpsertResult{Matched: 1, Modified: 1, Upserted: 1}, false},
+		{"empty", &collection{}, nil, storage.UpsertResult{}, false},
+		{"error", &collection{err: fmt.Errorf("VERY BAD ERROR")}, docs, storage.UpsertResult{}, true},
+		{"rejected", &collection{
+			err:    mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Code: 121, Message: "Document failed validation"}}}},
+			result: &mongo.BulkWriteResult{UpsertedCount: 1},
+		}, docs, storage.UpsertResult{Upserted: 1, Rejected: []storage.Rejection{{Key: "Paris", Reason: "Document failed validation"}}}, false},
+		{"rejected and failed", &collection{
+			err: mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+				{WriteError: mongo.WriteError{Index: 0, Code: 121, Message: "Document failed validation"}},
+				{WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}},
+			}},
+			result: &mongo.BulkWriteResult{},
+		}, docs, storage.UpsertResult{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewCities(tt.col).Upsert(context.Background(), tt.docs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("repository.Upsert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("repository.Upsert() = %v, want %v", got, tt.want)
+			}
+			if tt.col.err != nil {
+				return
+			}
+			if len(tt.col.models) != len(tt.docs) {
+				t.Fatalf("repository.Upsert() wrote %d models, want %d", len(tt.col.models), len(tt.docs))
+			}
+			// Cities are merged to keep their air quality.
+			for i, model := range tt.col.models {
+				update := model.(*mongo.UpdateOneModel)
+				if !reflect.DeepEqual(update.Filter, bson.M{"name": tt.docs[i].Key()}) || !reflect.DeepEqual(update.Update, bson.M{"$set": tt.docs[i]}) || !*update.Upsert {
+					t.Errorf("unexpected model %+v", update)
+				}
+			}
+		})
+	}
+}
+
+func Test_repository_Upsert_replace(t *testing.T) {
+	col := &collection{}
+	doc := storage.Location{Location: "a", Country: "DE"}
+	if _, err := NewLocations(col).Upsert(context.Background(), []storage.Document{doc}); err != nil {
+		t.Fatal(err)
+	}
+	replace := col.models[0].(*mongo.ReplaceOneModel)
+	if !reflect.DeepEqual(replace.Filter, bson.M{"location": "a"}) || !reflect.DeepEqual(replace.Replacement, doc) || !*replace.Upsert {
+		t.Errorf("unexpected model %+v", replace)
+	}
+}
+
+func Test_airQualityRepository_SetAirQuality(t *testing.T) {
+	col := &collection{}
+	aq := storage.AirQuality{QualityIndex: 2, Stations: 3}
+	if err := NewCountries(col).(storage.AirQualityWriter).SetAirQuality(context.Background(), map[string]storage.AirQuality{"DE": aq}); err != nil {
+		t.Fatal(err)
+	}
+	update := col.models[0].(*mongo.UpdateOneModel)
+	if !reflect.DeepEqual(update.Filter, bson.M{"code": "DE"}) || !reflect.DeepEqual(update.Update, bson.M{"$set": bson.M{"airQuality": aq}}) || update.Upsert != nil {
+		t.Errorf("unexpected model %+v", update)
+	}
+	clear := col.models[1].(*mongo.UpdateManyModel)
+	if !reflect.DeepEqual(clear.Filter, bson.M{"code": bson.M{"$nin": []string{"DE"}}, "airQuality": bson.M{"$exists": true}}) ||
+		!reflect.DeepEqual(clear.Update, bson.M{"$unset": bson.M{"airQuality": ""}}) {
+		t.Errorf("unexpected model %+v", clear)
+	}
+}
+
+func Test_repository_filter(t *testing.T) {
+	tests := []struct {
+		name   string
+		repo   storage.Repository
+		filter storage.Filter
+		want   bson.M
+	}{
+		{"all", NewLocations(nil), storage.Filter{}, bson.M{}},
+		{"locations", NewLocations(nil), storage.Filter{Keys: []string{"a"}, Country: "DE", City: "Berlin"},
+			bson.M{"location": bson.M{"$in": []string{"a"}}, "country": "DE", "city": "Berlin"}},
+		{"countries ignore city", NewCountries(nil), storage.Filter{Country: "DE", City: "Berlin"}, bson.M{"code": "DE"}},
+		{"after", NewCities(nil), storage.Filter{After: "Berlin"}, bson.M{"name": bson.M{"$gt": "Berlin"}}},
+		{"parameter and bounds", NewLocations(nil), storage.Filter{Parameter: "pm25", Bounds: &storage.Bounds{South: 52, West: 13, North: 53, East: 14}},
+			bson.M{"measurements.parameter": "pm25", "coordinates.latitude": bson.M{"$gte": 52.0, "$lte": 53.0}, "coordinates.longitude": bson.M{"$gte": 13.0, "$lte": 14.0}}},
+		{"bounds across antimeridian", NewLocations(nil), storage.Filter{Bounds: &storage.Bounds{South: -20, West: 170, North: -10, East: -170}},
+			bson.M{"coordinates.latitude": bson.M{"$gte": -20.0, "$lte": -10.0}, "$or": bson.A{
+				bson.M{"coordinates.longitude": bson.M{"$gte": 170.0}}, bson.M{"coordinates.longitude": bson.M{"$lte": -170.0}}}}},
+		{"cities ignore parameter", NewCities(nil), storage.Filter{Parameter: "pm25"}, bson.M{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, ok := tt.repo.(repository)
+			if !ok {
+				repo = tt.repo.(airQualityRepository).repository
+			}
+			if got := repo.filter(tt.filter); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("repository.filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_repository_DeleteStale(t *testing.T) {
+	tests := []struct {
+		name    string
+		col     *collection
+		want    int64
+		wantErr bool
+	}{
+		{"standard", &collection{}, 3, false},
+		{"error", &collection{err: fmt.Errorf("VERY BAD ERROR")}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewLocations(tt.col).DeleteStale(context.Background(), storage.DefaultProvider, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("repository.DeleteStale() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("repository.DeleteStale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Migrations(t *testing.T) {
+	cities, countries, locations := &collection{}, &collection{}, &collection{}
+	migrations := Migrations(cities, countries, locations)
+	if len(migrations) == 0 || migrations[0].Version != 1 {
+		t.Fatalf("Migrations() = %+v", migrations)
+	}
+	if err := migrations[0].Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, col := range []*collection{cities, countries, locations} {
+		update := col.models[0].(*mongo.UpdateManyModel)
+		if !reflect.DeepEqual(update.Filter, bson.M{"schemaVersion": bson.M{"$exists": false}}) {
+			t.Errorf("unexpected model %+v", update)
+		}
+	}
+	if len(migrations) < 2 || migrations[1].Version != 2 {
+		t.Fatalf("Migrations() = %+v", migrations)
+	}
+	if err := migrations[1].Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, col := range []*collection{cities, countries, locations} {
+		update := col.models[1].(*mongo.UpdateManyModel)
+		if !reflect.DeepEqual(update.Filter, bson.M{"provider": bson.M{"$exists": false}}) {
+			t.Errorf("unexpected model %+v", update)
+		}
+	}
+	if len(migrations) < 3 || migrations[2].Version != 3 {
+		t.Fatalf("Migrations() = %+v", migrations)
+	}
+	if err := migrations[2].Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	values := locations.models[2].(*mongo.UpdateManyModel)
+	if !reflect.DeepEqual(values.Filter, bson.M{"schemaVersion": bson.M{"$lt": 3}, "measurements": bson.M{"$type": "array"}}) {
+		t.Errorf("unexpected model %+v", values)
+	}
+	for _, update := range []mongo.WriteModel{cities.models[2], countries.models[2], locations.models[3]} {
+		if !reflect.DeepEqual(update.(*mongo.UpdateManyModel).Update, bson.M{"$set": bson.M{"schemaVersion": 3}}) {
+			t.Errorf("unexpected model %+v", update)
+		}
+	}
+}
+
+func Test_JSONSchema(t *testing.T) {
+	type inner struct {
+		Value float64 `bson:"value"`
+	}
+	type doc struct {
+		Name     string    `bson:"name"`
+		Count    int       `bson:"count"`
+		At       time.Time `bson:"at"`
+		Items    []inner   `bson:"items"`
+		Optional *inner    `bson:"optional,omitempty"`
+		Note     string    `bson:"note,omitempty"`
+		Ignored  string    `bson:"-"`
+		private  string
+	}
+	number := bson.M{"bsonType": bson.A{"double", "int", "long"}}
+	innerSchema := bson.M{"bsonType": "object", "properties": bson.M{"value": number}, "required": bson.A{"value"}}
+	tests := []struct {
+		name string
+		doc  interface{}
+		want bson.M
+	}{
+		{"string", "", bson.M{"bsonType": "string"}},
+		{"time", time.Time{}, bson.M{"bsonType": "date"}},
+		{"struct", doc{}, bson.M{
+			"bsonType": "object",
+			"properties": bson.M{
+				"name":     bson.M{"bsonType": "string"},
+				"count":    bson.M{"bsonType": bson.A{"int", "long"}},
+				"at":       bson.M{"bsonType": "date"},
+				"items":    bson.M{"bsonType": "array", "items": innerSchema},
+				"optional": innerSchema,
+				"note":     bson.M{"bsonType": "string"},
+			},
+			"required": bson.A{"name", "count", "at", "items"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JSONSchema(tt.doc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("JSONSchema() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ValidatorCommands(t *testing.T) {
+	create, update := ValidatorCommands("cities", storage.City{}, ValidationModerate, ValidationWarn)
+	if create[0] != (bson.E{Key: "create", Value: "cities"}) || update[0] != (bson.E{Key: "collMod", Value: "cities"}) {
+		t.Fatalf("ValidatorCommands() = %v, %v", create[0], update[0])
+	}
+	if !reflect.DeepEqual(create[1:], update[1:]) {
+		t.Errorf("ValidatorCommands() options differ: %v, %v", create[1:], update[1:])
+	}
+	want := bson.D{
+		{Key: "validator", Value: bson.M{"$jsonSchema": JSONSchema(storage.City{})}},
+		{Key: "validationLevel", Value: ValidationModerate},
+		{Key: "validationAction", Value: ValidationWarn},
+	}
+	if !reflect.DeepEqual(create[1:], want) {
+		t.Errorf("ValidatorCommands() = %v, want %v", create[1:], want)
+	}
+}
@@ -0,0 +1,122 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Validation levels and actions of mongo collection validators.
+const (
+	ValidationOff      = "off"
+	ValidationStrict   = "strict"
+	ValidationModerate = "moderate"
+	ValidationError    = "error"
+	ValidationWarn     = "warn"
+)
+
+// namespaceExists is the error code of creating a collection that already exists.
+const namespaceExists = 48
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// JSONSchema returns the $jsonSchema of the documents of type doc, derived from its bson tags.
+// Fields tagged omitempty and pointers are optional, all other fields are required.
+func JSONSchema(doc interface{}) bson.M {
+	return schema(reflect.TypeOf(doc))
+}
+
+func schema(t reflect.Type) bson.M {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return bson.M{"bsonType": "date"}
+	case t.Kind() == reflect.String:
+		return bson.M{"bsonType": "string"}
+	case t.Kind() == reflect.Bool:
+		return bson.M{"bsonType": "bool"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return bson.M{"bsonType": bson.A{"int", "long"}}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		// Whole numbers may be stored as integers by other writers.
+		return bson.M{"bsonType": bson.A{"double", "int", "long"}}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return bson.M{"bsonType": "array", "items": schema(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		properties := bson.M{}
+		required := bson.A{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, optional := bsonField(field)
+			if name == "" {
+				continue
+			}
+			properties[name] = schema(field.Type)
+			if !optional && field.Type.Kind() != reflect.Ptr {
+				required = append(required, name)
+			}
+		}
+		s := bson.M{"bsonType": "object", "properties": properties}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	default:
+		return bson.M{}
+	}
+}
+
+// bsonField returns the bson name of field and whether it is omitted when empty. The name is
+// empty for unexported and ignored fields.
+func bsonField(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag, ok := field.Tag.Lookup("bson")
+	if tag == "-" {
+		return "", false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if !ok || name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+// ValidatorCommands returns the commands creating the collection col with the validator of doc and,
+// if it already exists, updating its validator.
+func ValidatorCommands(col string, doc interface{}, level string, action string) (create bson.D, update bson.D) {
+	options := bson.D{
+		{Key: "validator", Value: bson.M{"$jsonSchema": JSONSchema(doc)}},
+		{Key: "validationLevel", Value: level},
+		{Key: "validationAction", Value: action},
+	}
+	create = append(bson.D{{Key: "create", Value: col}}, options...)
+	update = append(bson.D{{Key: "collMod", Value: col}}, options...)
+	return create, update
+}
+
+// ApplyValidator creates the collection col of db with the validator of doc or updates the validator
+// of the existing collection.
+func ApplyValidator(ctx context.Context, db *mongo.Database, col string, doc interface{}, level string, action string) error {
+	create, update := ValidatorCommands(col, doc, level, action)
+	err := db.RunCommand(ctx, create).Err()
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == namespaceExists {
+		err = db.RunCommand(ctx, update).Err()
+	}
+	return err
+}
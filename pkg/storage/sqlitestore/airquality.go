@@ -0,0 +1,92 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// Levels of the air quality rows.
+const (
+	levelCity    = "city"
+	levelCountry = "country"
+)
+
+// airQualityRepository is a repository of cities or countries that stores their air quality in
+// separate tables, so upserts of the synced fields keep it.
+type airQualityRepository struct {
+	repository
+	level string
+}
+
+func (r airQualityRepository) SetAirQuality(ctx context.Context, airQuality map[string]storage.AirQuality) error {
+	return r.db.inTx(ctx, func(tx *sql.Tx) error {
+		// Deleting the rows also deletes their parameters, and areas missing from airQuality
+		// lose their air quality.
+		if _, err := tx.ExecContext(ctx, "DELETE FROM air_quality WHERE level = ?", r.level); err != nil {
+			return err
+		}
+		for name, aq := range airQuality {
+			_, err := tx.ExecContext(ctx, `INSERT INTO air_quality (level, name, quality_index, stations, updated_at) VALUES (?, ?, ?, ?, ?)`,
+				r.level, name, aq.QualityIndex, aq.Stations, formatTime(aq.UpdatedAt))
+			if err != nil {
+				return fmt.Errorf("error setting air quality of %s: %w", name, err)
+			}
+			for _, p := range aq.Parameters {
+				_, err := tx.ExecContext(ctx, `INSERT INTO air_quality_parameters (level, name, parameter, unit, median, max, quality_index, stations)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+					r.level, name, p.Parameter, p.Unit, p.Median, p.Max, p.QualityIndex, p.Stations)
+				if err != nil {
+					return fmt.Errorf("error setting air quality of %s: %w", name, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// findAirQuality returns the air quality of all cities or countries by name.
+func findAirQuality(ctx context.Context, db *sql.DB, level string) (map[string]*storage.AirQuality, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, quality_index, stations, updated_at FROM air_quality WHERE level = ?", level)
+	if err != nil {
+		return nil, err
+	}
+	airQuality := make(map[string]*storage.AirQuality)
+	for rows.Next() {
+		var name, updatedAt string
+		aq := storage.AirQuality{Parameters: []storage.ParameterQuality{}}
+		if err := rows.Scan(&name, &aq.QualityIndex, &aq.Stations, &updatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if aq.UpdatedAt, err = parseTime(updatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		airQuality[name] = &aq
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT name, parameter, unit, median, max, quality_index, stations FROM air_quality_parameters
+		WHERE level = ? ORDER BY name, parameter, unit`, level)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var p storage.ParameterQuality
+		if err := rows.Scan(&name, &p.Parameter, &p.Unit, &p.Median, &p.Max, &p.QualityIndex, &p.Stations); err != nil {
+			return nil, err
+		}
+		if aq, ok := airQuality[name]; ok {
+			aq.Parameters = append(aq.Parameters, p)
+		}
+	}
+	return airQuality, rows.Err()
+}
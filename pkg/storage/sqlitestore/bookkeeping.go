@@ -0,0 +1,119 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"github.com/nhe23/aq-dbsync/pkg/lease"
+	"github.com/nhe23/aq-dbsync/pkg/syncstate"
+)
+
+type stateStore struct {
+	db *sql.DB
+}
+
+// SyncState returns the store of the sync state of the datasets.
+func (d *DB) SyncState() syncstate.Store {
+	return stateStore{d.db}
+}
+
+// Load returns the state of dataset or an empty state if the dataset was never synced.
+func (s stateStore) Load(ctx context.Context, dataset string) (syncstate.State, error) {
+	state := syncstate.State{Dataset: dataset}
+	var watermark, lastSuccess, lastFullSync string
+	err := s.db.QueryRowContext(ctx, "SELECT watermark, last_success, last_full_sync FROM sync_state WHERE dataset = ?", dataset).
+		Scan(&watermark, &lastSuccess, &lastFullSync)
+	if err == sql.ErrNoRows {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	for _, t := range []struct {
+		value string
+		into  *time.Time
+	}{{watermark, &state.Watermark}, {lastSuccess, &state.LastSuccess}, {lastFullSync, &state.LastFullSync}} {
+		if *t.into, err = parseTime(t.value); err != nil {
+			return state, err
+		}
+	}
+	return state, nil
+}
+
+func (s stateStore) Save(ctx context.Context, state syncstate.State) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sync_state (dataset, watermark, last_success, last_full_sync) VALUES (?, ?, ?, ?)
+		ON CONFLICT (dataset) DO UPDATE SET watermark = excluded.watermark, last_success = excluded.last_success,
+		last_full_sync = excluded.last_full_sync`,
+		state.Dataset, formatTime(state.Watermark), formatTime(state.LastSuccess), formatTime(state.LastFullSync))
+	return err
+}
+
+type checkpointStore struct {
+	db *sql.DB
+}
+
+// Checkpoints returns the store of the checkpoints of unfinished syncs.
+func (d *DB) Checkpoints() dataprocessor.CheckpointStore {
+	return checkpointStore{d.db}
+}
+
+func (c checkpointStore) LoadCheckpoint(ctx context.Context, dataset string) (*dataprocessor.Checkpoint, error) {
+	cp := dataprocessor.Checkpoint{Dataset: dataset}
+	var startedAt, updatedAt string
+	err := c.db.QueryRowContext(ctx, "SELECT run_id, url, page, total, started_at, updated_at FROM sync_checkpoints WHERE dataset = ?", dataset).
+		Scan(&cp.RunID, &cp.URL, &cp.Page, &cp.Total, &startedAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cp.StartedAt, err = parseTime(startedAt); err != nil {
+		return nil, err
+	}
+	if cp.UpdatedAt, err = parseTime(updatedAt); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (c checkpointStore) SaveCheckpoint(ctx context.Context, cp dataprocessor.Checkpoint) error {
+	_, err := c.db.ExecContext(ctx, `INSERT INTO sync_checkpoints (dataset, run_id, url, page, total, started_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (dataset) DO UPDATE SET run_id = excluded.run_id, url = excluded.url,
+		page = excluded.page, total = excluded.total, started_at = excluded.started_at, updated_at = excluded.updated_at`,
+		cp.Dataset, cp.RunID, cp.URL, cp.Page, cp.Total, formatTime(cp.StartedAt), formatTime(cp.UpdatedAt))
+	return err
+}
+
+func (c checkpointStore) DeleteCheckpoint(ctx context.Context, dataset string) error {
+	_, err := c.db.ExecContext(ctx, "DELETE FROM sync_checkpoints WHERE dataset = ?", dataset)
+	return err
+}
+
+type leaseStore struct {
+	db *sql.DB
+}
+
+// Leases returns the lease store shared by all processes using the database file.
+func (d *DB) Leases() lease.Store {
+	return leaseStore{d.db}
+}
+
+func (l leaseStore) TryAcquire(ctx context.Context, name string, owner string, expiresAt time.Time) (bool, error) {
+	result, err := l.db.ExecContext(ctx, `INSERT INTO sync_leases (name, owner, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE sync_leases.owner = excluded.owner OR sync_leases.expires_at < ?`,
+		name, owner, formatTime(expiresAt), formatTime(time.Now()))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (l leaseStore) Release(ctx context.Context, name string, owner string) error {
+	_, err := l.db.ExecContext(ctx, "DELETE FROM sync_leases WHERE name = ? AND owner = ?", name, owner)
+	return err
+}
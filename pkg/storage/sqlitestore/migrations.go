@@ -0,0 +1,157 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/nhe23/aq-dbsync/pkg/migrate"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// Migrations returns the migrations of the tables of the datasets.
+func (d *DB) Migrations() []migrate.Migration {
+	return []migrate.Migration{
+		{Version: 1, Description: "add the schema version to cities, countries and locations", Up: d.addSchemaVersion},
+		{Version: 2, Description: "add the provider to cities, countries and locations", Up: d.addProvider},
+		{Version: 3, Description: "add the low-cost flag to locations", Up: d.addLowCost},
+		{Version: 4, Description: "store measurement values as floating point numbers", Up: d.realValues},
+	}
+}
+
+// addSchemaVersion adds the schema_version column to databases created before it existed and
+// marks the unversioned rows, which have the shape of version 1.
+func (d *DB) addSchemaVersion(ctx context.Context) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		for _, table := range []string{"cities", "countries", "locations"} {
+			exists, err := hasColumn(ctx, tx, table, "schema_version")
+			if err != nil {
+				return err
+			}
+			if !exists {
+				if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 0", table)); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET schema_version = 1 WHERE schema_version = 0", table)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addProvider adds the provider column to databases created before it existed and marks the
+// rows without a provider, which were synced from the default provider.
+func (d *DB) addProvider(ctx context.Context) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		for _, table := range []string{"cities", "countries", "locations"} {
+			exists, err := hasColumn(ctx, tx, table, "provider")
+			if err != nil {
+				return err
+			}
+			if !exists {
+				if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN provider TEXT NOT NULL DEFAULT ''", table)); err != nil {
+					return err
+				}
+			}
+			_, err = tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET provider = ?, schema_version = 2 WHERE provider = ''", table),
+				storage.DefaultProvider)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addLowCost adds the low_cost column to databases created before it existed. The locations
+// synced before are no low-cost sensors.
+func (d *DB) addLowCost(ctx context.Context) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		exists, err := hasColumn(ctx, tx, "locations", "low_cost")
+		if err != nil || exists {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "ALTER TABLE locations ADD COLUMN low_cost INTEGER NOT NULL DEFAULT 0")
+		return err
+	})
+}
+
+// realValues rebuilds the measurements table of databases created while values were integers,
+// since SQLite cannot change the type of a column, and marks the rows with version 3.
+func (d *DB) realValues(ctx context.Context) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		var columnType string
+		if err := tx.QueryRowContext(ctx, "SELECT type FROM pragma_table_info('measurements') WHERE name = 'value'").Scan(&columnType); err != nil {
+			return err
+		}
+		if columnType != "REAL" {
+			_, err := tx.ExecContext(ctx, `CREATE TABLE measurements_real (
+				location      TEXT NOT NULL REFERENCES locations (location) ON DELETE CASCADE,
+				parameter     TEXT NOT NULL,
+				value         REAL NOT NULL,
+				unit          TEXT NOT NULL,
+				last_updated  TEXT NOT NULL,
+				quality_index INTEGER NOT NULL,
+				PRIMARY KEY (location, parameter)
+			);
+			INSERT INTO measurements_real SELECT location, parameter, CAST(value AS REAL), unit, last_updated, quality_index FROM measurements;
+			DROP TABLE measurements;
+			ALTER TABLE measurements_real RENAME TO measurements`)
+			if err != nil {
+				return err
+			}
+		}
+		for _, table := range []string{"cities", "countries", "locations"} {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET schema_version = 3 WHERE schema_version < 3", table)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func hasColumn(ctx context.Context, tx *sql.Tx, table string, column string) (bool, error) {
+	var found int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&found)
+	return found > 0, err
+}
+
+type migrationStore struct {
+	db *sql.DB
+}
+
+// MigrationRecords returns the store of the applied migrations.
+func (d *DB) MigrationRecords() migrate.Store {
+	return migrationStore{d.db}
+}
+
+func (m migrationStore) Applied(ctx context.Context) ([]migrate.Record, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT version, description, applied_at, duration_seconds FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []migrate.Record
+	for rows.Next() {
+		var r migrate.Record
+		var appliedAt string
+		if err := rows.Scan(&r.Version, &r.Description, &appliedAt, &r.DurationSeconds); err != nil {
+			return nil, err
+		}
+		if r.AppliedAt, err = parseTime(appliedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func (m migrationStore) Save(ctx context.Context, r migrate.Record) error {
+	_, err := m.db.ExecContext(ctx, `INSERT INTO schema_migrations (version, description, applied_at, duration_seconds) VALUES (?, ?, ?, ?)
+		ON CONFLICT (version) DO UPDATE SET description = excluded.description, applied_at = excluded.applied_at,
+		duration_seconds = excluded.duration_seconds`,
+		r.Version, r.Description, formatTime(r.AppliedAt), r.DurationSeconds)
+	return err
+}
@@ -0,0 +1,340 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// table describes how a dataset is stored in its table.
+type table struct {
+	name    string
+	key     string
+	country string
+	city    string
+	// parameter is the condition of locations with a measurement of a parameter.
+	parameter string
+	// latitude and longitude are the columns of the coordinates.
+	latitude  string
+	longitude string
+	// upsert writes a document in a transaction.
+	upsert func(ctx context.Context, tx *sql.Tx, doc storage.Document) error
+	// find returns the documents of the rows selected by where.
+	find func(ctx context.Context, db *sql.DB, where string, args []interface{}) ([]storage.Document, error)
+}
+
+type repository struct {
+	db    *DB
+	table table
+}
+
+// Cities returns the repository of cities keyed by name.
+func (d *DB) Cities() storage.Repository {
+	return airQualityRepository{repository{d, table{name: "cities", key: "name", country: "country", city: "name", upsert: upsertCity, find: findCities}}, levelCity}
+}
+
+// Countries returns the repository of countries keyed by code.
+func (d *DB) Countries() storage.Repository {
+	return airQualityRepository{repository{d, table{name: "countries", key: "code", country: "code", upsert: upsertCountry, find: findCountries}}, levelCountry}
+}
+
+// Locations returns the repository of locations with their latest measurements keyed by location.
+func (d *DB) Locations() storage.Repository {
+	return repository{d, table{
+		name:      "locations",
+		key:       "location",
+		country:   "country",
+		city:      "city",
+		parameter: "EXISTS (SELECT 1 FROM measurements WHERE measurements.location = locations.location AND measurements.parameter = ?)",
+		latitude:  "latitude",
+		longitude: "longitude",
+		upsert:    upsertLocation,
+		find:      findLocations,
+	}}
+}
+
+// Upsert replaces the documents in a single transaction. Modified counts all replaced documents.
+func (r repository) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	var result storage.UpsertResult
+	err := r.db.inTx(ctx, func(tx *sql.Tx) error {
+		exists, err := tx.PrepareContext(ctx, fmt.Sprintf("SELECT 1 FROM %s WHERE %s = ?", r.table.name, r.table.key))
+		if err != nil {
+			return err
+		}
+		defer exists.Close()
+		for _, doc := range docs {
+			var found int
+			err := exists.QueryRowContext(ctx, doc.Key()).Scan(&found)
+			switch {
+			case err == sql.ErrNoRows:
+				result.Upserted++
+			case err != nil:
+				return err
+			default:
+				result.Matched++
+				result.Modified++
+			}
+			if err := r.table.upsert(ctx, tx, doc); err != nil {
+				return fmt.Errorf("error writing %s %s: %w", r.table.name, doc.Key(), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return storage.UpsertResult{}, err
+	}
+	return result, nil
+}
+
+func (r repository) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	var conditions []string
+	var args []interface{}
+	if len(filter.Keys) > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s IN (?%s)", r.table.key, strings.Repeat(", ?", len(filter.Keys)-1)))
+		for _, key := range filter.Keys {
+			args = append(args, key)
+		}
+	}
+	if filter.After != "" {
+		conditions = append(conditions, r.table.key+" > ?")
+		args = append(args, filter.After)
+	}
+	if filter.Country != "" && r.table.country != "" {
+		conditions = append(conditions, r.table.country+" = ?")
+		args = append(args, filter.Country)
+	}
+	if filter.City != "" && r.table.city != "" {
+		conditions = append(conditions, r.table.city+" = ?")
+		args = append(args, filter.City)
+	}
+	if filter.Parameter != "" && r.table.parameter != "" {
+		conditions = append(conditions, r.table.parameter)
+		args = append(args, filter.Parameter)
+	}
+	if b := filter.Bounds; b != nil && r.table.latitude != "" {
+		conditions = append(conditions, r.table.latitude+" BETWEEN ? AND ?")
+		args = append(args, b.South, b.North)
+		operator := "AND"
+		if b.West > b.East {
+			operator = "OR"
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s >= ? %s %s <= ?)", r.table.longitude, operator, r.table.longitude))
+		args = append(args, b.West, b.East)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	where += " ORDER BY " + r.table.key
+	if filter.Limit > 0 || filter.Skip > 0 {
+		limit := filter.Limit
+		if limit == 0 {
+			limit = -1
+		}
+		where += " LIMIT ? OFFSET ?"
+		args = append(args, limit, filter.Skip)
+	}
+	return r.table.find(ctx, r.db.db, where, args)
+}
+
+// DeleteStale deletes the stale rows, measurements of deleted locations are deleted with them.
+func (r repository) DeleteStale(ctx context.Context, provider string, before time.Time) (int64, error) {
+	result, err := r.db.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE provider = ? AND synced_at < ?", r.table.name),
+		provider, formatTime(before))
+	if err != nil {
+		return 0, fmt.Errorf("error deleting stale documents: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func upsertCity(ctx context.Context, tx *sql.Tx, doc storage.Document) error {
+	city, ok := doc.(storage.City)
+	if !ok {
+		return fmt.Errorf("unexpected document type %T", doc)
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO cities (name, country, count, locations, synced_at, schema_version, provider) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET country = excluded.country, count = excluded.count,
+		locations = excluded.locations, synced_at = excluded.synced_at, schema_version = excluded.schema_version,
+		provider = excluded.provider`,
+		city.Name, city.Country, city.Count, city.Locations, formatTime(city.SyncedAt), city.SchemaVersion, city.Provider)
+	return err
+}
+
+func findCities(ctx context.Context, db *sql.DB, where string, args []interface{}) ([]storage.Document, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, country, count, locations, synced_at, schema_version, provider FROM cities "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	var cities []storage.City
+	for rows.Next() {
+		var city storage.City
+		var syncedAt string
+		if err := rows.Scan(&city.Name, &city.Country, &city.Count, &city.Locations, &syncedAt, &city.SchemaVersion, &city.Provider); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if city.SyncedAt, err = parseTime(syncedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cities = append(cities, city)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	airQuality, err := findAirQuality(ctx, db, levelCity)
+	if err != nil {
+		return nil, err
+	}
+	var docs []storage.Document
+	for _, city := range cities {
+		city.AirQuality = airQuality[city.Name]
+		docs = append(docs, city)
+	}
+	return docs, nil
+}
+
+func upsertCountry(ctx context.Context, tx *sql.Tx, doc storage.Document) error {
+	country, ok := doc.(storage.Country)
+	if !ok {
+		return fmt.Errorf("unexpected document type %T", doc)
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO countries (code, name, count, cities, locations, synced_at, schema_version, provider)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (code) DO UPDATE SET name = excluded.name, count = excluded.count, cities = excluded.cities,
+		locations = excluded.locations, synced_at = excluded.synced_at, schema_version = excluded.schema_version,
+		provider = excluded.provider`,
+		country.Code, country.Name, country.Count, country.Cities, country.Locations, formatTime(country.SyncedAt), country.SchemaVersion,
+		country.Provider)
+	return err
+}
+
+func findCountries(ctx context.Context, db *sql.DB, where string, args []interface{}) ([]storage.Document, error) {
+	rows, err := db.QueryContext(ctx, "SELECT code, name, count, cities, locations, synced_at, schema_version, provider FROM countries "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	var countries []storage.Country
+	for rows.Next() {
+		var country storage.Country
+		var syncedAt string
+		if err := rows.Scan(&country.Code, &country.Name, &country.Count, &country.Cities, &country.Locations, &syncedAt, &country.SchemaVersion, &country.Provider); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if country.SyncedAt, err = parseTime(syncedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		countries = append(countries, country)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	airQuality, err := findAirQuality(ctx, db, levelCountry)
+	if err != nil {
+		return nil, err
+	}
+	var docs []storage.Document
+	for _, country := range countries {
+		country.AirQuality = airQuality[country.Code]
+		docs = append(docs, country)
+	}
+	return docs, nil
+}
+
+// upsertLocation replaces a location and all its measurements.
+func upsertLocation(ctx context.Context, tx *sql.Tx, doc storage.Document) error {
+	location, ok := doc.(storage.Location)
+	if !ok {
+		return fmt.Errorf("unexpected document type %T", doc)
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO locations (location, city, country, latitude, longitude, synced_at, schema_version, provider, low_cost)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (location) DO UPDATE SET city = excluded.city, country = excluded.country,
+		latitude = excluded.latitude, longitude = excluded.longitude, synced_at = excluded.synced_at,
+		schema_version = excluded.schema_version, provider = excluded.provider, low_cost = excluded.low_cost`,
+		location.Location, location.City, location.Country, location.Coordinates.Latitude, location.Coordinates.Longitude,
+		formatTime(location.SyncedAt), location.SchemaVersion, location.Provider, location.LowCost)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM measurements WHERE location = ?", location.Location); err != nil {
+		return err
+	}
+	for _, m := range location.Measurements {
+		_, err := tx.ExecContext(ctx, `INSERT INTO measurements (location, parameter, value, unit, last_updated, quality_index)
+			VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (location, parameter) DO UPDATE SET value = excluded.value,
+			unit = excluded.unit, last_updated = excluded.last_updated, quality_index = excluded.quality_index`,
+			location.Location, m.Parameter, m.Value, m.Unit, formatTime(m.LastUpdated), m.QualityIndex)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findLocations(ctx context.Context, db *sql.DB, where string, args []interface{}) ([]storage.Document, error) {
+	rows, err := db.QueryContext(ctx, "SELECT location, city, country, latitude, longitude, synced_at, schema_version, provider, low_cost FROM locations "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	var locations []storage.Location
+	for rows.Next() {
+		var location storage.Location
+		var syncedAt string
+		if err := rows.Scan(&location.Location, &location.City, &location.Country,
+			&location.Coordinates.Latitude, &location.Coordinates.Longitude, &syncedAt, &location.SchemaVersion, &location.Provider, &location.LowCost); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if location.SyncedAt, err = parseTime(syncedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		locations = append(locations, location)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var docs []storage.Document
+	for _, location := range locations {
+		if location.Measurements, err = findMeasurements(ctx, db, location.Location); err != nil {
+			return nil, err
+		}
+		docs = append(docs, location)
+	}
+	return docs, nil
+}
+
+func findMeasurements(ctx context.Context, db *sql.DB, location string) ([]storage.Measurement, error) {
+	rows, err := db.QueryContext(ctx, `SELECT parameter, value, unit, last_updated, quality_index FROM measurements
+		WHERE location = ? ORDER BY parameter`, location)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	measurements := []storage.Measurement{}
+	for rows.Next() {
+		var m storage.Measurement
+		var lastUpdated string
+		if err := rows.Scan(&m.Parameter, &m.Value, &m.Unit, &lastUpdated, &m.QualityIndex); err != nil {
+			return nil, err
+		}
+		if m.LastUpdated, err = parseTime(lastUpdated); err != nil {
+			return nil, err
+		}
+		measurements = append(measurements, m)
+	}
+	return measurements, rows.Err()
+}
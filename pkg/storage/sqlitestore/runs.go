@@ -0,0 +1,80 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/runs"
+)
+
+type runStore struct {
+	db        *sql.DB
+	retention time.Duration
+}
+
+// Runs returns the history of sync runs, which removes runs retention after they finished.
+func (d *DB) Runs(retention time.Duration) runs.Store {
+	return runStore{d.db, retention}
+}
+
+// Save saves run and removes expired runs.
+func (r runStore) Save(ctx context.Context, run runs.Run) error {
+	datasets, err := json.Marshal(run.Datasets)
+	if err != nil {
+		return fmt.Errorf("error encoding datasets of run: %w", err)
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT INTO sync_runs (id, trigger, status, started_at, finished_at, datasets)
+		VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO UPDATE SET trigger = excluded.trigger, status = excluded.status,
+		started_at = excluded.started_at, finished_at = excluded.finished_at, datasets = excluded.datasets`,
+		run.ID, run.Trigger, run.Status, formatTime(run.StartedAt), formatTime(run.FinishedAt), string(datasets))
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "DELETE FROM sync_runs WHERE finished_at < ?", formatTime(time.Now().Add(-r.retention)))
+	return err
+}
+
+func (r runStore) Recent(ctx context.Context, limit int) ([]runs.Run, error) {
+	return r.find(ctx, "SELECT id, trigger, status, started_at, finished_at, datasets FROM sync_runs ORDER BY finished_at DESC LIMIT ?", limit)
+}
+
+func (r runStore) LastSuccess(ctx context.Context, dataset string) (*runs.Run, error) {
+	found, err := r.find(ctx, `SELECT id, trigger, status, started_at, finished_at, datasets FROM sync_runs
+		WHERE EXISTS (SELECT 1 FROM json_each(sync_runs.datasets) d
+			WHERE json_extract(d.value, '$.name') = ? AND json_extract(d.value, '$.status') = ?)
+		ORDER BY finished_at DESC LIMIT 1`, dataset, runs.StatusOK)
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return &found[0], nil
+}
+
+func (r runStore) find(ctx context.Context, query string, args ...interface{}) ([]runs.Run, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found := make([]runs.Run, 0)
+	for rows.Next() {
+		var run runs.Run
+		var startedAt, finishedAt, datasets string
+		if err := rows.Scan(&run.ID, &run.Trigger, &run.Status, &startedAt, &finishedAt, &datasets); err != nil {
+			return nil, err
+		}
+		if run.StartedAt, err = parseTime(startedAt); err != nil {
+			return nil, err
+		}
+		if run.FinishedAt, err = parseTime(finishedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(datasets), &run.Datasets); err != nil {
+			return nil, fmt.Errorf("error decoding datasets of run %s: %w", run.ID, err)
+		}
+		found = append(found, run)
+	}
+	return found, rows.Err()
+}
@@ -0,0 +1,212 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"time"
+
+	// Registers the pure Go sqlite driver.
+	_ "modernc.org/sqlite"
+)
+
+// schema stores the datasets in normalised tables. Measurements are the latest values of the
+// parameters of a location and are replaced together with their location.
+const schema = `
+CREATE TABLE IF NOT EXISTS countries (
+	code      TEXT PRIMARY KEY,
+	name      TEXT NOT NULL,
+	count     INTEGER NOT NULL,
+	cities    INTEGER NOT NULL,
+	locations INTEGER NOT NULL,
+	synced_at TEXT NOT NULL,
+	schema_version INTEGER NOT NULL DEFAULT 0,
+	provider  TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS cities (
+	name      TEXT PRIMARY KEY,
+	country   TEXT NOT NULL,
+	count     INTEGER NOT NULL,
+	locations INTEGER NOT NULL,
+	synced_at TEXT NOT NULL,
+	schema_version INTEGER NOT NULL DEFAULT 0,
+	provider  TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS cities_country ON cities (country);
+CREATE TABLE IF NOT EXISTS locations (
+	location  TEXT PRIMARY KEY,
+	city      TEXT NOT NULL,
+	country   TEXT NOT NULL,
+	latitude  REAL NOT NULL,
+	longitude REAL NOT NULL,
+	synced_at TEXT NOT NULL,
+	schema_version INTEGER NOT NULL DEFAULT 0,
+	provider  TEXT NOT NULL DEFAULT '',
+	low_cost  INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS locations_country_city ON locations (country, city);
+CREATE INDEX IF NOT EXISTS locations_coordinates ON locations (latitude, longitude);
+CREATE TABLE IF NOT EXISTS measurements (
+	location      TEXT NOT NULL REFERENCES locations (location) ON DELETE CASCADE,
+	parameter     TEXT NOT NULL,
+	value         REAL NOT NULL,
+	unit          TEXT NOT NULL,
+	last_updated  TEXT NOT NULL,
+	quality_index INTEGER NOT NULL,
+	PRIMARY KEY (location, parameter)
+);
+CREATE TABLE IF NOT EXISTS air_quality (
+	level         TEXT NOT NULL,
+	name          TEXT NOT NULL,
+	quality_index INTEGER NOT NULL,
+	stations      INTEGER NOT NULL,
+	updated_at    TEXT NOT NULL,
+	PRIMARY KEY (level, name)
+);
+CREATE TABLE IF NOT EXISTS air_quality_parameters (
+	level         TEXT NOT NULL,
+	name          TEXT NOT NULL,
+	parameter     TEXT NOT NULL,
+	unit          TEXT NOT NULL,
+	median        REAL NOT NULL,
+	max           REAL NOT NULL,
+	quality_index INTEGER NOT NULL,
+	stations      INTEGER NOT NULL,
+	PRIMARY KEY (level, name, parameter, unit),
+	FOREIGN KEY (level, name) REFERENCES air_quality (level, name) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version          INTEGER PRIMARY KEY,
+	description      TEXT NOT NULL,
+	applied_at       TEXT NOT NULL,
+	duration_seconds REAL NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sync_state (
+	dataset        TEXT PRIMARY KEY,
+	watermark      TEXT NOT NULL,
+	last_success   TEXT NOT NULL,
+	last_full_sync TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sync_checkpoints (
+	dataset    TEXT PRIMARY KEY,
+	run_id     TEXT NOT NULL,
+	url        TEXT NOT NULL,
+	page       INTEGER NOT NULL,
+	total      INTEGER NOT NULL,
+	started_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sync_runs (
+	id          TEXT PRIMARY KEY,
+	trigger     TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	started_at  TEXT NOT NULL,
+	finished_at TEXT NOT NULL,
+	datasets    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sync_runs_finished_at ON sync_runs (finished_at);
+CREATE TABLE IF NOT EXISTS sync_leases (
+	name       TEXT PRIMARY KEY,
+	owner      TEXT NOT NULL,
+	expires_at TEXT NOT NULL
+);
+`
+
+// timeFormat stores timestamps in UTC with millisecond precision like mongo. The fixed width keeps
+// the text comparable.
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+// DB is a SQLite database holding the datasets and the sync bookkeeping.
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens or creates the database at path and creates missing tables.
+func Open(ctx context.Context, path string) (*DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating sqlite tables: %w", err)
+	}
+	return &DB{db}, nil
+}
+
+// OpenExisting opens the existing database at path read-only without creating tables.
+func OpenExisting(ctx context.Context, path string) (*DB, error) {
+	dsn := fmt.Sprintf("file:%s?mode=ro&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening sqlite database: %w", err)
+	}
+	return &DB{db}, nil
+}
+
+// schemaObjects matches the names of the tables and indexes created by schema.
+var schemaObjects = regexp.MustCompile(`CREATE (?:TABLE|INDEX) IF NOT EXISTS (\w+)`)
+
+// MissingSchema lists the tables and indexes that Open creates and are missing.
+func (d *DB) MissingSchema(ctx context.Context) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type IN ('table', 'index')")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, match := range schemaObjects.FindAllStringSubmatch(schema, -1) {
+		if !existing[match[1]] {
+			missing = append(missing, match[1])
+		}
+	}
+	return missing, nil
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Ping checks the connection to the database.
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+// inTx runs fn in a transaction that is committed if fn succeeds.
+func (d *DB) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(timeFormat, s)
+}
@@ -0,0 +1,385 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"github.com/nhe23/aq-dbsync/pkg/migrate"
+	"github.com/nhe23/aq-dbsync/pkg/runs"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"github.com/nhe23/aq-dbsync/pkg/syncstate"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(context.Background(), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func Test_OpenExisting(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	if db, err := OpenExisting(ctx, path); err == nil {
+		db.Close()
+		t.Fatalf("OpenExisting() of a missing database succeeded")
+	}
+	created, err := Open(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+	db, err := OpenExisting(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Cities().Find(ctx, storage.Filter{}); err != nil {
+		t.Errorf("Find() = %v", err)
+	}
+	if _, err := db.Cities().Upsert(ctx, []storage.Document{storage.City{Name: "Berlin"}}); err == nil {
+		t.Errorf("Upsert() of a read-only database succeeded")
+	}
+}
+
+func Test_DB_MissingSchema(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if got, err := db.MissingSchema(ctx); err != nil || len(got) != 0 {
+		t.Errorf("MissingSchema() = %v, %v, want none", got, err)
+	}
+	if _, err := db.db.ExecContext(ctx, "DROP INDEX locations_coordinates"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.MissingSchema(ctx); err != nil || !reflect.DeepEqual(got, []string{"locations_coordinates"}) {
+		t.Errorf("MissingSchema() = %v, %v, want locations_coordinates", got, err)
+	}
+}
+
+func testLocation(name string, city string, syncedAt time.Time, values ...float64) storage.Location {
+	location := storage.Location{
+		Location:    name,
+		City:        city,
+		Country:     "DE",
+		Coordinates: storage.Coordinates{Latitude: 52.52, Longitude: 13.4},
+		SyncedAt:    syncedAt,
+		Provider:    storage.DefaultProvider,
+	}
+	for i, value := range values {
+		location.Measurements = append(location.Measurements, storage.Measurement{
+			Parameter:    []string{"no2", "pm10", "pm25"}[i],
+			Value:        value,
+			LastUpdated:  syncedAt.Add(-time.Hour),
+			Unit:         "µg/m³",
+			QualityIndex: 1,
+		})
+	}
+	return location
+}
+
+func Test_repository_Locations(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestDB(t).Locations()
+	synced := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	got, err := repo.Upsert(ctx, []storage.Document{
+		testLocation("a", "Berlin", synced, 10, 20),
+		testLocation("b", "Hamburg", synced, 30.5),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (storage.UpsertResult{Upserted: 2}); !reflect.DeepEqual(got, want) {
+		t.Errorf("repository.Upsert() = %v, want %v", got, want)
+	}
+
+	// Replacing a location replaces all its measurements.
+	replaced := testLocation("a", "Berlin", synced.Add(time.Hour), 15)
+	got, err = repo.Upsert(ctx, []storage.Document{replaced})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (storage.UpsertResult{Matched: 1, Modified: 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("repository.Upsert() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name   string
+		filter storage.Filter
+		want   []storage.Document
+	}{
+		{"all", storage.Filter{}, []storage.Document{replaced, testLocation("b", "Hamburg", synced, 30.5)}},
+		{"keys", storage.Filter{Keys: []string{"b", "c"}}, []storage.Document{testLocation("b", "Hamburg", synced, 30.5)}},
+		{"city", storage.Filter{Country: "DE", City: "Berlin"}, []storage.Document{replaced}},
+		{"page", storage.Filter{Skip: 1, Limit: 5}, []storage.Document{testLocation("b", "Hamburg", synced, 30.5)}},
+		{"after", storage.Filter{After: "a", Limit: 1}, []storage.Document{testLocation("b", "Hamburg", synced, 30.5)}},
+		{"parameter", storage.Filter{Parameter: "no2"}, []storage.Document{replaced, testLocation("b", "Hamburg", synced, 30.5)}},
+		{"missing parameter", storage.Filter{Parameter: "pm10"}, nil},
+		{"bounds", storage.Filter{Bounds: &storage.Bounds{South: 52, West: 13, North: 53, East: 14}}, []storage.Document{replaced, testLocation("b", "Hamburg", synced, 30.5)}},
+		{"bounds across antimeridian", storage.Filter{Bounds: &storage.Bounds{South: 52, West: 170, North: 53, East: -170}}, nil},
+		{"none", storage.Filter{Country: "FR"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.Find(ctx, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("repository.Find() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Stale locations of other providers are kept.
+	other := testLocation("c", "Berlin", synced, 10)
+	other.Provider, other.LowCost = "other", true
+	if _, err := repo.Upsert(ctx, []storage.Document{other}); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := repo.DeleteStale(ctx, storage.DefaultProvider, synced.Add(time.Minute))
+	if err != nil || deleted != 1 {
+		t.Fatalf("repository.DeleteStale() = %v, %v, want 1", deleted, err)
+	}
+	remaining, _ := repo.Find(ctx, storage.Filter{})
+	if len(remaining) != 2 || remaining[0].Key() != "a" || !reflect.DeepEqual(remaining[1], other) {
+		t.Errorf("remaining locations = %v", remaining)
+	}
+}
+
+func Test_repository_CitiesCountries(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	synced := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		repo storage.Repository
+		docs []storage.Document
+	}{
+		{"cities", db.Cities(), []storage.Document{
+			storage.City{Name: "Berlin", Country: "DE", Count: 10, Locations: 2, SyncedAt: synced},
+			storage.City{Name: "Paris", Country: "FR", Count: 5, Locations: 1, SyncedAt: synced},
+		}},
+		{"countries", db.Countries(), []storage.Document{
+			storage.Country{Code: "DE", Name: "Germany", Count: 10, Cities: 3, Locations: 4, SyncedAt: synced},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.repo.Upsert(ctx, tt.docs); err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.repo.Find(ctx, storage.Filter{Country: "DE"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.docs[:1]) {
+				t.Errorf("repository.Find() = %v, want %v", got, tt.docs[:1])
+			}
+			if _, err := tt.repo.Upsert(ctx, []storage.Document{storage.Location{Location: "x"}}); err == nil {
+				t.Errorf("repository.Upsert() of a wrong type did not fail")
+			}
+		})
+	}
+}
+
+func Test_airQualityRepository_SetAirQuality(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestDB(t).Cities()
+	synced := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	city := storage.City{Name: "Berlin", Country: "DE", Count: 10, Locations: 2, SyncedAt: synced}
+	other := storage.City{Name: "Hamburg", Country: "DE", Count: 5, Locations: 1, SyncedAt: synced}
+	if _, err := repo.Upsert(ctx, []storage.Document{city, other}); err != nil {
+		t.Fatal(err)
+	}
+	aq := storage.AirQuality{QualityIndex: 2, Stations: 2, UpdatedAt: synced, Parameters: []storage.ParameterQuality{
+		{Parameter: "no2", Unit: "µg/m³", Median: 30.5, Max: 41, QualityIndex: 1, Stations: 2},
+		{Parameter: "pm10", Unit: "µg/m³", Median: 22, Max: 22, QualityIndex: 2, Stations: 1},
+	}}
+	writer := repo.(storage.AirQualityWriter)
+	// The first air quality is replaced by the second, which also clears that of Hamburg.
+	if err := writer.SetAirQuality(ctx, map[string]storage.AirQuality{"Berlin": {Stations: 9}, "Hamburg": {Stations: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.SetAirQuality(ctx, map[string]storage.AirQuality{"Berlin": aq}); err != nil {
+		t.Fatal(err)
+	}
+	// Syncing the city keeps its air quality.
+	city.Count = 11
+	if _, err := repo.Upsert(ctx, []storage.Document{city}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.Find(ctx, storage.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	city.AirQuality = &aq
+	if !reflect.DeepEqual(got, []storage.Document{city, other}) {
+		t.Errorf("repository.Find() = %+v, want %+v", got, []storage.Document{city, other})
+	}
+}
+
+func Test_bookkeeping(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	state := syncstate.State{Dataset: "measurements", Watermark: now, LastSuccess: now, LastFullSync: now.Add(-time.Hour)}
+	if err := db.SyncState().Save(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.SyncState().Load(ctx, "measurements"); err != nil || got != state {
+		t.Errorf("SyncState().Load() = %v, %v, want %v", got, err, state)
+	}
+	if got, _ := db.SyncState().Load(ctx, "cities"); !got.Watermark.IsZero() {
+		t.Errorf("SyncState().Load() of a new dataset = %v", got)
+	}
+
+	cp := dataprocessor.Checkpoint{Dataset: "cities", RunID: "r", URL: "u", Page: 3, Total: 10, StartedAt: now, UpdatedAt: now}
+	if err := db.Checkpoints().SaveCheckpoint(ctx, cp); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Checkpoints().LoadCheckpoint(ctx, "cities"); err != nil || got == nil || *got != cp {
+		t.Errorf("Checkpoints().LoadCheckpoint() = %v, %v, want %v", got, err, cp)
+	}
+	if err := db.Checkpoints().DeleteCheckpoint(ctx, "cities"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Checkpoints().LoadCheckpoint(ctx, "cities"); err != nil || got != nil {
+		t.Errorf("Checkpoints().LoadCheckpoint() after delete = %v, %v", got, err)
+	}
+
+	leases := db.Leases()
+	for _, step := range []struct {
+		owner     string
+		expiresAt time.Time
+		want      bool
+	}{
+		{"a", now.Add(time.Minute), true},
+		{"b", now.Add(time.Minute), false},
+		{"a", now.Add(-time.Minute), true},
+		{"b", now.Add(time.Minute), true},
+	} {
+		if got, err := leases.TryAcquire(ctx, "cities", step.owner, step.expiresAt); err != nil || got != step.want {
+			t.Errorf("Leases().TryAcquire(%s) = %v, %v, want %v", step.owner, got, err, step.want)
+		}
+	}
+}
+
+func Test_DB_Migrations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "old.db")
+	old, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tables of a database created before the schema version existed and while values were integers.
+	_, err = old.ExecContext(ctx, `CREATE TABLE cities (name TEXT PRIMARY KEY, country TEXT NOT NULL, count INTEGER NOT NULL,
+		locations INTEGER NOT NULL, synced_at TEXT NOT NULL);
+		INSERT INTO cities VALUES ('Berlin', 'DE', 1, 1, '2021-03-01T12:00:00.000Z');
+		CREATE TABLE locations (location TEXT PRIMARY KEY, city TEXT NOT NULL, country TEXT NOT NULL, latitude REAL NOT NULL,
+		longitude REAL NOT NULL, synced_at TEXT NOT NULL);
+		INSERT INTO locations VALUES ('a', 'Berlin', 'DE', 52.5, 13.4, '2021-03-01T12:00:00.000Z');
+		CREATE TABLE measurements (location TEXT NOT NULL REFERENCES locations (location) ON DELETE CASCADE, parameter TEXT NOT NULL,
+		value INTEGER NOT NULL, unit TEXT NOT NULL, last_updated TEXT NOT NULL, quality_index INTEGER NOT NULL,
+		PRIMARY KEY (location, parameter));
+		INSERT INTO measurements VALUES ('a', 'pm10', 12, 'µg/m³', '2021-03-01T12:00:00.000Z', 1)`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrator, err := migrate.NewMigrator(db.MigrationRecords(), nil, db.Migrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := migrator.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.Cities().Find(ctx, storage.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].(storage.City).SchemaVersion != 3 || got[0].(storage.City).Provider != storage.DefaultProvider {
+		t.Errorf("cities after migration = %+v", got)
+	}
+	var valueType string
+	if err := db.db.QueryRowContext(ctx, "SELECT typeof(value) FROM measurements").Scan(&valueType); err != nil || valueType != "real" {
+		t.Errorf("type of migrated values = %q, %v, want real", valueType, err)
+	}
+	got, err = db.Locations().Find(ctx, storage.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].(storage.Location).SchemaVersion != 3 || got[0].(storage.Location).Measurements[0].Value != 12 {
+		t.Errorf("locations after migration = %+v", got)
+	}
+	applied, err := db.MigrationRecords().Applied(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || len(applied) != 4 || applied[3].Version != 4 {
+		t.Errorf("applied migrations = %+v", applied)
+	}
+	// Migrations are idempotent on new databases.
+	if err := db.addSchemaVersion(ctx); err != nil {
+		t.Errorf("addSchemaVersion() on a migrated database = %v", err)
+	}
+	if err := db.addProvider(ctx); err != nil {
+		t.Errorf("addProvider() on a migrated database = %v", err)
+	}
+	if err := db.addLowCost(ctx); err != nil {
+		t.Errorf("addLowCost() on a migrated database = %v", err)
+	}
+	if err := db.realValues(ctx); err != nil {
+		t.Errorf("realValues() on a migrated database = %v", err)
+	}
+}
+
+func Test_runStore(t *testing.T) {
+	ctx := context.Background()
+	store := openTestDB(t).Runs(24 * time.Hour)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	run := func(id string, finishedAt time.Time, status string) runs.Run {
+		return runs.Run{ID: id, Trigger: "schedule", Status: status, StartedAt: finishedAt.Add(-time.Minute), FinishedAt: finishedAt,
+			Datasets: []runs.Dataset{{Name: "cities", Status: status, StartedAt: finishedAt.Add(-time.Minute), Pages: 2, Upserted: 3,
+				Rejected: 1, RejectedDocs: []storage.Rejection{{Key: "Berlin", Reason: "Document failed validation"}}}}}
+	}
+	for _, r := range []runs.Run{
+		run("expired", now.Add(-48*time.Hour), runs.StatusOK),
+		run("ok", now.Add(-2*time.Hour), runs.StatusOK),
+		run("failed", now.Add(-time.Hour), "failed"),
+	} {
+		if err := store.Save(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recent, err := store.Recent(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || recent[0].ID != "failed" || !reflect.DeepEqual(recent[1], run("ok", now.Add(-2*time.Hour), runs.StatusOK)) {
+		t.Errorf("Recent() = %+v", recent)
+	}
+	if got, err := store.LastSuccess(ctx, "cities"); err != nil || got == nil || got.ID != "ok" {
+		t.Errorf("LastSuccess() = %+v, %v, want ok", got, err)
+	}
+	if got, err := store.LastSuccess(ctx, "countries"); err != nil || got != nil {
+		t.Errorf("LastSuccess() of a dataset without runs = %+v, %v", got, err)
+	}
+}
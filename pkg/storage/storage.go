@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Document is a record of a dataset identified by its key.
+type Document interface {
+	Key() string
+}
+
+// UpsertResult counts the documents written by an upsert.
+type UpsertResult struct {
+	// Matched is the number of documents that already existed and were replaced.
+	Matched int64
+	// Modified is the number of replaced documents whose content changed, as far as the backend can tell.
+	Modified int64
+	// Upserted is the number of inserted documents.
+	Upserted int64
+	// Rejected are the documents the storage refused to write, e.g. because they failed validation.
+	Rejected []Rejection
+}
+
+// Rejection is a document the storage refused to write.
+type Rejection struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// Add adds the counts of other to r.
+func (r *UpsertResult) Add(other UpsertResult) {
+	r.Matched += other.Matched
+	r.Modified += other.Modified
+	r.Upserted += other.Upserted
+	r.Rejected = append(r.Rejected, other.Rejected...)
+}
+
+// Filter selects documents of a dataset. Empty fields match all documents.
+type Filter struct {
+	Keys    []string
+	Country string
+	City    string
+	// Parameter only matches locations with a measurement of the parameter.
+	Parameter string
+	// Bounds only matches locations within the bounds.
+	Bounds *Bounds
+	// After only matches documents whose key sorts after it, for paging through large datasets.
+	After string
+	// Limit is the maximum number of documents returned, 0 returns all.
+	Limit int
+	Skip  int
+}
+
+// Bounds is a box of coordinates. A box crossing the antimeridian has a West greater than East.
+type Bounds struct {
+	South float64
+	West  float64
+	North float64
+	East  float64
+}
+
+// Contains reports whether c is within the bounds.
+func (b Bounds) Contains(c Coordinates) bool {
+	if c.Latitude < b.South || c.Latitude > b.North {
+		return false
+	}
+	if b.West <= b.East {
+		return c.Longitude >= b.West && c.Longitude <= b.East
+	}
+	return c.Longitude >= b.West || c.Longitude <= b.East
+}
+
+// Repository stores the documents of a dataset.
+type Repository interface {
+	// Upsert inserts docs or replaces the stored documents with the same keys.
+	Upsert(ctx context.Context, docs []Document) (UpsertResult, error)
+	// Find returns the documents matching filter ordered by key.
+	Find(ctx context.Context, filter Filter) ([]Document, error)
+	// DeleteStale deletes the documents of provider that were not synced since before.
+	DeleteStale(ctx context.Context, provider string, before time.Time) (int64, error)
+}
+
+// AirQualityWriter is implemented by the repositories of cities and countries to store their
+// current air quality without touching the synced fields.
+type AirQualityWriter interface {
+	// SetAirQuality sets the air quality of the documents with the keys of airQuality and
+	// removes it from all other documents. Keys without a document are ignored.
+	SetAirQuality(ctx context.Context, airQuality map[string]AirQuality) error
+}
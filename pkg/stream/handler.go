@@ -0,0 +1,123 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// Options configures the Server-Sent Events endpoint.
+type Options struct {
+	// Heartbeat is the interval of comments that keep idle connections open.
+	Heartbeat time.Duration
+	// AllowOrigin is sent as Access-Control-Allow-Origin if set, so browsers of other origins can
+	// subscribe.
+	AllowOrigin string
+}
+
+// NewHandler returns the handler of the Server-Sent Events endpoint of b.
+//
+//	GET /v1/stream?country=DE&city=Berlin&bbox=13.0,52.3,13.8,52.7&parameter=pm25
+//
+// Every changed location matching the filter is sent as "location" event with the location as
+// JSON. The bounding box is west,south,east,north.
+func NewHandler(b *Broker, opts Options) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/stream", func(w http.ResponseWriter, r *http.Request) {
+		if opts.AllowOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", opts.AllowOrigin)
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		filter, err := parseFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		updates, unsubscribe := b.Subscribe(filter)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": subscribed\n\n")
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(opts.Heartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case location, ok := <-updates:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(location)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(w, "event: location\ndata: %s\n\n", data)
+			}
+			flusher.Flush()
+		}
+	})
+	return mux
+}
+
+// parseFilter returns the filter of the query parameters of r.
+func parseFilter(r *http.Request) (Filter, error) {
+	q := r.URL.Query()
+	filter := Filter{Country: q.Get("country"), City: q.Get("city"), Parameter: q.Get("parameter")}
+	if bbox := q.Get("bbox"); bbox != "" {
+		bounds, err := parseBounds(bbox)
+		if err != nil {
+			return Filter{}, err
+		}
+		filter.Bounds = bounds
+	}
+	return filter, nil
+}
+
+// parseBounds parses a bounding box of west,south,east,north.
+func parseBounds(bbox string) (*storage.Bounds, error) {
+	invalid := errors.New("bbox must be west,south,east,north with longitudes between -180 and 180 and latitudes between -90 and 90")
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return nil, invalid
+	}
+	values := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, invalid
+		}
+		values = append(values, v)
+	}
+	b := storage.Bounds{West: values[0], South: values[1], East: values[2], North: values[3]}
+	for _, longitude := range []float64{b.West, b.East} {
+		if longitude < -180 || longitude > 180 {
+			return nil, invalid
+		}
+	}
+	if b.South < -90 || b.North > 90 || b.South > b.North {
+		return nil, invalid
+	}
+	return &b, nil
+}
@@ -0,0 +1,199 @@
+// Package stream publishes the locations whose measurements changed while syncing to subscribers
+// of a Server-Sent Events endpoint.
+package stream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+// Filter selects the published locations. Empty fields match all locations.
+type Filter struct {
+	Country string
+	City    string
+	// Parameter only matches locations with a measurement of the parameter and only their
+	// measurements of it are published.
+	Parameter string
+	Bounds    *storage.Bounds
+}
+
+// Match returns location as published to subscribers of f and whether it matches f.
+func (f Filter) Match(location storage.Location) (storage.Location, bool) {
+	if f.Country != "" && location.Country != f.Country || f.City != "" && location.City != f.City {
+		return location, false
+	}
+	if f.Bounds != nil && !f.Bounds.Contains(location.Coordinates) {
+		return location, false
+	}
+	if f.Parameter == "" {
+		return location, true
+	}
+	measurements := []storage.Measurement{}
+	for _, m := range location.Measurements {
+		if m.Parameter == f.Parameter {
+			measurements = append(measurements, m)
+		}
+	}
+	location.Measurements = measurements
+	return location, len(measurements) > 0
+}
+
+type subscriber struct {
+	filter  Filter
+	updates chan storage.Location
+}
+
+// Broker publishes changed locations to its subscribers.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	buffer      int
+	closed      bool
+}
+
+// NewBroker creates a Broker. Subscribers that fall more than buffer locations behind are
+// disconnected so that they do not hold up the sync.
+func NewBroker(buffer int) *Broker {
+	return &Broker{subscribers: make(map[*subscriber]struct{}), buffer: buffer}
+}
+
+// Subscribe returns the channel of the published locations matching filter and a function that
+// ends the subscription. The channel is closed when the subscription ends, the subscriber falls
+// behind or the broker is closed.
+func (b *Broker) Subscribe(filter Filter) (<-chan storage.Location, func()) {
+	s := &subscriber{filter: filter, updates: make(chan storage.Location, b.buffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.updates)
+		return s.updates, func() {}
+	}
+	b.subscribers[s] = struct{}{}
+	return s.updates, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(s)
+	}
+}
+
+// Subscribers returns the number of subscribers.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// Publish sends the locations to the subscribers whose filter they match.
+func (b *Broker) Publish(locations []storage.Location) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subscribers {
+		for _, location := range locations {
+			published, ok := s.filter.Match(location)
+			if !ok {
+				continue
+			}
+			select {
+			case s.updates <- published:
+			default:
+				b.remove(s)
+			}
+			if _, subscribed := b.subscribers[s]; !subscribed {
+				break
+			}
+		}
+	}
+}
+
+// Close ends all subscriptions.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subscribers {
+		b.remove(s)
+	}
+}
+
+// remove ends the subscription of s, b.mu must be held.
+func (b *Broker) remove(s *subscriber) {
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.updates)
+	}
+}
+
+// Repository returns repo publishing the locations whose measurements or coordinates changed by
+// an upsert to b.
+func (b *Broker) Repository(repo storage.Repository) storage.Repository {
+	return repository{repo, b}
+}
+
+type repository struct {
+	storage.Repository
+	broker *Broker
+}
+
+func (r repository) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	if r.broker.Subscribers() == 0 {
+		return r.Repository.Upsert(ctx, docs)
+	}
+	keys := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		keys = append(keys, doc.Key())
+	}
+	// Without the stored locations all of them are published as changed.
+	stored := make(map[string]storage.Location)
+	if found, err := r.Repository.Find(ctx, storage.Filter{Keys: keys}); err == nil {
+		for _, doc := range found {
+			if location, ok := doc.(storage.Location); ok {
+				stored[location.Location] = location
+			}
+		}
+	}
+	result, err := r.Repository.Upsert(ctx, docs)
+	if err != nil {
+		return result, err
+	}
+	rejected := make(map[string]bool)
+	for _, rejection := range result.Rejected {
+		rejected[rejection.Key] = true
+	}
+	var changed []storage.Location
+	for _, doc := range docs {
+		location, ok := doc.(storage.Location)
+		if !ok || rejected[location.Location] {
+			continue
+		}
+		if previous, ok := stored[location.Location]; !ok || Changed(previous, location) {
+			changed = append(changed, location)
+		}
+	}
+	if len(changed) > 0 {
+		r.broker.Publish(changed)
+	}
+	return result, nil
+}
+
+// Changed reports whether the coordinates or measurements of a location differ between previous
+// and current. Timestamps are compared at the millisecond precision of the storage.
+func Changed(previous storage.Location, current storage.Location) bool {
+	if previous.Coordinates != current.Coordinates || len(previous.Measurements) != len(current.Measurements) {
+		return true
+	}
+	measurements := make(map[string]storage.Measurement, len(previous.Measurements))
+	for _, m := range previous.Measurements {
+		measurements[m.Parameter] = m
+	}
+	for _, m := range current.Measurements {
+		p, ok := measurements[m.Parameter]
+		if !ok || p.Value != m.Value || p.Unit != m.Unit ||
+			!p.LastUpdated.Truncate(time.Millisecond).Equal(m.LastUpdated.Truncate(time.Millisecond)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+func testLocation(name string, city string, value float64) storage.Location {
+	return storage.Location{
+		Location:    name,
+		City:        city,
+		Country:     "DE",
+		Coordinates: storage.Coordinates{Latitude: 52.52, Longitude: 13.4},
+		Measurements: []storage.Measurement{
+			{Parameter: "no2", Value: value, LastUpdated: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)},
+			{Parameter: "pm25", Value: 5, LastUpdated: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)},
+		},
+	}
+}
+
+func Test_Filter_Match(t *testing.T) {
+	location := testLocation("a", "Berlin", 10)
+	tests := []struct {
+		name             string
+		filter           Filter
+		want             bool
+		wantMeasurements int
+	}{
+		{"all", Filter{}, true, 2},
+		{"city", Filter{Country: "DE", City: "Berlin"}, true, 2},
+		{"other city", Filter{City: "Hamburg"}, false, 2},
+		{"bounds", Filter{Bounds: &storage.Bounds{South: 52, West: 13, North: 53, East: 14}}, true, 2},
+		{"outside bounds", Filter{Bounds: &storage.Bounds{South: 48, West: 2, North: 49, East: 3}}, false, 2},
+		{"parameter", Filter{Parameter: "pm25"}, true, 1},
+		{"missing parameter", Filter{Parameter: "o3"}, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.filter.Match(location)
+			if ok != tt.want || len(got.Measurements) != tt.wantMeasurements {
+				t.Errorf("Filter.Match() = %v, %v, want %v with %d measurements", got, ok, tt.want, tt.wantMeasurements)
+			}
+		})
+	}
+}
+
+func Test_Broker(t *testing.T) {
+	b := NewBroker(1)
+	berlin, _ := b.Subscribe(Filter{City: "Berlin"})
+	all, _ := b.Subscribe(Filter{})
+	hamburg, unsubscribe := b.Subscribe(Filter{City: "Hamburg"})
+	unsubscribe()
+
+	b.Publish([]storage.Location{testLocation("a", "Berlin", 10)})
+	if got := <-berlin; got.Location != "a" {
+		t.Errorf("berlin received %v", got)
+	}
+	if _, ok := <-hamburg; ok {
+		t.Errorf("unsubscribed channel is open")
+	}
+	// all did not receive its first location yet and falls behind.
+	b.Publish([]storage.Location{testLocation("b", "Berlin", 10)})
+	if got := (<-all).Location; got != "a" {
+		t.Errorf("all received %v", got)
+	}
+	if _, ok := <-all; ok || b.Subscribers() != 1 {
+		t.Errorf("slow subscriber was not disconnected, %d subscribers", b.Subscribers())
+	}
+	b.Close()
+	<-berlin
+	if _, ok := <-berlin; ok {
+		t.Errorf("channel is open after Close")
+	}
+}
+
+type fakeRepository struct {
+	storage.Repository
+	stored   []storage.Document
+	rejected []storage.Rejection
+}
+
+func (f *fakeRepository) Find(ctx context.Context, filter storage.Filter) ([]storage.Document, error) {
+	return f.stored, nil
+}
+
+func (f *fakeRepository) Upsert(ctx context.Context, docs []storage.Document) (storage.UpsertResult, error) {
+	return storage.UpsertResult{Rejected: f.rejected}, nil
+}
+
+func Test_repository_Upsert(t *testing.T) {
+	b := NewBroker(10)
+	updates, _ := b.Subscribe(Filter{})
+	repo := b.Repository(&fakeRepository{
+		stored:   []storage.Document{testLocation("same", "Berlin", 10), testLocation("changed", "Berlin", 10)},
+		rejected: []storage.Rejection{{Key: "rejected"}},
+	})
+	unchanged := testLocation("same", "Berlin", 10)
+	unchanged.SyncedAt = time.Now()
+	_, err := repo.Upsert(context.Background(), []storage.Document{
+		unchanged, testLocation("changed", "Berlin", 20), testLocation("new", "Berlin", 10), testLocation("rejected", "Berlin", 10),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	var published []string
+	for location := range updates {
+		published = append(published, location.Location)
+	}
+	if got := strings.Join(published, ","); got != "changed,new" {
+		t.Errorf("published %v, want changed,new", got)
+	}
+}
+
+func Test_Changed(t *testing.T) {
+	later := testLocation("a", "Berlin", 10)
+	later.Measurements[0].LastUpdated = later.Measurements[0].LastUpdated.Add(time.Hour)
+	moved := testLocation("a", "Berlin", 10)
+	moved.Coordinates.Latitude = 48
+	fewer := testLocation("a", "Berlin", 10)
+	fewer.Measurements = fewer.Measurements[:1]
+	precise := testLocation("a", "Berlin", 10)
+	precise.Measurements[0].LastUpdated = precise.Measurements[0].LastUpdated.Add(time.Microsecond)
+	tests := []struct {
+		name    string
+		current storage.Location
+		want    bool
+	}{
+		{"same", testLocation("a", "Berlin", 10), false},
+		{"sub millisecond", precise, false},
+		{"value", testLocation("a", "Berlin", 11), true},
+		{"last updated", later, true},
+		{"coordinates", moved, true},
+		{"measurements", fewer, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Changed(testLocation("a", "Berlin", 10), tt.current); got != tt.want {
+				t.Errorf("Changed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_handler(t *testing.T) {
+	b := NewBroker(10)
+	server := httptest.NewServer(NewHandler(b, Options{Heartbeat: time.Hour, AllowOrigin: "*"}))
+	defer server.Close()
+
+	for _, query := range []string{"bbox=1,2,3", "bbox=13,53,14,52", "bbox=181,52,14,53"} {
+		resp, err := http.Get(server.URL + "/v1/stream?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status = %v, want %v", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/v1/stream?bbox=13,52,14,53&parameter=pm25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("headers = %v", resp.Header)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	lines.Scan()
+	if lines.Text() != ": subscribed" {
+		t.Fatalf("first line = %q", lines.Text())
+	}
+	paris := testLocation("b", "Paris", 10)
+	paris.Coordinates = storage.Coordinates{Latitude: 48.86, Longitude: 2.35}
+	b.Publish([]storage.Location{paris, testLocation("a", "Berlin", 10)})
+	var event []string
+	for lines.Scan() && len(event) < 2 {
+		if lines.Text() != "" {
+			event = append(event, lines.Text())
+		}
+	}
+	if event[0] != "event: location" || !strings.HasPrefix(event[1], `data: {"location":"a"`) || strings.Contains(event[1], "no2") {
+		t.Errorf("event = %v", event)
+	}
+}
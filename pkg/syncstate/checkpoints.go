@@ -0,0 +1,41 @@
+package syncstate
+
+import (
+	"context"
+
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoCheckpoints struct {
+	col *mongo.Collection
+}
+
+// NewMongoCheckpoints creates a dataprocessor.CheckpointStore backed by a mongo collection.
+func NewMongoCheckpoints(col *mongo.Collection) dataprocessor.CheckpointStore {
+	return mongoCheckpoints{col}
+}
+
+func (m mongoCheckpoints) LoadCheckpoint(ctx context.Context, dataset string) (*dataprocessor.Checkpoint, error) {
+	var cp dataprocessor.Checkpoint
+	err := m.col.FindOne(ctx, bson.M{"_id": dataset}).Decode(&cp)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (m mongoCheckpoints) SaveCheckpoint(ctx context.Context, cp dataprocessor.Checkpoint) error {
+	_, err := m.col.ReplaceOne(ctx, bson.M{"_id": cp.Dataset}, cp, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m mongoCheckpoints) DeleteCheckpoint(ctx context.Context, dataset string) error {
+	_, err := m.col.DeleteOne(ctx, bson.M{"_id": dataset})
+	return err
+}
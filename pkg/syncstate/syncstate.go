@@ -0,0 +1,59 @@
+package syncstate
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// State is the persisted sync state of a dataset.
+type State struct {
+	Dataset string `bson:"_id"`
+	// Watermark is the newest lastUpdated timestamp seen by a successful sync.
+	Watermark    time.Time `bson:"watermark"`
+	LastSuccess  time.Time `bson:"lastSuccess"`
+	LastFullSync time.Time `bson:"lastFullSync"`
+}
+
+// NeedsFullSync reports whether the next sync has to fetch the whole dataset.
+func (s State) NeedsFullSync(now time.Time, fullSyncInterval time.Duration) bool {
+	return s.Watermark.IsZero() || now.Sub(s.LastFullSync) >= fullSyncInterval
+}
+
+// Since returns the timestamp from which an incremental sync requests data.
+func (s State) Since(overlap time.Duration) time.Time {
+	return s.Watermark.Add(-overlap)
+}
+
+// Store persists the sync state of datasets.
+type Store interface {
+	Load(ctx context.Context, dataset string) (State, error)
+	Save(ctx context.Context, state State) error
+}
+
+type mongoStore struct {
+	col *mongo.Collection
+}
+
+// NewMongoStore creates a Store backed by a mongo collection.
+func NewMongoStore(col *mongo.Collection) Store {
+	return mongoStore{col}
+}
+
+// Load returns the state of dataset or an empty state if the dataset was never synced.
+func (m mongoStore) Load(ctx context.Context, dataset string) (State, error) {
+	state := State{Dataset: dataset}
+	err := m.col.FindOne(ctx, bson.M{"_id": dataset}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return state, nil
+	}
+	return state, err
+}
+
+func (m mongoStore) Save(ctx context.Context, state State) error {
+	_, err := m.col.ReplaceOne(ctx, bson.M{"_id": state.Dataset}, state, options.Replace().SetUpsert(true))
+	return err
+}
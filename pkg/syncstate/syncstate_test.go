@@ -0,0 +1,34 @@
+package syncstate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestState_NeedsFullSync(t *testing.T) {
+	now := time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		state State
+		want  bool
+	}{
+		{"neverSynced", State{}, true},
+		{"recentFullSync", State{Watermark: now.Add(-time.Hour), LastFullSync: now.Add(-time.Hour)}, false},
+		{"fullSyncDue", State{Watermark: now.Add(-time.Hour), LastFullSync: now.Add(-25 * time.Hour)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.NeedsFullSync(now, 24*time.Hour); got != tt.want {
+				t.Errorf("State.NeedsFullSync() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestState_Since(t *testing.T) {
+	watermark := time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC)
+	s := State{Watermark: watermark}
+	if got, want := s.Since(15*time.Minute), watermark.Add(-15*time.Minute); !got.Equal(want) {
+		t.Errorf("State.Since() = %v, want %v", got, want)
+	}
+}
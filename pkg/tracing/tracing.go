@@ -0,0 +1,147 @@
+// Package tracing exports OpenTelemetry spans of the syncs.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name is the instrumentation name of the spans of the service.
+const Name = "github.com/nhe23/aq-dbsync"
+
+// Exporters of spans.
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Options configures the export of spans.
+type Options struct {
+	ServiceName string
+	// Exporter is one of ExporterOTLP, ExporterStdout or ExporterFile.
+	Exporter string
+	// Endpoint is the host:port of the OTLP/HTTP collector, empty uses the OTEL_EXPORTER_OTLP_ environment.
+	Endpoint string
+	Insecure bool
+	// File is the path the file exporter appends the spans to.
+	File        string
+	SampleRatio float64
+}
+
+// Tracer returns the tracer of the service, whose spans are dropped until Setup installed an exporter.
+func Tracer() trace.Tracer {
+	return otel.Tracer(Name)
+}
+
+// Setup installs a global tracer provider exporting the spans as configured by opts and returns a
+// function flushing the pending spans and stopping the export.
+func Setup(ctx context.Context, opts Options) (func(ctx context.Context) error, error) {
+	exporter, output, err := newExporter(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", opts.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if output != nil {
+			if closeErr := output.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// newExporter returns the exporter of opts and the file it writes to, if any.
+func newExporter(ctx context.Context, opts Options) (sdktrace.SpanExporter, io.Closer, error) {
+	switch opts.Exporter {
+	case ExporterOTLP:
+		var clientOpts []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating otlp exporter: %w", err)
+		}
+		return exporter, nil, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		return exporter, nil, err
+	case ExporterFile:
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown exporter %q", opts.Exporter)
+	}
+}
+
+// End records err on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// RecordRetries adds events for the failed attempts and retries of the requests of client to the
+// spans of their contexts.
+func RecordRetries(client *retryablehttp.Client) {
+	requestHook := client.RequestLogHook
+	client.RequestLogHook = func(l retryablehttp.Logger, req *http.Request, attempt int) {
+		if attempt > 0 {
+			trace.SpanFromContext(req.Context()).AddEvent("retry", trace.WithAttributes(attribute.Int("http.retry_count", attempt)))
+		}
+		if requestHook != nil {
+			requestHook(l, req, attempt)
+		}
+	}
+	checkRetry := client.CheckRetry
+	client.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		retry, checkErr := checkRetry(ctx, resp, err)
+		if retry {
+			var attrs []attribute.KeyValue
+			if resp != nil {
+				attrs = append(attrs, attribute.Int("http.status_code", resp.StatusCode))
+			}
+			if err != nil {
+				attrs = append(attrs, attribute.String("error", err.Error()))
+			}
+			trace.SpanFromContext(ctx).AddEvent("attempt failed", trace.WithAttributes(attrs...))
+		}
+		return retry, checkErr
+	}
+}
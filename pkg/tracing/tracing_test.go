@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_Setup(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	file := filepath.Join(t.TempDir(), "traces.json")
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"file", Options{ServiceName: "aq-dbsync", Exporter: ExporterFile, File: file, SampleRatio: 1}, false},
+		{"otlp", Options{Exporter: ExporterOTLP, Endpoint: "localhost:4318", Insecure: true, SampleRatio: 1}, false},
+		{"missing directory", Options{Exporter: ExporterFile, File: filepath.Join(file, "missing", "traces.json")}, true},
+		{"unknown", Options{Exporter: "zipkin"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdown, err := Setup(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Setup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.opts.Exporter == ExporterFile {
+				_, span := Tracer().Start(context.Background(), "sync.run")
+				span.End()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				t.Fatalf("shutdown() error = %v", err)
+			}
+		})
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Name":"sync.run"`) || !strings.Contains(string(data), "aq-dbsync") {
+		t.Errorf("unexpected spans %s", data)
+	}
+}
+
+func Test_End(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(Name)
+	_, ok := tracer.Start(context.Background(), "ok")
+	End(ok, nil)
+	_, failed := tracer.Start(context.Background(), "failed")
+	End(failed, errors.New("VERY BAD ERROR"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].Status.Code != codes.Unset || len(spans[0].Events) != 0 {
+		t.Errorf("unexpected span %+v", spans[0])
+	}
+	if spans[1].Status.Code != codes.Error || spans[1].Status.Description != "VERY BAD ERROR" || len(spans[1].Events) != 1 {
+		t.Errorf("unexpected span %+v", spans[1])
+	}
+}
+
+func Test_RecordRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(Name)
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RetryWaitMin, client.RetryWaitMax = time.Millisecond, time.Millisecond
+	RecordRetries(client)
+
+	ctx, span := tracer.Start(context.Background(), "fetch")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	resp, err := client.StandardClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	span.End()
+
+	var names []string
+	for _, event := range exporter.GetSpans()[0].Events {
+		names = append(names, event.Name)
+	}
+	want := "attempt failed,retry,attempt failed,retry"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
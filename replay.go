@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/archive"
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"github.com/nhe23/aq-dbsync/pkg/replay"
+	"github.com/nhe23/aq-dbsync/pkg/source"
+	"github.com/nhe23/aq-dbsync/pkg/source/openaq"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+)
+
+type replaySummary struct {
+	commandSummary
+	Datasets []replayDataset `json:"datasets"`
+}
+
+type replayDataset struct {
+	Name  string `json:"name"`
+	Pages int    `json:"pages"`
+}
+
+// runReplay ingests recorded api pages from a directory of JSON files or the archive without
+// accessing the network.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	s, err := registerSettings(fs, args)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	var (
+		dir        = fs.String("dir", "", "Directory with <dataset>.json files or <dataset>/ directories of JSON pages")
+		archiveDir = fs.String("from-archive", "", "Directory of an archive of api responses to replay")
+		from       = fs.String("from", "", "Replay archived responses fetched at or after this date (2006-01-02) or RFC3339 timestamp")
+		to         = fs.String("to", "", "Replay archived responses fetched before this date or RFC3339 timestamp")
+	)
+	if err := s.parse(fs, args); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	var src replay.Source
+	switch {
+	case *dir != "" && *archiveDir != "":
+		logger.Log("err", fmt.Errorf("only one of dir and from-archive can be set"))
+		return exitInitError
+	case *dir != "":
+		src = replay.NewDir(*dir)
+	case *archiveDir != "":
+		var fromTime, toTime time.Time
+		if *from != "" {
+			if fromTime, err = parseTime(*from); err != nil {
+				logger.Log("err", fmt.Errorf("invalid from: %w", err))
+				return exitInitError
+			}
+		}
+		if *to != "" {
+			if toTime, err = parseTime(*to); err != nil {
+				logger.Log("err", fmt.Errorf("invalid to: %w", err))
+				return exitInitError
+			}
+		}
+		src = replay.NewArchive(archive.NewDirStore(*archiveDir), fromTime, toTime)
+	default:
+		logger.Log("err", fmt.Errorf("one of dir and from-archive is required"))
+		return exitInitError
+	}
+	selected, err := s.selectedDatasets()
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopTracing, err := startTracing(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer stopTracing()
+
+	st, err := openStore(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer st.disconnect(s.Mongo.ConnectTimeout)
+	if err := st.migrateOnStartup(ctx, s); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+
+	// The recorded pages are api pages of OpenAQ.
+	provider := replay.Recorded(openaq.NewSource(openaq.Options{PageSize: s.API.BatchSize}), src)
+	processor := dataprocessor.NewDataProcessor(s.API.BatchSize)
+	summary := replaySummary{}
+	datasets, err := replayPages(ctx, src, replayFuncs(processor, provider), st.repos, selected)
+	summary.Datasets = datasets
+	code := summary.finish(ctx, err)
+	return printSummary(summary, code)
+}
+
+// replayFuncs returns the process funcs of the datasets of src.
+func replayFuncs(processor dataprocessor.DataProcessor, src source.Source) map[string]dataprocessor.DataProcessFunc {
+	funcs := make(map[string]dataprocessor.DataProcessFunc)
+	for _, d := range src.Datasets() {
+		funcs[d.Name] = processor.Process(src, d.Name)
+	}
+	return funcs
+}
+
+// replayPages processes the pages of the selected datasets of src in sync order.
+func replayPages(ctx context.Context, src replay.Source, funcs map[string]dataprocessor.DataProcessFunc, repos map[string]storage.Repository, selected map[string]bool) ([]replayDataset, error) {
+	var names []string
+	for _, name := range datasetNames {
+		if selected[name] && funcs[name] != nil {
+			names = append(names, name)
+		}
+	}
+	ctx, span := startRun(ctx, triggerReplay)
+	defer span.End()
+	pages, err := src.Pages(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	datasets := make([]replayDataset, 0, len(names))
+	index := make(map[string]int)
+	for i, name := range names {
+		datasets = append(datasets, replayDataset{Name: name})
+		index[name] = i
+	}
+	for _, page := range pages {
+		if err := ctx.Err(); err != nil {
+			return datasets, err
+		}
+		logger.Log("info", fmt.Sprintf("Replaying %s page %s", page.Dataset, page.URL))
+		if _, err := funcs[page.Dataset](ctx, page.URL, repos[page.Dataset]); err != nil {
+			return datasets, fmt.Errorf("error replaying %s: %w", page.URL, err)
+		}
+		datasets[index[page.Dataset]].Pages++
+	}
+	return datasets, nil
+}
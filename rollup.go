@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/rollup"
+)
+
+type rollupSummary struct {
+	commandSummary
+	rollup.Result
+}
+
+// runRollup recomputes the rollups of a date range from the history, e.g. after a backfill.
+func runRollup(args []string) int {
+	fs := flag.NewFlagSet("rollup", flag.ExitOnError)
+	s, err := registerSettings(fs, args)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	var (
+		from = fs.String("from", "", "Start of the range as date (2006-01-02) or RFC3339 timestamp")
+		to   = fs.String("to", "", "End of the range as date (2006-01-02) or RFC3339 timestamp, defaults to now")
+	)
+	if err := s.parse(fs, args); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	fromTime, err := parseTime(*from)
+	if err != nil {
+		logger.Log("err", fmt.Errorf("invalid from: %w", err))
+		return exitInitError
+	}
+	toTime := time.Now().UTC()
+	if *to != "" {
+		if toTime, err = parseTime(*to); err != nil {
+			logger.Log("err", fmt.Errorf("invalid to: %w", err))
+			return exitInitError
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	st, err := openStore(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer st.disconnect(s.Mongo.ConnectTimeout)
+	if st.rollups == nil {
+		logger.Log("err", fmt.Errorf("rollups are not supported by the %s storage backend", s.Storage.Backend))
+		return exitInitError
+	}
+	rollupper := rollup.NewRollupper(st.history, st.historyReader, st.rollups)
+
+	logger.Log("info", fmt.Sprintf("Recomputing rollups of %s to %s", fromTime.Format(time.RFC3339), toTime.Format(time.RFC3339)))
+	result, err := rollupper.Recompute(ctx, fromTime, toTime)
+	summary := rollupSummary{Result: result}
+	code := summary.finish(ctx, err)
+	return printSummary(summary, code)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/admin"
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"github.com/nhe23/aq-dbsync/pkg/runs"
+	"github.com/nhe23/aq-dbsync/pkg/scheduler"
+	"github.com/nhe23/aq-dbsync/pkg/source"
+	"github.com/nhe23/aq-dbsync/pkg/stream"
+	"github.com/nhe23/aq-dbsync/pkg/tracing"
+)
+
+// runServe syncs the selected datasets on their schedules until a shutdown signal is received.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	s, err := registerSettings(fs, args)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	fs.BoolVar(&s.Sync.RunOnStart, "run-on-start", s.Sync.RunOnStart, "Sync every dataset once at startup")
+	if err := s.parse(fs, args); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	return serve(s, s.Sync.RunOnStart)
+}
+
+func serve(s *settings, runOnStart bool) int {
+	logger.Log("info", "Starting service")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopTracing, err := startTracing(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer stopTracing()
+
+	st, err := openStore(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer st.disconnect(s.Mongo.ConnectTimeout)
+	if err := st.migrateOnStartup(ctx, s); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	syncer, dataParams, err := newDataParams(s, st)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	var broker *stream.Broker
+	if s.Stream.Enabled {
+		broker = stream.NewBroker(s.Stream.Buffer)
+		for i := range dataParams {
+			if dataParams[i].kind == source.Locations {
+				dataParams[i].repo = broker.Repository(dataParams[i].repo)
+			}
+		}
+	}
+
+	// interrupted is set when a sync was stopped before all pages were processed.
+	var interrupted int32
+	sched := scheduler.NewScheduler(ctx, logger)
+	for _, data := range dataParams {
+		job := syncJob(s, syncer, data, &interrupted)
+		if err := sched.Add(job); err != nil {
+			logger.Log("err", err)
+			return exitInitError
+		}
+	}
+	if s.Admin.Enabled {
+		server, err := startAdmin(s, sched, syncer, st.runs)
+		if err != nil {
+			logger.Log("err", err)
+			return exitInitError
+		}
+		defer stopServer(server)
+	}
+	if broker != nil {
+		handler := stream.NewHandler(broker, stream.Options{Heartbeat: s.Stream.Heartbeat, AllowOrigin: s.Stream.AllowOrigin})
+		server, err := startServer("stream", s.Stream.Addr, handler)
+		if err != nil {
+			logger.Log("err", err)
+			return exitInitError
+		}
+		defer stopServer(server)
+		// Ends the subscriptions first, the server waits for them otherwise.
+		defer broker.Close()
+	}
+	sched.Start(runOnStart)
+
+	<-ctx.Done()
+	logger.Log("info", "Shutdown requested, waiting for running syncs to finish")
+	sched.Stop()
+	if atomic.LoadInt32(&interrupted) != 0 {
+		logger.Log("info", "Service stopped, a sync was interrupted")
+		return exitInterrupted
+	}
+	logger.Log("info", "Service stopped")
+	return exitOK
+}
+
+// syncJob returns the job syncing a dataset on its schedule or when triggered by the admin API.
+// It sets interrupted when a sync is stopped before all pages were processed.
+func syncJob(s *settings, syncer *syncer, data dataProcessParams, interrupted *int32) scheduler.Job {
+	return scheduler.Job{
+		Name:     data.name,
+		Schedule: s.Schedule(data.name),
+		Jitter:   s.Datasets[data.name].Jitter,
+		Timeout:  s.Timeout(data.name),
+		Run: func(ctx context.Context) (err error) {
+			trigger := triggerSchedule
+			if scheduler.Triggered(ctx) {
+				trigger = triggerAdmin
+			}
+			ctx, span := startRun(ctx, trigger)
+			defer func() { tracing.End(span, err) }()
+			run := runs.Run{ID: dataprocessor.NewRunID(), Trigger: trigger, StartedAt: time.Now().UTC()}
+			report, err := syncer.process(ctx, data)
+			if errors.Is(err, errLeaseHeld) {
+				logger.Log("info", err)
+				return nil
+			}
+			run.Status, run.FinishedAt, run.Datasets = report.Status, time.Now().UTC(), []runs.Dataset{report}
+			syncer.record(ctx, run)
+			if err != nil && ctx.Err() != nil {
+				atomic.StoreInt32(interrupted, 1)
+			}
+			return err
+		},
+	}
+}
+
+// serverShutdownTimeout limits the time spent finishing the requests of an http server on shutdown.
+const serverShutdownTimeout = 10 * time.Second
+
+// startAdmin serves the admin API on the configured address.
+func startAdmin(s *settings, sched *scheduler.Scheduler, syncer *syncer, history runs.Store) (*http.Server, error) {
+	token, err := s.Admin.BearerToken()
+	if err != nil {
+		return nil, err
+	}
+	return startServer("admin api", s.Admin.Addr, admin.NewHandler(token, sched, syncer, history))
+}
+
+// startServer serves handler on addr in the background.
+func startServer(name string, addr string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for the %s: %w", name, err)
+	}
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logger.Log("info", fmt.Sprintf("Serving the %s on %s", name, listener.Addr()))
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Log("error", fmt.Errorf("error serving the %s: %w", name, err))
+		}
+	}()
+	return server, nil
+}
+
+// stopServer stops server after finishing the running requests.
+func stopServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Log("error", fmt.Errorf("error stopping http server: %w", err))
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nhe23/aq-dbsync/pkg/readapi"
+)
+
+// runServeAPI serves the read api from the database until a shutdown signal is received.
+func runServeAPI(args []string) int {
+	fs := flag.NewFlagSet("serve-api", flag.ExitOnError)
+	s, err := registerSettings(fs, args)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	fs.StringVar(&s.ReadAPI.Addr, "read-api-addr", s.ReadAPI.Addr, "Address of the read api")
+	if err := s.parse(fs, args); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	st, err := openStore(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer st.disconnect(s.Mongo.ConnectTimeout)
+
+	server, err := startServer("read api", s.ReadAPI.Addr, readapi.NewHandler(readapi.Repositories{
+		Locations: st.repos[measurementsColName],
+		Cities:    st.repos[citiesColName],
+		Countries: st.repos[countriesColName],
+	}))
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+
+	<-ctx.Done()
+	logger.Log("info", "Shutdown requested, waiting for running requests to finish")
+	stopServer(server)
+	logger.Log("info", "Service stopped")
+	return exitOK
+}
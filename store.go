@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/backfill"
+	"github.com/nhe23/aq-dbsync/pkg/config"
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"github.com/nhe23/aq-dbsync/pkg/lease"
+	"github.com/nhe23/aq-dbsync/pkg/migrate"
+	"github.com/nhe23/aq-dbsync/pkg/rollup"
+	"github.com/nhe23/aq-dbsync/pkg/runs"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"github.com/nhe23/aq-dbsync/pkg/storage/mongostore"
+	"github.com/nhe23/aq-dbsync/pkg/storage/sqlitestore"
+	"github.com/nhe23/aq-dbsync/pkg/syncstate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// store holds the repositories of the datasets and the sync bookkeeping of the storage backend.
+type store struct {
+	repos       map[string]storage.Repository
+	state       syncstate.Store
+	checkpoints dataprocessor.CheckpointStore
+	leases      lease.Store
+	// migrations are the migrations of the backend, their applied records are kept in migrationRecords.
+	migrations       []migrate.Migration
+	migrationRecords migrate.Store
+	// The history and rollups are only supported by the mongo backend and nil otherwise.
+	history             backfill.HistoryStore
+	historyReader       backfill.HistoryReader
+	backfillCheckpoints backfill.CheckpointStore
+	rollups             rollup.Store
+	// runs is the history of sync runs, nil if disabled.
+	runs runs.Store
+	// missingSchema lists the indexes or tables openStore creates that are missing.
+	missingSchema func(ctx context.Context) ([]string, error)
+	close         func(ctx context.Context) error
+}
+
+// openStore connects to the configured storage backend and creates its missing collections,
+// indexes and tables.
+func openStore(ctx context.Context, s *settings) (*store, error) {
+	return connectStore(ctx, s, true)
+}
+
+// openExistingStore connects to the configured storage backend without changing its schema. The
+// sqlite database is opened read-only and must exist.
+func openExistingStore(ctx context.Context, s *settings) (*store, error) {
+	return connectStore(ctx, s, false)
+}
+
+func connectStore(ctx context.Context, s *settings, createSchema bool) (*store, error) {
+	connectCtx, cancel := context.WithTimeout(ctx, s.Mongo.ConnectTimeout)
+	defer cancel()
+	if s.Storage.Backend == config.BackendSQLite {
+		return openSQLite(connectCtx, s, createSchema)
+	}
+	return openMongo(connectCtx, s, createSchema)
+}
+
+func openMongo(ctx context.Context, s *settings, createSchema bool) (*store, error) {
+	clientOpts, err := s.Mongo.ClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	client, cols, err := initCollections(ctx, clientOpts, s, createSchema)
+	if client != nil && err != nil {
+		client.Disconnect(context.WithoutCancel(ctx))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error initializing mongo collections: %w", err)
+	}
+	st := &store{
+		repos: map[string]storage.Repository{
+			citiesColName:       mongostore.NewCities(cols.citiesCol.col),
+			countriesColName:    mongostore.NewCountries(cols.countriesCol.col),
+			measurementsColName: mongostore.NewLocations(cols.measurementCol.col),
+		},
+		state:               syncstate.NewMongoStore(cols.syncStateCol.col),
+		checkpoints:         syncstate.NewMongoCheckpoints(cols.checkpointsCol.col),
+		leases:              lease.NewMongoStore(cols.leasesCol.col),
+		migrations:          mongostore.Migrations(cols.citiesCol.col, cols.countriesCol.col, cols.measurementCol.col),
+		migrationRecords:    migrate.NewMongoStore(client.Database(s.Mongo.Database).Collection(migrationsColName)),
+		history:             cols.historyCol.col,
+		historyReader:       backfill.NewMongoHistory(cols.historyCol.col),
+		backfillCheckpoints: backfill.NewMongoCheckpoints(client.Database(s.Mongo.Database).Collection(backfillCheckpointsColName)),
+		rollups:             rollup.NewMongoStore(cols.hourlyCol.col, cols.dailyCol.col),
+		missingSchema: func(ctx context.Context) ([]string, error) {
+			return missingIndexes(ctx, cols, s)
+		},
+		close: client.Disconnect,
+	}
+	if s.Runs.Enabled {
+		st.runs = runs.NewMongoStore(cols.runsCol.col)
+	}
+	return st, nil
+}
+
+func openSQLite(ctx context.Context, s *settings, createSchema bool) (*store, error) {
+	open := sqlitestore.Open
+	if !createSchema {
+		open = sqlitestore.OpenExisting
+	}
+	db, err := open(ctx, s.Storage.SQLitePath)
+	if err != nil {
+		return nil, err
+	}
+	st := &store{
+		repos: map[string]storage.Repository{
+			citiesColName:       db.Cities(),
+			countriesColName:    db.Countries(),
+			measurementsColName: db.Locations(),
+		},
+		state:            db.SyncState(),
+		checkpoints:      db.Checkpoints(),
+		leases:           db.Leases(),
+		migrations:       db.Migrations(),
+		migrationRecords: db.MigrationRecords(),
+		missingSchema:    db.MissingSchema,
+		close: func(ctx context.Context) error {
+			return db.Close()
+		},
+	}
+	if s.Runs.Enabled {
+		st.runs = db.Runs(s.Runs.Retention)
+	}
+	return st, nil
+}
+
+// namespaceNotFound is the code of the error of listing the indexes of a missing collection.
+const namespaceNotFound = 26
+
+// missingIndexes lists the indexes of the collections that initCollections creates and are
+// missing as collection.index.
+func missingIndexes(ctx context.Context, cols collections, s *settings) ([]string, error) {
+	indexes := cols.indexes()
+	if s.Runs.Enabled {
+		indexes = append(indexes, collectionIndex{cols.runsCol, runs.RetentionIndex(s.Runs.Retention)})
+	}
+	var missing []string
+	for _, index := range indexes {
+		names, err := indexNames(ctx, index.col.col)
+		if err != nil {
+			return nil, fmt.Errorf("error listing the indexes of %s: %w", index.col.name, err)
+		}
+		if name := indexName(index.model.Keys.(bson.D)); !names[name] {
+			missing = append(missing, index.col.name+"."+name)
+		}
+	}
+	return missing, nil
+}
+
+// indexNames returns the names of the indexes of col, none if col does not exist.
+func indexNames(ctx context.Context, col *mongo.Collection) (map[string]bool, error) {
+	names := make(map[string]bool)
+	cursor, err := col.Indexes().List(ctx)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == namespaceNotFound {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var index struct {
+			Name string `bson:"name"`
+		}
+		if err := cursor.Decode(&index); err != nil {
+			return nil, err
+		}
+		names[index.Name] = true
+	}
+	return names, cursor.Err()
+}
+
+// indexName returns the name mongo gives an index of keys.
+func indexName(keys bson.D) string {
+	parts := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// disconnect closes the store within timeout.
+func (st *store) disconnect(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := st.close(ctx); err != nil {
+		logger.Log("error", fmt.Errorf("error closing storage: %w", err))
+	}
+}
+
+// newMigrator returns the migrator of the store, which holds a lease while migrating if leader
+// election is enabled.
+func (st *store) newMigrator(s *settings) (*migrate.Migrator, error) {
+	var lock *lease.Lock
+	if s.Lease.Enabled {
+		lock = lease.NewLock(st.leases, s.Lease.Owner, s.Lease.TTL)
+	}
+	return migrate.NewMigrator(st.migrationRecords, lock, st.migrations)
+}
+
+// migrateOnStartup applies the pending migrations if migrations on startup are enabled.
+func (st *store) migrateOnStartup(ctx context.Context, s *settings) error {
+	if !s.Migrations.OnStartup {
+		return nil
+	}
+	migrator, err := st.newMigrator(s)
+	if err != nil {
+		return err
+	}
+	records, err := migrator.Run(ctx)
+	for _, r := range records {
+		logger.Log("info", fmt.Sprintf("Applied migration %d: %s", r.Version, r.Description))
+	}
+	if err != nil {
+		return fmt.Errorf("error migrating: %w", err)
+	}
+	return nil
+}
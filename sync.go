@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"github.com/nhe23/aq-dbsync/pkg/dryrun"
+	"github.com/nhe23/aq-dbsync/pkg/runs"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Statuses of a sync and of its datasets in the summary.
+const (
+	statusOK          = "ok"
+	statusFailed      = "failed"
+	statusTimeout     = "timeout"
+	statusInterrupted = "interrupted"
+	statusSkipped     = "skipped"
+	statusLocked      = "locked"
+)
+
+// commandSummary is the status of a command that runs once, printed with its result.
+type commandSummary struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// finish sets the status of a command that stopped with err and returns the matching exit code.
+func (c *commandSummary) finish(ctx context.Context, err error) int {
+	if err == nil {
+		c.Status = statusOK
+		return exitOK
+	}
+	logger.Log("error", err)
+	c.Error = err.Error()
+	if ctx.Err() != nil {
+		c.Status = statusInterrupted
+		return exitInterrupted
+	}
+	c.Status = statusFailed
+	return exitSyncFailed
+}
+
+// printSummary prints the summary of a command as JSON to stdout and returns code.
+func printSummary(summary interface{}, code int) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(summary)
+	return code
+}
+
+// syncSummary is the run of the sync command.
+type syncSummary struct {
+	runs.Run
+	// DryRun holds the planned operations of a dry run.
+	DryRun []dryrun.Plan `json:"dryRun,omitempty"`
+}
+
+// runSync syncs the selected datasets once and prints a JSON summary to stdout.
+func runSync(args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	s, err := registerSettings(fs, args)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	var (
+		once          = fs.Bool("once", true, "Sync once and exit, otherwise keep syncing on the dataset schedules")
+		dryRun        = fs.Bool("dry-run", false, "Fetch and transform the datasets but only print the planned database operations")
+		dryRunFormat  = fs.String("dry-run-format", "text", "Output format of a dry run, text or json")
+		dryRunSamples = fs.Int("dry-run-samples", 5, "Number of planned operations shown per collection in a dry run")
+	)
+	if err := s.parse(fs, args); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	if *dryRun && *dryRunFormat != "text" && *dryRunFormat != "json" {
+		logger.Log("err", fmt.Errorf("invalid dry-run-format %q, must be text or json", *dryRunFormat))
+		return exitInitError
+	}
+	if !*once {
+		if *dryRun {
+			logger.Log("err", fmt.Errorf("dry-run requires once"))
+			return exitInitError
+		}
+		return serve(s, true)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopTracing, err := startTracing(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer stopTracing()
+
+	open := openStore
+	if *dryRun {
+		open = openExistingStore
+	}
+	st, err := open(ctx, s)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	defer st.disconnect(s.Mongo.ConnectTimeout)
+	var recorders map[string]*dryrun.Recorder
+	if *dryRun {
+		recorders = dryRunStore(s, st, *dryRunSamples)
+	} else if err := st.migrateOnStartup(ctx, s); err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+	syncer, dataParams, err := newDataParams(s, st)
+	if err != nil {
+		logger.Log("err", err)
+		return exitInitError
+	}
+
+	summary := syncSummary{Run: runs.Run{ID: dataprocessor.NewRunID(), Trigger: triggerCommand, StartedAt: time.Now().UTC()}}
+	ctx, span := startRun(ctx, triggerCommand)
+	for _, data := range dataParams {
+		if ctx.Err() != nil {
+			summary.Datasets = append(summary.Datasets, runs.Dataset{Name: data.name, Status: statusSkipped})
+			continue
+		}
+		runCtx, cancel := context.WithTimeout(ctx, s.Timeout(data.name))
+		dataSummary, err := syncer.process(runCtx, data)
+		cancel()
+		if err != nil {
+			logger.Log("error", err)
+		}
+		summary.Datasets = append(summary.Datasets, dataSummary)
+	}
+	summary.FinishedAt = time.Now().UTC()
+
+	code := summary.finish()
+	span.SetAttributes(attribute.String("status", summary.Status))
+	span.End()
+	if !*dryRun {
+		syncer.record(ctx, summary.Run)
+	}
+	if *dryRun {
+		summary.DryRun = dryRunPlans(recorders, dataParams)
+	}
+	if *dryRun && *dryRunFormat == "text" {
+		summary.writeText(os.Stdout)
+		return code
+	}
+	return printSummary(summary, code)
+}
+
+// finish sets the overall status of the summary and returns the matching exit code.
+func (s *syncSummary) finish() int {
+	s.Status = statusOK
+	code := exitOK
+	for _, d := range s.Datasets {
+		switch d.Status {
+		case statusInterrupted, statusSkipped:
+			s.Status = statusInterrupted
+			return exitInterrupted
+		case statusFailed, statusTimeout:
+			s.Status = statusFailed
+			code = exitSyncFailed
+		}
+	}
+	return code
+}
+
+// dryRunStore replaces the repositories of st by recorders of the planned operations and keeps
+// the sync bookkeeping and the archive from being written.
+func dryRunStore(s *settings, st *store, samples int) map[string]*dryrun.Recorder {
+	recorders := make(map[string]*dryrun.Recorder)
+	for name, repo := range st.repos {
+		recorders[name] = dryrun.NewRecorder(name, repo, samples)
+		st.repos[name] = recorders[name]
+	}
+	st.state = dryrun.ReadOnlyState(st.state)
+	st.checkpoints = nil
+	st.runs = nil
+	s.Lease.Enabled = false
+	s.Rollups.Enabled = false
+	s.AirQuality.Enabled = false
+	s.Archive.Enabled = false
+	return recorders
+}
+
+// dryRunPlans returns the plans of the collections the datasets are written to, in sync order.
+// Datasets of several sources can share a collection, whose plan is only returned once.
+func dryRunPlans(recorders map[string]*dryrun.Recorder, dataParams []dataProcessParams) []dryrun.Plan {
+	var plans []dryrun.Plan
+	seen := make(map[string]bool)
+	for _, data := range dataParams {
+		name := kindCollections[data.kind]
+		recorder, ok := recorders[name]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		plans = append(plans, recorder.Plan())
+	}
+	return plans
+}
+
+// writeText writes the summary of a dry run in a human readable form.
+func (s *syncSummary) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Dry run %s\n", s.Status)
+	for _, d := range s.Datasets {
+		if d.Error != "" {
+			fmt.Fprintf(w, "%s: %s: %s\n", d.Name, d.Status, d.Error)
+		}
+	}
+	dryrun.WriteText(w, s.DryRun)
+}
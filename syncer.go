@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nhe23/aq-dbsync/pkg/admin"
+	"github.com/nhe23/aq-dbsync/pkg/airquality"
+	"github.com/nhe23/aq-dbsync/pkg/archive"
+	"github.com/nhe23/aq-dbsync/pkg/dataprocessor"
+	"github.com/nhe23/aq-dbsync/pkg/lease"
+	"github.com/nhe23/aq-dbsync/pkg/rollup"
+	"github.com/nhe23/aq-dbsync/pkg/runs"
+	"github.com/nhe23/aq-dbsync/pkg/source"
+	"github.com/nhe23/aq-dbsync/pkg/storage"
+	"github.com/nhe23/aq-dbsync/pkg/syncstate"
+	"github.com/nhe23/aq-dbsync/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errLeaseHeld is returned when a dataset is not synced because another replica syncs it.
+var errLeaseHeld = errors.New("lease held by another replica")
+
+// syncer syncs datasets and keeps track of their sync state.
+type syncer struct {
+	state    syncstate.Store
+	settings *settings
+	// lock guards datasets against concurrent syncs of other replicas, nil if leader election is disabled.
+	lock *lease.Lock
+	// archiver archives the api responses, nil if archiving is disabled.
+	archiver *archive.Archiver
+	// rollups aggregates the synced measurements, nil if rollups are disabled.
+	rollups *rollup.Rollupper
+	// airQuality updates the air quality of cities and countries, nil if disabled.
+	airQuality *airquality.Updater
+	// runs keeps the history of sync runs, nil if disabled.
+	runs runs.Store
+
+	mu sync.Mutex
+	// running holds the running syncs by dataset.
+	running map[string]runningSync
+}
+
+// runningSync is a running sync of a dataset.
+type runningSync struct {
+	startedAt time.Time
+	progress  *dataprocessor.Progress
+}
+
+// Running returns the running syncs with their progress.
+func (s *syncer) Running() []admin.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]admin.Job, 0, len(s.running))
+	for name, r := range s.running {
+		page, pages := r.progress.Pages()
+		jobs = append(jobs, admin.Job{Dataset: name, StartedAt: r.startedAt, Page: page, Pages: pages})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Dataset < jobs[j].Dataset })
+	return jobs
+}
+
+// track adds a running sync of dataset until the returned function is called.
+func (s *syncer) track(dataset string, startedAt time.Time, progress *dataprocessor.Progress) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running == nil {
+		s.running = make(map[string]runningSync)
+	}
+	s.running[dataset] = runningSync{startedAt: startedAt, progress: progress}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.running, dataset)
+	}
+}
+
+// Triggers of sync runs.
+const (
+	triggerCommand  = "command"
+	triggerSchedule = "schedule"
+	triggerAdmin    = "admin"
+	triggerReplay   = "replay"
+)
+
+// startRun starts the span of a sync run, which contains the spans of its datasets.
+func startRun(ctx context.Context, trigger string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "run", trace.WithAttributes(attribute.String("trigger", trigger)))
+}
+
+// process syncs a dataset while holding its lease and reports the pages and documents written,
+// including those written before an error.
+func (s *syncer) process(ctx context.Context, data dataProcessParams) (runs.Dataset, error) {
+	report := runs.Dataset{Name: data.name, StartedAt: time.Now().UTC()}
+	ctx, span := tracing.Tracer().Start(ctx, "dataset", trace.WithAttributes(attribute.String("dataset", data.name)))
+	counter := storage.NewCounter(data.repo)
+	data.repo = counter
+	progress := &dataprocessor.Progress{}
+	untrack := s.track(data.name, report.StartedAt, progress)
+	err := s.hold(dataprocessor.WithProgress(ctx, progress), data)
+	untrack()
+
+	report.DurationSeconds = time.Since(report.StartedAt).Seconds()
+	report.Status = datasetStatus(ctx, err)
+	report.Pages, _ = progress.Pages()
+	result := counter.Result()
+	report.SetResult(result)
+	logRejected(data.name, result.Rejected)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	span.SetAttributes(
+		attribute.Int("pages", report.Pages),
+		attribute.Int64("matched", report.Matched),
+		attribute.Int64("modified", report.Modified),
+		attribute.Int64("upserted", report.Upserted),
+		attribute.Int("rejected", report.Rejected),
+	)
+	tracing.End(span, err)
+	return report, err
+}
+
+// hold syncs a dataset while holding its lease. The derived data of measurements is updated
+// before the lease is released, so no other replica syncs the dataset in the meantime.
+func (s *syncer) hold(ctx context.Context, data dataProcessParams) error {
+	defer s.pruneArchive()
+	if s.lock == nil {
+		return s.syncAndUpdate(ctx, data)
+	}
+	heldCtx, release, acquired, err := s.lock.Hold(ctx, data.name)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return fmt.Errorf("not syncing %s: %w", data.name, errLeaseHeld)
+	}
+	defer release()
+	return s.syncAndUpdate(heldCtx, data)
+}
+
+// syncAndUpdate syncs a dataset and updates the data derived from measurements.
+func (s *syncer) syncAndUpdate(ctx context.Context, data dataProcessParams) error {
+	if data.kind == source.Locations {
+		defer s.afterMeasurements(ctx)
+	}
+	return s.sync(ctx, data)
+}
+
+// datasetStatus returns the status of a dataset whose sync with ctx returned err.
+func datasetStatus(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return statusOK
+	case errors.Is(err, errLeaseHeld):
+		return statusLocked
+	case errors.Is(ctx.Err(), context.Canceled):
+		return statusInterrupted
+	case errors.Is(err, context.DeadlineExceeded):
+		return statusTimeout
+	default:
+		return statusFailed
+	}
+}
+
+// record saves run to the history of runs if it is enabled. Errors are only logged because the
+// run is over.
+func (s *syncer) record(ctx context.Context, run runs.Run) {
+	if s.runs == nil {
+		return
+	}
+	if err := s.runs.Save(context.WithoutCancel(ctx), run); err != nil {
+		logger.Log("error", fmt.Errorf("error saving run %s: %w", run.ID, err))
+	}
+}
+
+// logRejected logs the documents of a dataset the storage rejected, e.g. because they failed validation.
+func logRejected(name string, rejected []storage.Rejection) {
+	for _, r := range rejected {
+		logger.Log("error", fmt.Sprintf("Rejected %s document %s: %s", name, r.Key, r.Reason))
+	}
+}
+
+// sync syncs a dataset. Incremental datasets only request data newer than the watermark of the
+// previous sync, unless a full sync is due. A full sync deletes documents that were not synced.
+// Datasets with staleAfter delete the documents not synced for that long after every sync.
+func (s *syncer) sync(ctx context.Context, data dataProcessParams) error {
+	if !data.incremental {
+		start := time.Now().UTC()
+		if err := s.processURL(ctx, data, data.source.URL(data.name, time.Time{})); err != nil {
+			return err
+		}
+		if data.staleAfter > 0 {
+			return s.deleteStale(context.WithoutCancel(ctx), data, start.Add(-data.staleAfter))
+		}
+		return nil
+	}
+
+	state, err := s.state.Load(ctx, data.name)
+	if err != nil {
+		return fmt.Errorf("error loading sync state of %s: %w", data.name, err)
+	}
+	start := time.Now().UTC()
+	full := !s.settings.Sync.Incremental || state.NeedsFullSync(start, s.settings.Sync.FullSyncInterval)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("full", full))
+	watermark := dataprocessor.NewWatermark(time.Time{})
+	if !full {
+		watermark.Since = state.Since(s.settings.Sync.WatermarkOverlap)
+	}
+	dataURL := data.source.URL(data.name, watermark.Since)
+	if err := s.processURL(dataprocessor.WithWatermark(ctx, watermark), data, dataURL); err != nil {
+		return err
+	}
+
+	// The sync succeeded, so the state is saved even if a shutdown was requested in the meantime.
+	ctx = context.WithoutCancel(ctx)
+	if full {
+		// A resumed sync wrote its first pages before start, so only documents older than the
+		// resumed run are stale.
+		cutoff := start
+		if startedAt := dataprocessor.ProgressFromContext(ctx).StartedAt(); !startedAt.IsZero() && startedAt.Before(cutoff) {
+			cutoff = startedAt
+		}
+		if err := s.deleteStale(ctx, data, cutoff); err != nil {
+			return err
+		}
+		state.LastFullSync = cutoff
+	}
+	if max := watermark.Max(); max.After(state.Watermark) {
+		state.Watermark = max
+	}
+	state.LastSuccess = start
+	if err := s.state.Save(ctx, state); err != nil {
+		return fmt.Errorf("error saving sync state of %s: %w", data.name, err)
+	}
+	return nil
+}
+
+// deleteStale deletes the documents of the source of a dataset that were not synced since before.
+func (s *syncer) deleteStale(ctx context.Context, data dataProcessParams, before time.Time) error {
+	deleted, err := data.repo.DeleteStale(ctx, data.source.ID(), before)
+	if err != nil {
+		return fmt.Errorf("error deleting stale %s: %w", data.name, err)
+	}
+	logger.Log("info", fmt.Sprintf("Sync of %s deleted %d stale documents", data.name, deleted))
+	return nil
+}
+
+// pruneArchive applies the retention of the archive after a sync.
+func (s *syncer) pruneArchive() {
+	if s.archiver == nil {
+		return
+	}
+	deleted, err := s.archiver.Prune(context.Background())
+	if err != nil {
+		logger.Log("error", err)
+		return
+	}
+	if deleted > 0 {
+		logger.Log("info", fmt.Sprintf("Pruned %d objects from the archive", deleted))
+	}
+}
+
+// afterMeasurements recomputes the rollups and the air quality from the measurements synced so
+// far. Pages synced before an error or a shutdown are included.
+func (s *syncer) afterMeasurements(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+	if s.rollups != nil {
+		result, err := s.rollups.Flush(ctx)
+		if err != nil {
+			logger.Log("error", err)
+		} else {
+			logger.Log("info", fmt.Sprintf("Recomputed %d rollups of %d days", result.Rollups, result.Days))
+		}
+	}
+	if s.airQuality != nil {
+		result, err := s.airQuality.Update(ctx)
+		if err != nil {
+			logger.Log("error", fmt.Errorf("error updating air quality: %w", err))
+		} else {
+			logger.Log("info", fmt.Sprintf("Updated the air quality of %d cities and %d countries", result.Cities, result.Countries))
+		}
+	}
+}
+
+func (s *syncer) processURL(ctx context.Context, data dataProcessParams, dataURL string) error {
+	logger.Log("info", fmt.Sprintf("Processing data for %s", dataURL))
+	// The errors of ProcessData already name the url.
+	return data.processor.ProcessData(ctx, data.name, dataURL, data.repo, data.processor.Process(data.source, data.name))
+}